@@ -3,6 +3,7 @@ package conf
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,8 +34,67 @@ var (
 	DBMaxIdle int
 	DBMaxConn int
 	DBDebug   bool
+
+	// sms
+	SmsProviderPriority         []string      // 短信服务商发送优先级，服务商编码列表
+	SmsOutboxWorkers            int           // 短信发件箱后台发送协程数
+	SmsOutboxVisibilityTimeout  time.Duration // 短信被取出发送后，超过该时间未完成则重新发送
+	SmsOutboxPollInterval       time.Duration // 发件箱无待发送短信时的轮询间隔
+	SmsRetryMaxAttempts         int           // 暂时性错误发送失败后，最多发送次数(含首次)
+	SmsRetryBaseInterval        time.Duration // 重新发送的初始退避时间，每次失败后翻倍
+	SmsRetryMaxInterval         time.Duration // 重新发送的最大退避时间
+	SmsMarketingSendWindow      string        // 营销短信默认发送时间窗口，如：08:00-21:00，为空不限制
+	SmsUnsubscribeKeywords      []string      // 上行短信退订关键字，不区分大小写
+	SmsSensitiveWordAction      string        // 短信包含敏感词时的处理方式：reject: 拒绝发送；mask: 替换为*后发送
+	SmsSensitiveWordRemoteCheck bool          // 本地未匹配到敏感词时，是否再调用云片网检查屏蔽词
+	SmsAllowInternational       bool          // 是否允许发送国际号码，不允许时只发送中国大陆手机号
+	SmsVerificationCodeLength   int           // 短信验证码长度
+	SmsVerificationCodeCharset  string        // 短信验证码字符集：numeric: 数字；alphanumeric: 数字和大写字母；其他为自定义字符集
+	SmsVerificationCodeTTL      time.Duration // 短信验证码有效期
+	SmsVerificationMaxAttempts  int           // 每个短信验证码最多校验次数，超过后验证码失效
+	SmsVoiceFallback            bool          // 验证码短信未送达(EXPIRED、UNDELIV)时，是否改为语音验证码
+	SmsVoiceAccount             string        // 创蓝语音验证码账号，为空不开通语音验证码
+	SmsVoicePassword            string        // 创蓝语音验证码密码
+	SmsVoiceHttpApi             string        // 创蓝语音验证码HTTP API
+	SmsAdminCompanyIds          []int         // 平台管理员公司ID，只有这些公司可以修改平台级配置，如短信路由规则
+	SmsTrustedProxies           []string      // 可信反向代理IP或者CIDR，只有来源地址为可信代理时才采用X-Forwarded-For
+
+	// 短信验证码获取频率限制，滑动窗口
+	SmsVerificationMobileLimits  []RateLimit   // 每个手机号
+	SmsVerificationIpLimits      []RateLimit   // 每个客户端IP
+	SmsVerificationDeviceLimits  []RateLimit   // 每个设备指纹
+	SmsVerificationCaptchaLimit  RateLimit     // 所有请求超过该频率时开启图形验证，Limit为0不开启
+	SmsVerificationCaptchaPeriod time.Duration // 开启图形验证后的持续时间
+	SmsVerificationCaptchaApi    string        // 图形验证码校验接口地址
 )
 
+// 频率限制：Window时间内最多Limit次
+type RateLimit struct {
+	Window time.Duration
+	Limit  int
+}
+
+// 解析频率限制配置，格式：窗口秒数:次数，多个以英文逗号分隔，如：60:1,86400:10
+func parseRateLimits(value string) (limits []RateLimit) {
+	limits = []RateLimit{}
+	for _, item := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(item), ":")
+		if len(fields) != 2 {
+			continue
+		}
+		window, windowErr := strconv.Atoi(strings.TrimSpace(fields[0]))
+		limit, limitErr := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if windowErr != nil || limitErr != nil || window <= 0 || limit <= 0 {
+			continue
+		}
+		limits = append(limits, RateLimit{
+			Window: time.Duration(window) * time.Second,
+			Limit:  limit,
+		})
+	}
+	return
+}
+
 func initRpcEnv() {
 	EtcdAddr = strings.TrimSpace(beego.AppConfig.String("etcd::address"))
 	RpcAddr = strings.TrimSpace(beego.AppConfig.String("rpc::address"))
@@ -46,6 +106,60 @@ func initRpcEnv() {
 	return
 }
 
+func initSmsEnv() {
+	SmsProviderPriority = []string{}
+	for _, code := range strings.Split(beego.AppConfig.String("sms::provider_priority"), ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			SmsProviderPriority = append(SmsProviderPriority, code)
+		}
+	}
+	SmsOutboxWorkers = beego.AppConfig.DefaultInt("sms::outbox_workers", 4)
+	SmsOutboxVisibilityTimeout = time.Duration(beego.AppConfig.DefaultInt("sms::outbox_visibility_timeout", 60)) * time.Second
+	SmsOutboxPollInterval = time.Duration(beego.AppConfig.DefaultInt("sms::outbox_poll_interval", 1000)) * time.Millisecond
+	SmsRetryMaxAttempts = beego.AppConfig.DefaultInt("sms::retry_max_attempts", 5)
+	SmsRetryBaseInterval = time.Duration(beego.AppConfig.DefaultInt("sms::retry_base_interval", 10)) * time.Second
+	SmsRetryMaxInterval = time.Duration(beego.AppConfig.DefaultInt("sms::retry_max_interval", 600)) * time.Second
+	SmsMarketingSendWindow = strings.Replace(beego.AppConfig.String("sms::marketing_send_window"), " ", "", -1)
+	SmsSensitiveWordAction = strings.TrimSpace(beego.AppConfig.DefaultString("sms::sensitive_word_action", "reject"))
+	SmsSensitiveWordRemoteCheck = beego.AppConfig.DefaultBool("sms::sensitive_word_remote_check", false)
+	SmsAllowInternational = beego.AppConfig.DefaultBool("sms::allow_international", false)
+	SmsVerificationCodeLength = beego.AppConfig.DefaultInt("sms::verification_code_length", 6)
+	SmsVerificationCodeCharset = strings.TrimSpace(beego.AppConfig.DefaultString("sms::verification_code_charset", "numeric"))
+	SmsVerificationCodeTTL = time.Duration(beego.AppConfig.DefaultInt("sms::verification_code_ttl", 600)) * time.Second
+	SmsVerificationMaxAttempts = beego.AppConfig.DefaultInt("sms::verification_max_attempts", 5)
+	SmsVoiceFallback = beego.AppConfig.DefaultBool("sms::verification_voice_fallback", false)
+	SmsVoiceAccount = strings.TrimSpace(beego.AppConfig.String("sms::voice_account"))
+	SmsVoicePassword = strings.TrimSpace(beego.AppConfig.String("sms::voice_password"))
+	SmsVoiceHttpApi = strings.TrimSpace(beego.AppConfig.String("sms::voice_http_api"))
+	SmsVerificationMobileLimits = parseRateLimits(beego.AppConfig.DefaultString("sms::verification_limit_mobile", "60:1,86400:10"))
+	SmsVerificationIpLimits = parseRateLimits(beego.AppConfig.DefaultString("sms::verification_limit_ip", "60:10,86400:200"))
+	SmsVerificationDeviceLimits = parseRateLimits(beego.AppConfig.DefaultString("sms::verification_limit_device", "60:2,86400:20"))
+	if captchaLimits := parseRateLimits(beego.AppConfig.String("sms::verification_captcha_threshold")); len(captchaLimits) > 0 {
+		SmsVerificationCaptchaLimit = captchaLimits[0]
+	}
+	SmsVerificationCaptchaPeriod = time.Duration(beego.AppConfig.DefaultInt("sms::verification_captcha_period", 600)) * time.Second
+	SmsVerificationCaptchaApi = strings.TrimSpace(beego.AppConfig.String("sms::verification_captcha_api"))
+	SmsAdminCompanyIds = []int{}
+	for _, value := range strings.Split(beego.AppConfig.String("sms::admin_company_ids"), ",") {
+		if companyId, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && companyId > 0 {
+			SmsAdminCompanyIds = append(SmsAdminCompanyIds, companyId)
+		}
+	}
+	SmsTrustedProxies = []string{}
+	for _, value := range strings.Split(beego.AppConfig.String("sms::trusted_proxies"), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			SmsTrustedProxies = append(SmsTrustedProxies, value)
+		}
+	}
+	SmsUnsubscribeKeywords = []string{}
+	for _, keyword := range strings.Split(beego.AppConfig.DefaultString("sms::unsubscribe_keywords", "TD,T,退订,STOP"), ",") {
+		if keyword = strings.ToUpper(strings.TrimSpace(keyword)); keyword != "" {
+			SmsUnsubscribeKeywords = append(SmsUnsubscribeKeywords, keyword)
+		}
+	}
+	return
+}
+
 func connRpcClient(appName string) (client *rpcx.Client) {
 	s := clientselector.NewEtcdClientSelector([]string{EtcdAddr}, fmt.Sprintf("/%s/%s/%s", beego.BConfig.RunMode, "rpcx", appName), time.Minute, rpcx.RandomSelect, time.Minute)
 	client = rpcx.NewClient(s)
@@ -72,6 +186,9 @@ func init() {
 	}
 	OfficialAccountClient = connRpcClient(name)
 
+	// 初始化短信服务商配置
+	initSmsEnv()
+
 	// 1. connect mysql
 	DBHost = strings.TrimSpace(beego.AppConfig.String("db::host"))
 	if "" == DBHost {
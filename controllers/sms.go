@@ -3,13 +3,15 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
-	"time"
+	"strings"
 
 	utils "github.com/1046102779/common"
 	. "github.com/1046102779/common/utils"
 	. "github.com/1046102779/sms/logger"
 	"github.com/1046102779/sms/models"
+	"github.com/1046102779/sms/phone"
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 )
@@ -20,7 +22,7 @@ const (
 	CUSTOM_GROUPS_TYPE   = 3
 
 	// 错误码
-	SMS_MASS_SEND_BLACKWORDS = 12030 // 屏蔽词过滤
+	SMS_MASS_SEND_BLACKWORDS = models.SMS_MASS_SEND_BLACKWORDS // 屏蔽词过滤
 
 	ACTIVITY_TYPE_QUESTIONNAIRE = 4
 
@@ -33,14 +35,24 @@ type SmsController struct {
 }
 
 // 生成验证码，并把验证码保存到redis，且发送短信验证码
+/*
+	>>	purpose: 验证码用途，login: 登录(默认)；register: 注册；reset_password: 重置密码；bind_mobile: 绑定手机号
+	>>	验证码长度、字符集和有效期见配置sms::verification_code_*，短信发送失败时验证码失效
+	>>	按客户端IP、设备指纹(device_id或者请求头X-Device-Id)、手机号限制获取频率，超过时返回retry_after(秒)；
+		流量突增时需要图形验证码captcha_token
+	>>	channel: 验证码通道，sms: 短信(默认)；voice: 语音电话播报
+*/
 // @router /mobile_verification_code [post]
 func (t *SmsController) MobileVerificationCode() {
 	type MobileInfo struct {
-		Mobile string `json:"mobile"`
+		Mobile       string `json:"mobile"`
+		Purpose      string `json:"purpose"`
+		DeviceId     string `json:"device_id"`
+		CaptchaToken string `json:"captcha_token"`
+		Channel      string `json:"channel"`
 	}
 	var (
 		mobiles []string
-		key     string
 		info    *MobileInfo = new(MobileInfo)
 	)
 	if err := json.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
@@ -52,25 +64,76 @@ func (t *SmsController) MobileVerificationCode() {
 		t.ServeJSON()
 		return
 	}
-
-	// 生成四位验证码
-	code := GetRandomString(4)
-	key = fmt.Sprintf("SMS:%s:LOGIN", info.Mobile)
-	if err := RedisClient.Set(key, code, 600*time.Second).Err(); err != nil {
-		Logger.Error("set redis failed. " + err.Error())
+	channel, retcode, err := models.GetSmsVerificationChannel(info.Channel)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if info.DeviceId == "" {
+		info.DeviceId = t.Ctx.Input.Header("X-Device-Id")
+	}
+	// 图形验证码和获取频率限制
+	if retcode, err := models.CheckSmsVerificationLimits(&models.SmsVerificationClient{
+		Mobile:       info.Mobile,
+		Ip:           models.GetClientIp(t.Ctx.Request.RemoteAddr, t.Ctx.Request.Header["X-Forwarded-For"]),
+		DeviceId:     info.DeviceId,
+		CaptchaToken: info.CaptchaToken,
+	}); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code":    retcode,
+			"err_msg":     errors.Cause(err).Error(),
+			"retry_after": models.GetSmsRetryAfter(err), // 可以再次获取的等待时间(秒)
+		}
+		t.ServeJSON()
+		return
+	}
+	// 生成验证码并保存到redis
+	mobile, code, retcode, err := models.GenerateSmsVerificationCode(info.Mobile, info.Purpose)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	// 拨打语音电话播报验证码
+	if channel == models.SMS_VERIFICATION_CHANNEL_VOICE {
+		if _, retcode, err = models.SendVoiceVerificationCode(mobile, code); err != nil {
+			Logger.Error(err.Error())
+			if _, removeErr := models.RemoveSmsVerificationCode(mobile, info.Purpose); removeErr != nil {
+				Logger.Error(removeErr.Error())
+			}
+			t.Data["json"] = map[string]interface{}{
+				"err_code": retcode,
+				"err_msg":  errors.Cause(err).Error(),
+			}
+			t.ServeJSON()
+			return
+		}
 		t.Data["json"] = map[string]interface{}{
-			"err_code": utils.REDIS_SET_FAILED,
-			"err_msg":  "store redis error:" + errors.Cause(err).Error(),
+			"err_code": 0,
+			"err_msg":  "",
+			"channel":  channel,
 		}
 		t.ServeJSON()
 		return
 	}
-	mobiles = append(mobiles, info.Mobile)
+	mobiles = append(mobiles, mobile)
 	// 发送短信验证码
-	chuanglan := ChuanglanSmsController{}
-	countPerSingle, smsSendCount, msgid, content, templateId, retcode, err := chuanglan.SendVerificationSms(code, mobiles)
+	result, retcode, err := t.sendVerificationSms(code, mobiles)
 	if err != nil {
 		Logger.Error(err.Error())
+		if _, removeErr := models.RemoveSmsVerificationCode(mobile, info.Purpose); removeErr != nil {
+			Logger.Error(removeErr.Error())
+		}
 		t.Data["json"] = map[string]interface{}{
 			"err_code": retcode,
 			"err_msg":  errors.Cause(err).Error(),
@@ -78,11 +141,49 @@ func (t *SmsController) MobileVerificationCode() {
 		t.ServeJSON()
 		return
 	}
-	fmt.Printf("countPerSingle=%d, smsSendCount=%d, msgid=%s, content=%s, templateId=%d\n",
-		countPerSingle, smsSendCount, msgid, content, templateId)
 	// 扣除该公司营销所发送的短信和平台短信数量
-	models.UpdateChuanglanRemaingSMS(-1, 0, int64(-1*smsSendCount), 0)
+	models.UpdateChuanglanRemaingSMS(-1, 0, int64(-1*result.ChuanglanCount()), 0)
+	// 短信未送达时回退到语音验证码
+	models.RegisterSmsVoiceFallback(result, mobile, info.Purpose)
 	// 发送验证码
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"channel":  channel,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 校验短信验证码，校验成功后验证码失效；超过最多校验次数后需要重新获取
+// @router /mobile_verification_code/verify [post]
+func (t *SmsController) VerifyMobileVerificationCode() {
+	type VerifyInfo struct {
+		Mobile  string `json:"mobile"`
+		Purpose string `json:"purpose"`
+		Code    string `json:"code"`
+	}
+	var (
+		info *VerifyInfo = new(VerifyInfo)
+	)
+	if err := json.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if retcode, err := models.VerifySmsVerificationCode(info.Mobile, info.Purpose, info.Code); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
 	t.Data["json"] = map[string]interface{}{
 		"err_code": 0,
 		"err_msg":  "",
@@ -95,9 +196,12 @@ func (t *SmsController) MobileVerificationCode() {
 // @router /marketing [POST]
 func (t *SmsController) SendMarketingSms() {
 	type SmsInfo struct {
-		Content    string   `json:"content"`
-		Mobiles    []string `json:"mobiles"`
-		TemplateId int      `json:"template_id"`
+		Content    string            `json:"content"`
+		Mobiles    []string          `json:"mobiles"`
+		TemplateId int               `json:"template_id"`
+		Args       []interface{}     `json:"args"`    // 模板参数，按模板变量顺序对应
+		Params     map[string]string `json:"params"`  // 模板命名参数，不为空时优先于args
+		SendAt     string            `json:"send_at"` // 定时发送时间，格式：2006-01-02 15:04:05，为空则立即发送
 	}
 	var (
 		info      *SmsInfo = new(SmsInfo)
@@ -132,6 +236,15 @@ func (t *SmsController) SendMarketingSms() {
 		t.ServeJSON()
 		return
 	}
+	sendAt, retcode, err := models.ParseSmsSendAt(info.SendAt)
+	if err != nil {
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
 	_, platformMarketingCount, companySmsRemainingCount := models.GetChuanglanRemainingSMS(int64(companyId))
 	if platformMarketingCount <= 0 || companySmsRemainingCount <= 0 {
 		err := errors.New("sms remaining count not enough")
@@ -142,8 +255,273 @@ func (t *SmsController) SendMarketingSms() {
 		t.ServeJSON()
 		return
 	}
-	chuanglan := &ChuanglanSmsController{}
-	countPerSingle, smsSendCount, msgid, retcode, err := chuanglan.SendMarketingSms(companyId, info.TemplateId, info.Content, info.Mobiles)
+	req, filtered, retcode, err := buildMarketingSmsRequest(companyId, info.TemplateId, info.Content, info.Mobiles, info.Args, info.Params)
+	if err != nil {
+		Logger.Error(err.Error())
+		missing, unexpected := getTemplateParamsError(err)
+		t.Data["json"] = map[string]interface{}{
+			"err_code":          retcode,
+			"err_msg":           errors.Cause(err).Error(),
+			"sensitive_words":   getSensitiveWords(err),      // 短信内容包含的敏感词
+			"invalid_mobiles":   getInvalidMobiles(filtered), // 无效的手机号及原因
+			"missing_params":    missing,                     // 未提供的模板变量
+			"unexpected_params": unexpected,                  // 模板中不存在的变量
+		}
+		t.ServeJSON()
+		return
+	}
+	// 写入发件箱，到达发送时间后由后台协程异步发送并扣除短信数量
+	messageId, retcode, err := models.EnqueueSms(req, sendAt)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":          0,
+		"err_msg":           "",
+		"message_id":        messageId,
+		"send_at":           sendAt,
+		"invalid_mobiles":   filtered.InvalidMobiles,   // 无效未发送的手机号及原因
+		"blacklist_mobiles": filtered.BlacklistMobiles, // 黑名单中未发送的手机号
+		"opt_out_mobiles":   filtered.OptOutMobiles,    // 已退订未发送的手机号
+	}
+	t.ServeJSON()
+	return
+}
+
+// 按模板名称和命名参数发送短信
+/*
+	>>	各服务商使用同名模板，params为模板命名参数(如：{"code": "1234"})，必须提供模板中的所有变量
+	>>	模板不存在或者参数不完整时不发送，返回缺少的变量(missing_params)和多余的变量(unexpected_params)
+	>>	与营销短信一致：写入发件箱异步发送，无效的、黑名单中和已退订的手机号不发送
+*/
+// @router /template [POST]
+func (t *SmsController) SendTemplateSms() {
+	type TemplateSmsInfo struct {
+		TemplateName string            `json:"template_name"`
+		Params       map[string]string `json:"params"`
+		Mobiles      []string          `json:"mobiles"`
+		SendAt       string            `json:"send_at"` // 定时发送时间，格式：2006-01-02 15:04:05，为空则立即发送
+	}
+	var (
+		info      *TemplateSmsInfo = new(TemplateSmsInfo)
+		companyId int
+	)
+	if err := jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	// 获取user_id和company_id
+	if header, retcode, err := GetHeaderParams(t.Ctx.Request); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	} else if header != nil && header.CompanyId > 0 {
+		companyId = header.CompanyId
+	} else {
+		err := errors.New("please login homepage")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.USER_LOGGED_IN,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if strings.TrimSpace(info.TemplateName) == "" || len(info.Mobiles) <= 0 {
+		err := errors.New("param `template_name || mobiles` empty")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SOURCE_DATA_ILLEGAL,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	sendAt, retcode, err := models.ParseSmsSendAt(info.SendAt)
+	if err != nil {
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if info.Params == nil {
+		info.Params = map[string]string{}
+	}
+	req := &models.SmsRequest{
+		CompanyId:      companyId,
+		AccountType:    models.SMS_CHUANGLAN_MARKETING_TYPE,
+		TemplateName:   strings.TrimSpace(info.TemplateName),
+		TemplateParams: info.Params,
+		Mobiles:        info.Mobiles,
+	}
+	// 写入发件箱前校验模板和参数，避免发送时才失败
+	if retcode, err := models.ValidateSmsTemplateRequest(req); err != nil {
+		Logger.Error(err.Error())
+		missing, unexpected := getTemplateParamsError(err)
+		t.Data["json"] = map[string]interface{}{
+			"err_code":          retcode,
+			"err_msg":           errors.Cause(err).Error(),
+			"missing_params":    missing,    // 未提供的模板变量
+			"unexpected_params": unexpected, // 模板中不存在的变量
+		}
+		t.ServeJSON()
+		return
+	}
+	_, platformMarketingCount, companySmsRemainingCount := models.GetChuanglanRemainingSMS(int64(companyId))
+	if platformMarketingCount <= 0 || companySmsRemainingCount <= 0 {
+		err := errors.New("sms remaining count not enough")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SMS_CHUANGLAN_REMAINING_NOT_ENOUGH,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	filtered, retcode, err := filterMarketingSmsRequest(req)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code":        retcode,
+			"err_msg":         errors.Cause(err).Error(),
+			"sensitive_words": getSensitiveWords(err),      // 模板参数包含的敏感词
+			"invalid_mobiles": getInvalidMobiles(filtered), // 无效的手机号及原因
+		}
+		t.ServeJSON()
+		return
+	}
+	// 写入发件箱，到达发送时间后由后台协程异步发送并扣除短信数量
+	messageId, retcode, err := models.EnqueueSms(req, sendAt)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":          0,
+		"err_msg":           "",
+		"message_id":        messageId,
+		"send_at":           sendAt,
+		"invalid_mobiles":   filtered.InvalidMobiles,   // 无效未发送的手机号及原因
+		"blacklist_mobiles": filtered.BlacklistMobiles, // 黑名单中未发送的手机号
+		"opt_out_mobiles":   filtered.OptOutMobiles,    // 已退订未发送的手机号
+	}
+	t.ServeJSON()
+	return
+}
+
+// 预估短信计费条数和费用，不发送短信
+/*
+	>>	按短信内容(或者营销模板和参数)渲染各服务商实际发送的内容(含签名)，按服务商计费规则计算
+	>>	mobiles不为空时按有效手机号个数计算，否则按mobile_count计算(默认1个)
+	>>	estimates按服务商发送优先级排列，第一个为实际将采用的服务商
+*/
+// @router /estimate [POST]
+func (t *SmsController) EstimateSms() {
+	type EstimateInfo struct {
+		Content     string            `json:"content"`
+		TemplateId  int               `json:"template_id"`
+		Args        []interface{}     `json:"args"`
+		Params      map[string]string `json:"params"` // 模板命名参数，不为空时优先于args
+		Mobiles     []string          `json:"mobiles"`
+		MobileCount int               `json:"mobile_count"`
+	}
+	var (
+		info      *EstimateInfo = new(EstimateInfo)
+		companyId int
+		template  *models.SmsTemplates
+	)
+	if err := jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	// 获取user_id和company_id
+	if header, retcode, err := GetHeaderParams(t.Ctx.Request); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	} else if header != nil && header.CompanyId > 0 {
+		companyId = header.CompanyId
+	} else {
+		err := errors.New("please login homepage")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.USER_LOGGED_IN,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if strings.TrimSpace(info.Content) == "" && info.TemplateId <= 0 {
+		err := errors.New("param `content || template_id` empty")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SOURCE_DATA_ILLEGAL,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	req := &models.SmsRequest{
+		CompanyId:     companyId,
+		SmsTemplateId: info.TemplateId,
+		AccountType:   models.SMS_CHUANGLAN_MARKETING_TYPE,
+		Mobiles:       info.Mobiles,
+	}
+	if info.TemplateId > 0 {
+		o := orm.NewOrm()
+		template = &models.SmsTemplates{
+			Id: info.TemplateId,
+		}
+		if retcode, err := template.ReadSmsTemplateNoLock(&o); err != nil {
+			Logger.Error(err.Error())
+			t.Data["json"] = map[string]interface{}{
+				"err_code": retcode,
+				"err_msg":  errors.Cause(err).Error(),
+			}
+			t.ServeJSON()
+			return
+		}
+		req.TemplateName = template.TemplateName
+		req.TemplateArgs = info.Args
+		req.TemplateParams = info.Params
+	} else {
+		// 与营销短信发送的内容一致，签名由服务商替换
+		req.Content = fmt.Sprintf("【】%s。回复TD退订", info.Content)
+	}
+	invalidMobiles := models.NormalizeSmsMobiles(req)
+	mobileCount := len(req.Mobiles)
+	if len(info.Mobiles) <= 0 {
+		if mobileCount = info.MobileCount; mobileCount <= 0 {
+			mobileCount = 1
+		}
+	}
+	estimates, retcode, err := models.EstimateSms(req, mobileCount)
 	if err != nil {
 		Logger.Error(err.Error())
 		t.Data["json"] = map[string]interface{}{
@@ -153,9 +531,195 @@ func (t *SmsController) SendMarketingSms() {
 		t.ServeJSON()
 		return
 	}
-	// 扣除该公司营销所发送的短信和平台短信数量
-	models.UpdateChuanglanRemaingSMS(int64(companyId), 0, int64(-1*smsSendCount), int64(-1*smsSendCount))
-	fmt.Printf("countPerSingle=%d, smsSendCount=%d, msgid=%s\n", countPerSingle, smsSendCount, msgid)
+	t.Data["json"] = map[string]interface{}{
+		"err_code":        0,
+		"err_msg":         "",
+		"mobile_count":    mobileCount,
+		"invalid_mobiles": invalidMobiles, // 无效的手机号及原因，不计入条数
+		"estimates":       estimates,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 查询发件箱短信发送状态
+// @router /messages/:id [GET]
+func (t *SmsController) GetMessage() {
+	var (
+		companyId int
+	)
+	// 获取user_id和company_id
+	if info, retcode, err := GetHeaderParams(t.Ctx.Request); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	} else if info != nil && info.CompanyId > 0 {
+		companyId = info.CompanyId
+	} else {
+		err := errors.New("please login homepage")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.USER_LOGGED_IN,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	id, _ := t.GetInt(":id")
+	o := orm.NewOrm()
+	message := &models.SmsOutboxMessages{
+		Id: id,
+	}
+	if retcode, err := message.ReadSmsOutboxMessageNoLock(&o); err != nil || message.CompanyId != companyId {
+		if err == nil {
+			err = errors.New("sms message not exist")
+			retcode = utils.SOURCE_DATA_ILLEGAL
+		}
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	// 发送历史
+	attempts, retcode, err := models.GetSmsSendAttempts(message.Id)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	// 接收人送达状态
+	deliveries, retcode, err := models.GetSmsDeliveriesByOutboxId(message.Id)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":        0,
+		"err_msg":         "",
+		"message_id":      message.Id,
+		"status":          message.Status,
+		"attempts":        message.Attempts,
+		"send_at":         message.SendAt,
+		"next_attempt_at": message.VisibleAt,
+		"send_retcode":    message.Retcode,
+		"send_err_msg":    message.ErrMsg,
+		"provider_msgid":  message.MessageId,
+		"attempt_history": attempts,
+		"deliveries":      deliveries,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 定时发送短信列表，只包括尚未到达发送时间的短信
+// @router /scheduled_messages [GET]
+func (t *SmsController) GetScheduledMessages() {
+	var (
+		companyId int
+	)
+	// 获取user_id和company_id
+	if info, retcode, err := GetHeaderParams(t.Ctx.Request); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	} else if info != nil && info.CompanyId > 0 {
+		companyId = info.CompanyId
+	} else {
+		err := errors.New("please login homepage")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.USER_LOGGED_IN,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 20)
+	messages, count, retcode, err := models.GetScheduledSmsOutboxMessages(companyId, offset, limit)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	list := []map[string]interface{}{}
+	for index := 0; index < len(messages); index++ {
+		list = append(list, map[string]interface{}{
+			"message_id":   messages[index].Id,
+			"account_type": messages[index].AccountType,
+			"request":      messages[index].Request,
+			"send_at":      messages[index].SendAt,
+			"created_at":   messages[index].CreatedAt,
+		})
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"count":    count,
+		"messages": list,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 取消待发送的短信
+// @router /messages/:id [DELETE]
+func (t *SmsController) CancelMessage() {
+	var (
+		companyId int
+	)
+	// 获取user_id和company_id
+	if info, retcode, err := GetHeaderParams(t.Ctx.Request); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	} else if info != nil && info.CompanyId > 0 {
+		companyId = info.CompanyId
+	} else {
+		err := errors.New("please login homepage")
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.USER_LOGGED_IN,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	id, _ := t.GetInt(":id")
+	if retcode, err := models.CancelSmsOutboxMessage(companyId, id); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
 	t.Data["json"] = map[string]interface{}{
 		"err_code": 0,
 		"err_msg":  "",
@@ -163,3 +727,124 @@ func (t *SmsController) SendMarketingSms() {
 	t.ServeJSON()
 	return
 }
+
+// 营销类短信，主动推送给用户，用户被动接受且可以退订
+/*
+	>>	本接口支持营销类短信两类:
+		1. 采用模板和参数，形成短信内容
+		2. 直接发送自定义内容，无模板
+	>>	采用模板时按args(模板变量顺序)或者params(命名参数，优先)渲染，与按模板名称发送一致，参数不完整时不发送
+	>>	无效的、黑名单中和已退订该公司营销短信的手机号不发送，通过filtered返回给调用方
+*/
+func buildMarketingSmsRequest(companyId int, templateId int, content string, mobiles []string, args []interface{}, params map[string]string) (req *models.SmsRequest, filtered *models.SmsFilteredMobiles, retcode int, err error) {
+	Logger.Info("[%v] enter buildMarketingSmsRequest.", templateId)
+	defer Logger.Info("[%v] left buildMarketingSmsRequest.", templateId)
+	var (
+		template *models.SmsTemplates
+	)
+	if (strings.TrimSpace(content) == "" && templateId <= 0) || mobiles == nil || len(mobiles) <= 0 {
+		err = errors.New("param `content || mobiles` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	req = &models.SmsRequest{
+		CompanyId:     companyId,
+		SmsTemplateId: templateId,
+		AccountType:   models.SMS_CHUANGLAN_MARKETING_TYPE,
+		Mobiles:       mobiles,
+	}
+	// 如果模板ID不为空，则采用模板发送短信, 各服务商使用同名模板
+	if templateId > 0 {
+		o := orm.NewOrm()
+		template = &models.SmsTemplates{
+			Id: templateId,
+		}
+		if retcode, err = template.ReadSmsTemplateNoLock(&o); err != nil {
+			err = errors.Wrap(err, "buildMarketingSmsRequest")
+			return
+		}
+		req.TemplateName = template.TemplateName
+		req.TemplateArgs = args
+		req.TemplateParams = params
+		// 写入发件箱前校验模板和参数，避免发送时才失败
+		if retcode, err = models.ValidateSmsTemplateRequest(req); err != nil {
+			err = errors.Wrap(err, "buildMarketingSmsRequest")
+			return
+		}
+	} else {
+		// 签名由实际发送的服务商替换
+		req.Content = fmt.Sprintf("【】%s。回复TD退订", content)
+	}
+	if filtered, retcode, err = filterMarketingSmsRequest(req); err != nil {
+		err = errors.Wrap(err, "buildMarketingSmsRequest")
+		return
+	}
+	return
+}
+
+// 营销短信发送前检查敏感词，过滤无效的、黑名单中和已退订该公司营销短信的手机号
+func filterMarketingSmsRequest(req *models.SmsRequest) (filtered *models.SmsFilteredMobiles, retcode int, err error) {
+	// 敏感词检查：拒绝发送或者替换为*
+	if _, retcode, err = models.FilterSmsSensitiveWords(req); err != nil {
+		err = errors.Wrap(err, "filterMarketingSmsRequest")
+		return
+	}
+	if filtered, retcode, err = models.FilterSmsRequest(req); err != nil {
+		err = errors.Wrap(err, "filterMarketingSmsRequest")
+		return
+	}
+	if len(req.Mobiles) <= 0 {
+		err = errors.New("all mobiles invalid, blacklisted or opted out")
+		retcode = filtered.Retcode()
+		return
+	}
+	return
+}
+
+// 短信内容包含的敏感词，其他错误返回空
+func getSensitiveWords(err error) (words []string) {
+	if sensitiveErr, ok := errors.Cause(err).(*models.SmsSensitiveWordsError); ok {
+		return sensitiveErr.Words
+	}
+	return
+}
+
+// 模板未提供的变量和多余的变量，其他错误返回空
+func getTemplateParamsError(err error) (missing []string, unexpected []string) {
+	if paramsErr, ok := errors.Cause(err).(*models.SmsTemplateParamsError); ok {
+		return paramsErr.Missing, paramsErr.Unexpected
+	}
+	return
+}
+
+// 无效的手机号及原因，未过滤手机号时返回空
+func getInvalidMobiles(filtered *models.SmsFilteredMobiles) (rejections []phone.Rejection) {
+	if filtered == nil {
+		return
+	}
+	return filtered.InvalidMobiles
+}
+
+// 发送短信验证码，采用服务商对应的短信验证码模板
+func (t *SmsController) sendVerificationSms(code string, mobiles []string) (result *models.SmsResult, retcode int, err error) {
+	Logger.Info("enter sendVerificationSms.")
+	defer Logger.Info("left sendVerificationSms.")
+	req := &models.SmsRequest{
+		CompanyId:    -1,
+		AccountType:  models.SMS_CHUANGLAN_VERIFICATION_TYPE,
+		TemplateName: models.MOBILE_VERIFICATION_CODE_CONTENT,
+		TemplateArgs: []interface{}{code},
+		Mobiles:      mobiles,
+	}
+	// 无效的和黑名单中的手机号不发送
+	filtered, retcode, err := models.FilterSmsRequest(req)
+	if err != nil {
+		return
+	}
+	if len(req.Mobiles) <= 0 {
+		err = errors.New("mobile invalid or blacklisted")
+		retcode = filtered.Retcode()
+		return
+	}
+	return models.RouteSms(req)
+}
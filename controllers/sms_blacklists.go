@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"strings"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SmsBlacklistsController operations for SmsBlacklists
+type SmsBlacklistsController struct {
+	beego.Controller
+}
+
+// 获取公司ID
+func (t *SmsBlacklistsController) getCompanyId() (companyId int, retcode int, err error) {
+	info, retcode, err := GetHeaderParams(t.Ctx.Request)
+	if err != nil {
+		return
+	}
+	if info == nil || info.CompanyId <= 0 {
+		err = errors.New("please login homepage")
+		retcode = utils.USER_LOGGED_IN
+		return
+	}
+	return info.CompanyId, 0, nil
+}
+
+func (t *SmsBlacklistsController) serveError(retcode int, err error) {
+	Logger.Error(err.Error())
+	t.Data["json"] = map[string]interface{}{
+		"err_code": retcode,
+		"err_msg":  errors.Cause(err).Error(),
+	}
+	t.ServeJSON()
+}
+
+// 公司的黑名单列表, mobile: 只查询该手机号
+// @router /blacklists [GET]
+func (t *SmsBlacklistsController) GetBlacklists() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 100)
+	blacklists, count, retcode, err := models.GetSmsBlacklists(companyId, strings.TrimSpace(t.GetString("mobile")), offset, limit)
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":   0,
+		"err_msg":    "",
+		"count":      count,
+		"blacklists": blacklists,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 手动添加黑名单手机号，该公司的所有短信不再发送给这些手机号
+// @router /blacklists [POST]
+func (t *SmsBlacklistsController) AddBlacklists() {
+	type BlacklistInfo struct {
+		Mobiles []string `json:"mobiles"`
+		Remark  string   `json:"remark"`
+	}
+	var (
+		info *BlacklistInfo = new(BlacklistInfo)
+	)
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	if err = jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		t.serveError(utils.JSON_PARSE_FAILED, err)
+		return
+	}
+	if len(info.Mobiles) <= 0 {
+		t.serveError(utils.SOURCE_DATA_ILLEGAL, errors.New("param `mobiles` empty"))
+		return
+	}
+	for _, mobile := range info.Mobiles {
+		if retcode, err = models.AddSmsBlacklist(companyId, mobile, models.SMS_BLACKLIST_SOURCE_MANUAL, 0, info.Remark); err != nil {
+			t.serveError(retcode, err)
+			return
+		}
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
+
+// 删除公司的黑名单手机号；状态报告加入的全局黑名单不受影响
+// @router /blacklists/:mobile [DELETE]
+func (t *SmsBlacklistsController) DeleteBlacklist() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	if retcode, err = models.RemoveSmsBlacklist(companyId, t.GetString(":mobile")); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
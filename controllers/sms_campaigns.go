@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"strings"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SmsCampaignsController operations for SmsCampaigns
+type SmsCampaignsController struct {
+	beego.Controller
+}
+
+// 获取公司ID
+func (t *SmsCampaignsController) getCompanyId() (companyId int, retcode int, err error) {
+	info, retcode, err := GetHeaderParams(t.Ctx.Request)
+	if err != nil {
+		return
+	}
+	if info == nil || info.CompanyId <= 0 {
+		err = errors.New("please login homepage")
+		retcode = utils.USER_LOGGED_IN
+		return
+	}
+	return info.CompanyId, 0, nil
+}
+
+func (t *SmsCampaignsController) serveError(retcode int, err error) {
+	Logger.Error(err.Error())
+	t.Data["json"] = map[string]interface{}{
+		"err_code": retcode,
+		"err_msg":  errors.Cause(err).Error(),
+	}
+	t.ServeJSON()
+}
+
+// 创建营销短信群发活动，接收人分批发送
+// @router /campaigns [POST]
+func (t *SmsCampaignsController) AddCampaign() {
+	type CampaignInfo struct {
+		Name       string            `json:"name"`
+		TemplateId int               `json:"template_id"`
+		Args       []interface{}     `json:"args"`   // 模板参数，按模板变量顺序对应
+		Params     map[string]string `json:"params"` // 模板命名参数，不为空时优先于args
+		Content    string            `json:"content"`
+		Mobiles    []string          `json:"mobiles"` // 接收人
+		SendAt     string            `json:"send_at"` // 定时发送时间，格式：2006-01-02 15:04:05，为空则立即发送
+	}
+	var (
+		info *CampaignInfo = new(CampaignInfo)
+	)
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	if err = jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		t.serveError(utils.JSON_PARSE_FAILED, err)
+		return
+	}
+	if strings.TrimSpace(info.Name) == "" {
+		t.serveError(utils.SOURCE_DATA_ILLEGAL, errors.New("param `name` empty"))
+		return
+	}
+	sendAt, retcode, err := models.ParseSmsSendAt(info.SendAt)
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	_, platformMarketingCount, companySmsRemainingCount := models.GetChuanglanRemainingSMS(int64(companyId))
+	if platformMarketingCount <= 0 || companySmsRemainingCount <= 0 {
+		t.serveError(utils.SMS_CHUANGLAN_REMAINING_NOT_ENOUGH, errors.New("sms remaining count not enough"))
+		return
+	}
+	req, filtered, retcode, err := buildMarketingSmsRequest(companyId, info.TemplateId, info.Content, info.Mobiles, info.Args, info.Params)
+	if err != nil {
+		Logger.Error(err.Error())
+		missing, unexpected := getTemplateParamsError(err)
+		t.Data["json"] = map[string]interface{}{
+			"err_code":          retcode,
+			"err_msg":           errors.Cause(err).Error(),
+			"sensitive_words":   getSensitiveWords(err),      // 短信内容包含的敏感词
+			"invalid_mobiles":   getInvalidMobiles(filtered), // 无效的手机号及原因
+			"missing_params":    missing,                     // 未提供的模板变量
+			"unexpected_params": unexpected,                  // 模板中不存在的变量
+		}
+		t.ServeJSON()
+		return
+	}
+	campaign := &models.SmsCampaigns{
+		CompanyId:     companyId,
+		Name:          strings.TrimSpace(info.Name),
+		SmsTemplateId: info.TemplateId,
+		Content:       req.Content,
+	}
+	if retcode, err = models.CreateSmsCampaign(campaign, req, sendAt); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":          0,
+		"err_msg":           "",
+		"id":                campaign.Id,
+		"chunk_count":       campaign.ChunkCount,
+		"send_at":           campaign.SendAt,
+		"invalid_mobiles":   filtered.InvalidMobiles,   // 无效未发送的手机号及原因
+		"blacklist_mobiles": filtered.BlacklistMobiles, // 黑名单中未发送的手机号
+		"opt_out_mobiles":   filtered.OptOutMobiles,    // 已退订未发送的手机号
+	}
+	t.ServeJSON()
+	return
+}
+
+// 群发活动列表
+// @router /campaigns [GET]
+func (t *SmsCampaignsController) GetCampaigns() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 20)
+	campaigns, count, retcode, err := models.GetSmsCampaigns(companyId, offset, limit)
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":  0,
+		"err_msg":   "",
+		"count":     count,
+		"campaigns": campaigns,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 群发活动发送进度，以及每批短信的发送状态
+// @router /campaigns/:id [GET]
+func (t *SmsCampaignsController) GetCampaign() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	id, _ := t.GetInt(":id")
+	campaign, chunks, retcode, err := models.GetSmsCampaign(companyId, id)
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	chunkList := []map[string]interface{}{}
+	for index := 0; index < len(chunks); index++ {
+		chunkList = append(chunkList, map[string]interface{}{
+			"message_id":      chunks[index].Id,
+			"status":          chunks[index].Status,
+			"attempts":        chunks[index].Attempts,
+			"send_at":         chunks[index].SendAt,
+			"next_attempt_at": chunks[index].VisibleAt,
+			"send_retcode":    chunks[index].Retcode,
+			"send_err_msg":    chunks[index].ErrMsg,
+		})
+	}
+	pendingCount := campaign.TotalCount - campaign.SubmittedCount - campaign.FailedCount - campaign.CanceledCount
+	t.Data["json"] = map[string]interface{}{
+		"err_code":        0,
+		"err_msg":         "",
+		"campaign":        campaign,
+		"pending_count":   pendingCount,
+		"submitted_count": campaign.SubmittedCount,
+		"failed_count":    campaign.FailedCount,
+		"canceled_count":  campaign.CanceledCount,
+		"chunks":          chunkList,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 群发活动接收人发送状态，status: 10: 待发送；20: 已提交服务商；30: 发送失败；40: 已取消，不传则全部
+// @router /campaigns/:id/recipients [GET]
+func (t *SmsCampaignsController) GetCampaignRecipients() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	id, _ := t.GetInt(":id")
+	status, _ := t.GetInt("status", 0)
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 100)
+	if _, _, retcode, err = models.GetSmsCampaign(companyId, id); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	recipients, count, retcode, err := models.GetSmsCampaignRecipients(id, status, offset, limit)
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":   0,
+		"err_msg":    "",
+		"count":      count,
+		"recipients": recipients,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 暂停群发活动
+// @router /campaigns/:id/pause [PUT]
+func (t *SmsCampaignsController) PauseCampaign() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	id, _ := t.GetInt(":id")
+	if retcode, err = models.PauseSmsCampaign(companyId, id); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
+
+// 恢复已暂停的群发活动
+// @router /campaigns/:id/resume [PUT]
+func (t *SmsCampaignsController) ResumeCampaign() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	id, _ := t.GetInt(":id")
+	if retcode, err = models.ResumeSmsCampaign(companyId, id); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
+
+// 取消群发活动，正在发送的分批短信不受影响
+// @router /campaigns/:id/cancel [PUT]
+func (t *SmsCampaignsController) CancelCampaign() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	id, _ := t.GetInt(":id")
+	if retcode, err = models.CancelSmsCampaign(companyId, id); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"strings"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	"github.com/pkg/errors"
+)
+
+// SmsInboundMessagesController operations for SmsInboundMessages
+type SmsInboundMessagesController struct {
+	beego.Controller
+}
+
+// 获取公司收到的上行短信(用户回复)，campaign_id: 群发活动ID，只查询该活动的回复；mobile: 只查询该手机号的回复
+// @router /inbound_messages [GET]
+func (t *SmsInboundMessagesController) GetInboundMessages() {
+	info, retcode, err := GetHeaderParams(t.Ctx.Request)
+	if err == nil && (info == nil || info.CompanyId <= 0) {
+		err = errors.New("please login homepage")
+		retcode = utils.USER_LOGGED_IN
+	}
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	campaignId, _ := t.GetInt("campaign_id", 0)
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 100)
+	messages, count, retcode, err := models.GetSmsInboundMessages(info.CompanyId, campaignId, strings.TrimSpace(t.GetString("mobile")), offset, limit)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"count":    count,
+		"messages": messages,
+	}
+	t.ServeJSON()
+	return
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"strings"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SmsOptOutsController operations for SmsOptOuts
+type SmsOptOutsController struct {
+	beego.Controller
+}
+
+// 获取公司ID
+func (t *SmsOptOutsController) getCompanyId() (companyId int, retcode int, err error) {
+	info, retcode, err := GetHeaderParams(t.Ctx.Request)
+	if err != nil {
+		return
+	}
+	if info == nil || info.CompanyId <= 0 {
+		err = errors.New("please login homepage")
+		retcode = utils.USER_LOGGED_IN
+		return
+	}
+	return info.CompanyId, 0, nil
+}
+
+func (t *SmsOptOutsController) serveError(retcode int, err error) {
+	Logger.Error(err.Error())
+	t.Data["json"] = map[string]interface{}{
+		"err_code": retcode,
+		"err_msg":  errors.Cause(err).Error(),
+	}
+	t.ServeJSON()
+}
+
+// 公司的退订手机号列表, mobile: 只查询该手机号
+// @router /opt_outs [GET]
+func (t *SmsOptOutsController) GetOptOuts() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 100)
+	optOuts, count, retcode, err := models.GetSmsOptOuts(companyId, strings.TrimSpace(t.GetString("mobile")), offset, limit)
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"count":    count,
+		"opt_outs": optOuts,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 手动添加退订手机号，不再接收该公司的营销短信
+// @router /opt_outs [POST]
+func (t *SmsOptOutsController) AddOptOuts() {
+	type OptOutInfo struct {
+		Mobiles []string `json:"mobiles"`
+	}
+	var (
+		info *OptOutInfo = new(OptOutInfo)
+	)
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	if err = jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		t.serveError(utils.JSON_PARSE_FAILED, err)
+		return
+	}
+	if len(info.Mobiles) <= 0 {
+		t.serveError(utils.SOURCE_DATA_ILLEGAL, errors.New("param `mobiles` empty"))
+		return
+	}
+	for _, mobile := range info.Mobiles {
+		if retcode, err = models.AddSmsOptOut(companyId, mobile, models.SMS_OPT_OUT_SOURCE_MANUAL, 0); err != nil {
+			t.serveError(retcode, err)
+			return
+		}
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
+
+// 删除退订手机号，恢复接收该公司的营销短信；全局退订不受影响
+// @router /opt_outs/:mobile [DELETE]
+func (t *SmsOptOutsController) DeleteOptOut() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	if retcode, err = models.RemoveSmsOptOut(companyId, t.GetString(":mobile")); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SmsRoutingRulesController operations for SmsRoutingRules
+type SmsRoutingRulesController struct {
+	beego.Controller
+}
+
+type RoutingRuleInfo struct {
+	AccountType          int16  `json:"account_type"`            // 0: 所有短信类型；1: 验证码短信；2: 营销短信
+	MobilePrefix         string `json:"mobile_prefix"`           // 号码前缀，国家码开头，如：86、86138、852
+	SmsServiceProviderId int    `json:"sms_service_provider_id"` // 短信服务商ID
+	Weight               int    `json:"weight"`                  // 权重
+	UnitCost             int    `json:"unit_cost"`               // 单条短信价格，单位：厘
+}
+
+func (t *RoutingRuleInfo) check() (err error) {
+	if int(t.AccountType) != models.SMS_ROUTING_ALL_TYPE && int(t.AccountType) != models.SMS_CHUANGLAN_VERIFICATION_TYPE && int(t.AccountType) != models.SMS_CHUANGLAN_MARKETING_TYPE {
+		return errors.New("param `account_type` is illegal!")
+	}
+	if t.SmsServiceProviderId <= 0 || t.Weight < 0 || t.UnitCost < 0 {
+		return errors.New("param `sms_service_provider_id | weight | unit_cost` is illegal!")
+	}
+	t.MobilePrefix = strings.TrimPrefix(strings.TrimSpace(t.MobilePrefix), "+")
+	return
+}
+
+// 路由规则对所有公司生效，只有平台管理员公司(conf.SmsAdminCompanyIds)可以查询和修改
+func (t *SmsRoutingRulesController) checkAdmin() (retcode int, err error) {
+	info, retcode, err := GetHeaderParams(t.Ctx.Request)
+	if err != nil {
+		return
+	}
+	if info == nil || info.CompanyId <= 0 {
+		err = errors.New("please login homepage")
+		retcode = utils.USER_LOGGED_IN
+		return
+	}
+	for _, companyId := range conf.SmsAdminCompanyIds {
+		if companyId == info.CompanyId {
+			return 0, nil
+		}
+	}
+	err = errors.New("sms routing rules forbidden")
+	retcode = models.SMS_ROUTING_RULE_FORBIDDEN
+	return
+}
+
+func (t *SmsRoutingRulesController) serveError(retcode int, err error) {
+	Logger.Error(err.Error())
+	t.Data["json"] = map[string]interface{}{
+		"err_code": retcode,
+		"err_msg":  errors.Cause(err).Error(),
+	}
+	t.ServeJSON()
+}
+
+// 短信路由规则列表
+// @router /routing_rules [GET]
+func (t *SmsRoutingRulesController) GetRoutingRules() {
+	if retcode, err := t.checkAdmin(); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	query := map[string]string{
+		"status": fmt.Sprintf("%d", utils.STATUS_VALID),
+	}
+	if accountType, err := t.GetInt("account_type", -1); err == nil && accountType >= 0 {
+		query["account_type"] = fmt.Sprintf("%d", accountType)
+	}
+	rules, err := models.GetAllSmsRoutingRules(query, nil, []string{"id"}, []string{"asc"}, 0, 1000)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.DB_READ_ERROR,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"rules":    rules,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 新增短信路由规则，即时生效
+// @router /routing_rules [POST]
+func (t *SmsRoutingRulesController) AddRoutingRule() {
+	if retcode, err := t.checkAdmin(); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	var (
+		info *RoutingRuleInfo = new(RoutingRuleInfo)
+	)
+	if err := jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if err := info.check(); err != nil {
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SOURCE_DATA_ILLEGAL,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	now := time.Now()
+	o := orm.NewOrm()
+	rule := &models.SmsRoutingRules{
+		AccountType:          info.AccountType,
+		MobilePrefix:         info.MobilePrefix,
+		SmsServiceProviderId: info.SmsServiceProviderId,
+		Weight:               info.Weight,
+		UnitCost:             info.UnitCost,
+		Status:               utils.STATUS_VALID,
+		UpdatedAt:            now,
+		CreatedAt:            now,
+	}
+	if retcode, err := rule.InsertSmsRoutingRuleNoLock(&o); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"id":       rule.Id,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 修改短信路由规则，即时生效
+// @router /routing_rules/:id [PUT]
+func (t *SmsRoutingRulesController) ModifyRoutingRule() {
+	if retcode, err := t.checkAdmin(); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	var (
+		info *RoutingRuleInfo = new(RoutingRuleInfo)
+	)
+	id, _ := t.GetInt(":id")
+	if err := jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if err := info.check(); err != nil || id <= 0 {
+		if err == nil {
+			err = errors.New("param `id` is illegal!")
+		}
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SOURCE_DATA_ILLEGAL,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	o := orm.NewOrm()
+	rule := &models.SmsRoutingRules{
+		Id: id,
+	}
+	if retcode, err := rule.ReadSmsRoutingRuleNoLock(&o); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	rule.AccountType = info.AccountType
+	rule.MobilePrefix = info.MobilePrefix
+	rule.SmsServiceProviderId = info.SmsServiceProviderId
+	rule.Weight = info.Weight
+	rule.UnitCost = info.UnitCost
+	rule.UpdatedAt = time.Now()
+	if retcode, err := rule.UpdateSmsRoutingRuleNoLock(&o); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
+
+// 删除短信路由规则(逻辑删除)，即时生效
+// @router /routing_rules/:id [DELETE]
+func (t *SmsRoutingRulesController) DeleteRoutingRule() {
+	if retcode, err := t.checkAdmin(); err != nil {
+		t.serveError(retcode, err)
+		return
+	}
+	id, _ := t.GetInt(":id")
+	o := orm.NewOrm()
+	rule := &models.SmsRoutingRules{
+		Id: id,
+	}
+	if retcode, err := rule.ReadSmsRoutingRuleNoLock(&o); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	rule.Status = int16(models.SMS_STATUS_DELETED)
+	rule.UpdatedAt = time.Now()
+	if retcode, err := rule.UpdateSmsRoutingRuleNoLock(&o); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/orm"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SmsSendPeriodsController operations for SmsSendPeriods
+type SmsSendPeriodsController struct {
+	beego.Controller
+}
+
+// 获取公司ID
+func (t *SmsSendPeriodsController) getCompanyId() (companyId int, retcode int, err error) {
+	info, retcode, err := GetHeaderParams(t.Ctx.Request)
+	if err != nil {
+		return
+	}
+	if info == nil || info.CompanyId <= 0 {
+		err = errors.New("please login homepage")
+		retcode = utils.USER_LOGGED_IN
+		return
+	}
+	return info.CompanyId, 0, nil
+}
+
+// 公司的短信发送时间窗口列表
+// @router /send_periods [GET]
+func (t *SmsSendPeriodsController) GetSendPeriods() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	periods := []models.SmsSendPeriods{}
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&models.SmsSendPeriods{}).TableName()).Filter("company_id", companyId).Filter("status", utils.STATUS_VALID).All(&periods); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.DB_READ_ERROR,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"periods":  periods,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 设置公司短信类型的发送时间窗口，已存在则修改；验证码短信不受发送时间窗口限制
+// @router /send_periods [PUT]
+func (t *SmsSendPeriodsController) SetSendPeriod() {
+	type SendPeriodInfo struct {
+		AccountType int16  `json:"account_type"` // 2: 营销短信
+		StartTime   string `json:"start_time"`   // 开始时间，格式：08:00
+		EndTime     string `json:"end_time"`     // 结束时间，格式：21:00
+		TimeLoc     string `json:"time_loc"`     // 时区，如：Asia/Shanghai，为空采用db::time_loc
+	}
+	var (
+		info *SendPeriodInfo = new(SendPeriodInfo)
+	)
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if err = jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	now := time.Now()
+	period := &models.SmsSendPeriods{
+		CompanyId:   companyId,
+		AccountType: info.AccountType,
+		StartTime:   info.StartTime,
+		EndTime:     info.EndTime,
+		TimeLoc:     info.TimeLoc,
+		Status:      utils.STATUS_VALID,
+		UpdatedAt:   now,
+		CreatedAt:   now,
+	}
+	if int(info.AccountType) != models.SMS_CHUANGLAN_MARKETING_TYPE {
+		err = errors.New("param `account_type` is illegal!")
+	} else {
+		err = period.Check()
+	}
+	if err != nil {
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SOURCE_DATA_ILLEGAL,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	o := orm.NewOrm()
+	existed := &models.SmsSendPeriods{}
+	err = o.QueryTable(existed.TableName()).Filter("company_id", companyId).Filter("account_type", info.AccountType).Filter("status", utils.STATUS_VALID).One(existed)
+	if err != nil && err != orm.ErrNoRows {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.DB_READ_ERROR,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if err == orm.ErrNoRows {
+		retcode, err = period.InsertSmsSendPeriodNoLock(&o)
+	} else {
+		period.Id = existed.Id
+		period.CreatedAt = existed.CreatedAt
+		retcode, err = period.UpdateSmsSendPeriodNoLock(&o)
+	}
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"id":       period.Id,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 删除公司的短信发送时间窗口(逻辑删除)，之后采用平台默认发送时间窗口
+// @router /send_periods/:id [DELETE]
+func (t *SmsSendPeriodsController) DeleteSendPeriod() {
+	companyId, retcode, err := t.getCompanyId()
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	id, _ := t.GetInt(":id")
+	o := orm.NewOrm()
+	period := &models.SmsSendPeriods{
+		Id: id,
+	}
+	if retcode, err = period.ReadSmsSendPeriodNoLock(&o); err != nil || period.CompanyId != companyId {
+		if err == nil {
+			err = errors.New("sms send period not exist")
+			retcode = utils.SOURCE_DATA_ILLEGAL
+		}
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	period.Status = int16(models.SMS_STATUS_DELETED)
+	period.UpdatedAt = time.Now()
+	if retcode, err = period.UpdateSmsSendPeriodNoLock(&o); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+	}
+	t.ServeJSON()
+	return
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"strings"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// SmsSensitiveWordsController operations for SmsSensitiveWords
+type SmsSensitiveWordsController struct {
+	beego.Controller
+}
+
+// 敏感词列表，敏感词由平台在sms_sensitive_words表维护
+// @router /sensitive_words [GET]
+func (t *SmsSensitiveWordsController) GetSensitiveWords() {
+	offset, _ := t.GetInt64("offset", 0)
+	limit, _ := t.GetInt64("limit", 100)
+	words, count, retcode, err := models.GetSmsSensitiveWords(offset, limit)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code": 0,
+		"err_msg":  "",
+		"count":    count,
+		"words":    words,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 检查短信内容包含的敏感词，返回敏感词和替换为*后的内容
+// @router /sensitive_words/check [POST]
+func (t *SmsSensitiveWordsController) CheckSensitiveWords() {
+	type ContentInfo struct {
+		Content string `json:"content"`
+	}
+	var (
+		info *ContentInfo = new(ContentInfo)
+	)
+	if err := jsoniter.Unmarshal(t.Ctx.Input.RequestBody, info); err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.JSON_PARSE_FAILED,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	if strings.TrimSpace(info.Content) == "" {
+		t.Data["json"] = map[string]interface{}{
+			"err_code": utils.SOURCE_DATA_ILLEGAL,
+			"err_msg":  "param `content` empty",
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":        0,
+		"err_msg":         "",
+		"sensitive_words": models.CheckSmsSensitiveWords(info.Content),
+		"masked_content":  models.MaskSmsSensitiveWords(info.Content),
+	}
+	t.ServeJSON()
+	return
+}
@@ -1,8 +1,78 @@
 package controllers
 
-import "github.com/astaxie/beego"
+import (
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/models"
+	"github.com/astaxie/beego"
+	"github.com/pkg/errors"
+)
 
 // SmsServiceProvidersController operations for SmsServiceProviders
 type SmsServiceProvidersController struct {
 	beego.Controller
 }
+
+// 已启用短信服务商的健康状态：熔断状态、错误率、平均耗时、连续失败次数
+// @router /providers/health [GET]
+func (t *SmsServiceProvidersController) GetProvidersHealth() {
+	healths := []*models.SmsProviderHealthInfo{}
+	for _, provider := range models.GetPrioritizedSmsProviders() {
+		healths = append(healths, models.GetSmsProviderHealthInfo(provider.GetSmsServiceProviderId()))
+	}
+	t.Data["json"] = map[string]interface{}{
+		"err_code":  0,
+		"err_msg":   "",
+		"providers": healths,
+	}
+	t.ServeJSON()
+	return
+}
+
+// 服务商回调认证失败统计：按服务商编码和失败原因计数
+// @router /providers/callback_rejections [GET]
+func (t *SmsServiceProvidersController) GetCallbackRejections() {
+	t.Data["json"] = map[string]interface{}{
+		"err_code":   0,
+		"err_msg":    "",
+		"rejections": models.GetSmsCallbackRejections(),
+	}
+	t.ServeJSON()
+	return
+}
+
+// 服务商状态报告推送统一回调地址，:code为服务商编码，如：253_CHUANGLAN_SMS_SERVICE
+// 由各服务商注册的回调处理方法解析状态报告并生成响应内容
+// @router /providers/:code/callback [get,post]
+func (t *SmsServiceProvidersController) ReceiptCallback() {
+	code := t.GetString(":code")
+	response, retcode, err := models.HandleSmsCallback(code, models.SMS_CALLBACK_RECEIPT, models.GetClientIp(t.Ctx.Request.RemoteAddr, t.Ctx.Request.Header["X-Forwarded-For"]), t.Input(), t.Ctx.Input.RequestBody)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Ctx.Output.Body(response)
+	return
+}
+
+// 服务商上行短信推送统一回调地址，:code为服务商编码，如：YUNPIAN_SMS_SERVICE
+// @router /providers/:code/inbound [get,post]
+func (t *SmsServiceProvidersController) InboundCallback() {
+	code := t.GetString(":code")
+	response, retcode, err := models.HandleSmsCallback(code, models.SMS_CALLBACK_INBOUND, models.GetClientIp(t.Ctx.Request.RemoteAddr, t.Ctx.Request.Header["X-Forwarded-For"]), t.Input(), t.Ctx.Input.RequestBody)
+	if err != nil {
+		Logger.Error(err.Error())
+		t.Data["json"] = map[string]interface{}{
+			"err_code": retcode,
+			"err_msg":  errors.Cause(err).Error(),
+		}
+		t.ServeJSON()
+		return
+	}
+	t.Ctx.Output.Body(response)
+	return
+}
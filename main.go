@@ -40,6 +40,8 @@ func main() {
 	}
 	fmt.Println("main starting...")
 	go startRPCService(conf.RpcAddr, conf.EtcdAddr, &models.SmsServer{})
+	// 发件箱异步发送协程池
+	models.StartSmsOutboxWorkers(conf.SmsOutboxWorkers)
 
 	beego.Run()
 }
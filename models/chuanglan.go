@@ -5,14 +5,11 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
-	"unicode/utf8"
 
 	utils "github.com/1046102779/common"
 	"github.com/1046102779/common/httpRequest"
 	"github.com/1046102779/sms/conf"
 	. "github.com/1046102779/sms/logger"
-	"github.com/astaxie/beego/orm"
 	"github.com/pkg/errors"
 
 	pb "github.com/1046102779/igrpc"
@@ -37,6 +34,8 @@ import (
 var (
 	SMS_CHUANGLAN_VERIFICATION_TYPE = 1 // 验证码短信，不可退订的, 独立账户
 	SMS_CHUANGLAN_MARKETING_TYPE    = 2 // 营销短信，可退订的, 独立账户
+
+	SMS_CHUANGLAN_MAX_MOBILES = 50000 // 群发手机号个数上限，见错误码108
 )
 
 type ChuanglanInfo struct {
@@ -54,54 +53,72 @@ type ChuanglanInfo struct {
 	Extend               int16  // 可选参数，扩展码，用户定义扩展码,扩展码的长度将直接影响短信上行接收的接收。固需要传扩展码参数时，请提前咨询客服相关设置问题。
 	SignName             string // 短信服务应用签名
 	SmsServiceProviderId int    // 内部短信服务商ID
+
+	SegmentRule *SmsSegmentRule // 短信计费规则，见sms_segments.go
+
+	VoiceAccount  string // 语音验证码账号，为空不开通语音验证码，见配置sms::voice_account
+	VoicePassword string // 语音验证码密码
+	VoiceHttpApi  string // 语音验证码HTTP API
 }
 
-var (
-	chuanglanService *ChuanglanInfo
-	mutex            sync.Mutex
-)
+func init() {
+	RegisterSmsProviderFactory(SMS_SERVICE_PROVIDER_TYPE_253_CHUANGLAN, SMS_SERVICE_PROVIDER_CODE_253_CHUANGLAN, newChuanglanProvider)
+	RegisterSmsCallbackHandler(SMS_SERVICE_PROVIDER_TYPE_253_CHUANGLAN, SMS_SERVICE_PROVIDER_CODE_253_CHUANGLAN, SMS_CALLBACK_RECEIPT, handleChuanglanReceiptCallback)
+	RegisterSmsCallbackHandler(SMS_SERVICE_PROVIDER_TYPE_253_CHUANGLAN, SMS_SERVICE_PROVIDER_CODE_253_CHUANGLAN, SMS_CALLBACK_INBOUND, handleChuanglanInboundCallback)
+}
 
-func GetChuanglanInstance() (instance *ChuanglanInfo) {
-	Logger.Info("enter GetChuanglanInstance.")
-	defer Logger.Info("left GetChuanglanInstance.")
-	mutex.Lock()
-	defer mutex.Unlock()
-	if chuanglanService == nil {
-		// 调用rpcx服务，获取系统配置的253创蓝账号和密码
-		systemConfInfo := &pb.ChuanglanConfInfo{}
-		err := conf.AccountClient.Call(fmt.Sprintf("%s.%s", "accounts", "GetChuanglanAccountInfo"), systemConfInfo, systemConfInfo)
-		if err != nil {
-			fmt.Println(err.Error())
-		}
-		// 获取创蓝服务提供商，单条短信最大长度
-		var (
-			smsServiceProviders []SmsServiceProviders = []SmsServiceProviders{}
-		)
-		o := orm.NewOrm()
-		num, err := o.QueryTable((&SmsServiceProviders{}).TableName()).Filter("type", SMS_SERVICE_PROVIDER_TYPE_253_CHUANGLAN).Filter("status", utils.STATUS_VALID).Filter("is_valid", SMS_SERVICE_VALID).All(&smsServiceProviders)
-		if err != nil {
-			Logger.Error(err.Error())
-			return
-		}
-		if num <= 0 {
-			return nil // 尚未启用创蓝短信服务
-		}
-		chuanglanService = &ChuanglanInfo{
-			VerificationAccount:  systemConfInfo.VerificationAccount,
-			VerificationPassword: systemConfInfo.VerificationPassword,
-			MarketingAccount:     systemConfInfo.MarketingAccount,
-			MarketingPassword:    systemConfInfo.MarketingPassword,
-			HttpApi:              systemConfInfo.HttpApi,
-			ReceiverHttpApi:      systemConfInfo.ReceiverHttpApi,
-			QueryBalanceHttpApi:  systemConfInfo.QueryBalanceHttpApi,
-			SingleSmsMaxLength:   smsServiceProviders[0].SingleSmsMaxLength,
-			SignName:             smsServiceProviders[0].SignName,
-			SmsServiceProviderId: smsServiceProviders[0].Id,
-			ReceivedStatus:       1,
-		}
-		fmt.Println("*chuanglanService: ", *chuanglanService)
+// 创蓝状态报告推送(GET请求)，响应内容不影响创蓝推送
+func handleChuanglanReceiptCallback(provider ISMS, params url.Values, body []byte) (response []byte, retcode int, err error) {
+	if retcode, err = SaveSmsReceiptCallback(provider, params, body); err != nil {
+		return
+	}
+	response = []byte(`{"err_code":0,"err_msg":""}`)
+	return
+}
+
+// 创蓝上行短信推送(GET请求)，响应内容不影响创蓝推送
+func handleChuanglanInboundCallback(provider ISMS, params url.Values, body []byte) (response []byte, retcode int, err error) {
+	if retcode, err = SaveSmsInboundCallback(provider, params, body); err != nil {
+		return
+	}
+	response = []byte(`{"err_code":0,"err_msg":""}`)
+	return
+}
+
+// 创蓝253服务商工厂方法：调用rpcx服务，获取系统配置的253创蓝账号和密码
+func newChuanglanProvider(provider *SmsServiceProviders) (instance ISMS, retcode int, err error) {
+	Logger.Info("[%v] enter newChuanglanProvider.", provider.Id)
+	defer Logger.Info("[%v] left newChuanglanProvider.", provider.Id)
+	systemConfInfo := &pb.ChuanglanConfInfo{}
+	if err = conf.AccountClient.Call(fmt.Sprintf("%s.%s", "accounts", "GetChuanglanAccountInfo"), systemConfInfo, systemConfInfo); err != nil {
+		err = errors.Wrap(err, "newChuanglanProvider")
+		retcode = utils.HTTP_CALL_FAILD_EXTERNAL
+		return
+	}
+	instance = &ChuanglanInfo{
+		VerificationAccount:  systemConfInfo.VerificationAccount,
+		VerificationPassword: systemConfInfo.VerificationPassword,
+		MarketingAccount:     systemConfInfo.MarketingAccount,
+		MarketingPassword:    systemConfInfo.MarketingPassword,
+		HttpApi:              systemConfInfo.HttpApi,
+		ReceiverHttpApi:      systemConfInfo.ReceiverHttpApi,
+		QueryBalanceHttpApi:  systemConfInfo.QueryBalanceHttpApi,
+		SingleSmsMaxLength:   provider.SingleSmsMaxLength,
+		SegmentRule:          newSmsSegmentRule(provider),
+		SignName:             provider.SignName,
+		SmsServiceProviderId: provider.Id,
+		ReceivedStatus:       1,
+		VoiceAccount:         conf.SmsVoiceAccount,
+		VoicePassword:        conf.SmsVoicePassword,
+		VoiceHttpApi:         conf.SmsVoiceHttpApi,
 	}
-	return chuanglanService
+	return
+}
+
+// 从服务商注册表获取创蓝253服务，尚未启用返回nil
+func GetChuanglanInstance() (instance *ChuanglanInfo) {
+	instance, _ = GetSmsProviderByCode(SMS_SERVICE_PROVIDER_CODE_253_CHUANGLAN).(*ChuanglanInfo)
+	return
 }
 
 // rpc获取平台创蓝剩余短信数量和公司剩余短信数量
@@ -156,23 +173,23 @@ func parseChuanglanBody(bodyData []byte) (timeStr string, retcode int, msgid str
 	return
 }
 
-// 发送专用通道短信：是不可退订的
-func (t *ChuanglanInfo) SendVerificationSms(content string, mobiles []string) (countPerSingle int, smsSendCount int, msgid string, retcode int, err error) {
+// 发送专用通道短信：是不可退订的, extend为扩展码，为空不发送
+func (t *ChuanglanInfo) SendVerificationSms(content string, mobiles []string, extend string) (countPerSingle int, smsSendCount int, msgid string, retcode int, err error) {
 	Logger.Info("enter SendVerificationSms.")
 	defer Logger.Info("left SendVerificationSms.")
 	var (
 		bodyData []byte
 	)
-	if mobiles == nil || len(mobiles) <= 0 || strings.TrimSpace(content) == "" || t.SingleSmsMaxLength <= 0 {
+	if mobiles == nil || len(mobiles) <= 0 || strings.TrimSpace(content) == "" {
 		return
 	}
-	charCount := utf8.RuneCountInString(content)
-	countPerSingle = charCount / t.SingleSmsMaxLength
-	if charCount%t.SingleSmsMaxLength > 0 {
-		countPerSingle += 1
-	}
+	// 按计费规则计算条数(含签名)，见sms_segments.go
+	countPerSingle = t.GetSegmentRule().Count(content).Segments
 	smsSendCount = countPerSingle * len(mobiles)
 	httpStr := fmt.Sprintf("%s?account=%s&pswd=%s&mobile=%s&msg=%s&needstatus=true", t.HttpApi, t.VerificationAccount, t.VerificationPassword, strings.Join(mobiles, ","), url.QueryEscape(content))
+	if extend != "" {
+		httpStr = fmt.Sprintf("%s&extno=%s", httpStr, url.QueryEscape(extend))
+	}
 	fmt.Println("uri: ", httpStr)
 	bodyData, err = httpRequest.HttpGetBody(httpStr)
 	if err != nil {
@@ -188,23 +205,23 @@ func (t *ChuanglanInfo) SendVerificationSms(content string, mobiles []string) (c
 	return
 }
 
-// 发送营销短信：是指可以退订的
-func (t *ChuanglanInfo) SendMarketingSms(content string, mobiles []string) (countPerSingle int, smsSendCount int, msgid string, retcode int, err error) {
+// 发送营销短信：是指可以退订的, extend为扩展码，为空不发送
+func (t *ChuanglanInfo) SendMarketingSms(content string, mobiles []string, extend string) (countPerSingle int, smsSendCount int, msgid string, retcode int, err error) {
 	Logger.Info("enter SendMarketingSms.")
 	defer Logger.Info("left SendMarketingSms.")
 	var (
 		bodyData []byte
 	)
-	if mobiles == nil || len(mobiles) <= 0 || strings.TrimSpace(content) == "" || t.SingleSmsMaxLength <= 0 {
+	if mobiles == nil || len(mobiles) <= 0 || strings.TrimSpace(content) == "" {
 		return
 	}
-	charCount := utf8.RuneCountInString(content)
-	countPerSingle = charCount / t.SingleSmsMaxLength
-	if charCount%t.SingleSmsMaxLength > 0 {
-		countPerSingle += 1
-	}
+	// 按计费规则计算条数(含签名)，见sms_segments.go
+	countPerSingle = t.GetSegmentRule().Count(content).Segments
 	smsSendCount = countPerSingle * len(mobiles)
 	httpStr := fmt.Sprintf("%s?account=%s&pswd=%s&mobile=%s&msg=%s&needstatus=true", t.HttpApi, t.MarketingAccount, t.MarketingPassword, strings.Join(mobiles, ","), url.QueryEscape(content))
+	if extend != "" {
+		httpStr = fmt.Sprintf("%s&extno=%s", httpStr, url.QueryEscape(extend))
+	}
 	bodyData, err = httpRequest.HttpGetBody(httpStr)
 	if err != nil {
 		err = errors.Wrap(err, "SendVerificationSms")
@@ -219,30 +236,131 @@ func (t *ChuanglanInfo) SendMarketingSms(content string, mobiles []string) (coun
 	return
 }
 
-func (t *ChuanglanInfo) ReceivedNotification(mobile string, msgid string, code string, reportTime string) (retcode int, err error) {
-	Logger.Info("enter ReceivedNotification.")
-	defer Logger.Info("left ReceivedNotification.")
-	status := t.getReportErrorMessage(code)
-	if status > 0 {
-		o := orm.NewOrm()
-		smsReceiptFailedRecord := &SmsReceiptFailedRecords{
-			MessageId:     msgid,
-			Mobile:        mobile,
-			ReceiptStatus: status,
-			ReceiptAt:     fmt.Sprintf("20%s", reportTime),
+func (t *ChuanglanInfo) GetSmsServiceProviderId() int {
+	return t.SmsServiceProviderId
+}
+
+func (t *ChuanglanInfo) GetSignName() string {
+	return t.SignName
+}
+
+func (t *ChuanglanInfo) GetMaxMobiles() int {
+	return SMS_CHUANGLAN_MAX_MOBILES
+}
+
+// 短信计费规则，未配置时采用默认规则
+func (t *ChuanglanInfo) GetSegmentRule() *SmsSegmentRule {
+	if t.SegmentRule == nil {
+		t.SegmentRule = newSmsSegmentRule(nil)
+	}
+	return t.SegmentRule
+}
+
+// 单条发送, 创蓝单条与批量发送为同一接口
+func (t *ChuanglanInfo) SingleSend(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	return t.BatchSend(req)
+}
+
+// 批量发送相同内容，根据短信类型选择验证码账户或者营销账户
+func (t *ChuanglanInfo) BatchSend(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	if req == nil {
+		err = errors.New("param `sms request` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	result = &SmsResult{
+		SmsServiceProviderId: t.SmsServiceProviderId,
+	}
+	if req.AccountType == SMS_CHUANGLAN_MARKETING_TYPE {
+		result.CountPerSingle, result.Count, result.MessageId, retcode, err = t.SendMarketingSms(req.Content, req.Mobiles, req.ExtendCode)
+	} else {
+		result.CountPerSingle, result.Count, result.MessageId, retcode, err = t.SendVerificationSms(req.Content, req.Mobiles, req.ExtendCode)
+	}
+	return
+}
+
+// 批量发送不同内容，创蓝无此接口，逐个号码发送
+// 部分号码发送失败时不中断，失败的号码记入FailedMobiles，只有全部失败时返回错误
+func (t *ChuanglanInfo) MultiSend(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	var (
+		lastRetcode int
+		lastErr     error
+	)
+	if req == nil || len(req.Contents) != len(req.Mobiles) {
+		err = errors.New("param `contents || mobiles` not match")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	result = &SmsResult{
+		SmsServiceProviderId: t.SmsServiceProviderId,
+		MessageIds:           map[string]string{},
+		FailedMobiles:        map[string]int{},
+	}
+	for index := 0; index < len(req.Mobiles); index++ {
+		singleReq := &SmsRequest{
+			CompanyId:     req.CompanyId,
+			SmsTemplateId: req.SmsTemplateId,
+			AccountType:   req.AccountType,
+			Content:       req.Contents[index],
+			Mobiles:       []string{req.Mobiles[index]},
+			ExtendCode:    req.ExtendCode,
 		}
-		retcode, err = smsReceiptFailedRecord.InsertSmsReceiptFailedRecordNoLock(&o)
-		if err != nil {
-			err = errors.Wrap(err, "ReceivedNotification")
-			return
+		singleResult, code, singleErr := t.BatchSend(singleReq)
+		if singleErr != nil {
+			Logger.Error("[%v] %s", req.Mobiles[index], singleErr.Error())
+			result.FailedMobiles[req.Mobiles[index]] = code
+			lastRetcode, lastErr = code, singleErr
+			continue
 		}
+		result.MessageIds[req.Mobiles[index]] = singleResult.MessageId
+		result.Count += singleResult.Count
+		if singleResult.CountPerSingle > result.CountPerSingle {
+			result.CountPerSingle = singleResult.CountPerSingle
+		}
+	}
+	result.MessageId = getFirstSmsMessageId(req.Mobiles, result.MessageIds)
+	if len(result.MessageIds) <= 0 {
+		retcode, err = lastRetcode, lastErr
+	}
+	return
+}
+
+// 创蓝状态报告推送为GET请求，参数：msgid, reportTime, mobile, status
+func (t *ChuanglanInfo) ParseReceipts(params url.Values, body []byte) (receipts []SmsReceipt, retcode int, err error) {
+	if params == nil || strings.TrimSpace(params.Get("msgid")) == "" {
+		err = errors.New("param `msgid` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	receipts = append(receipts, SmsReceipt{
+		MessageId:     params.Get("msgid"),
+		Mobile:        params.Get("mobile"),
+		ReceiptStatus: t.getReportErrorMessage(params.Get("status")),
+		ReceiptAt:     fmt.Sprintf("20%s", params.Get("reportTime")),
+	})
+	return
+}
+
+// 创蓝上行短信推送为GET请求，参数：moTime(yyMMddHHmm), mobile, msg, destcode(接收号码，含扩展码)
+func (t *ChuanglanInfo) ParseInbounds(params url.Values, body []byte) (inbounds []SmsInbound, retcode int, err error) {
+	if params == nil || strings.TrimSpace(params.Get("mobile")) == "" {
+		err = errors.New("param `mobile` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
 	}
+	inbounds = append(inbounds, SmsInbound{
+		Mobile:     strings.TrimSpace(params.Get("mobile")),
+		Content:    params.Get("msg"),
+		ExtendCode: params.Get("destcode"),
+		ReceivedAt: fmt.Sprintf("20%s", params.Get("moTime")),
+	})
 	return
 }
 
 // 额度查询接口
 // @param accountType : 账户类型，1.验证码短信是不可退订的，属于verification_account
-//								  2.营销短信是可退订的，属于marketing_account
+//
+//	2.营销短信是可退订的，属于marketing_account
 func (t *ChuanglanInfo) QueryBalance(accountType int16) (remainingCount int, retcode int, err error) {
 	Logger.Info("enter QueryBalance.")
 	defer Logger.Info("left QueryBalance.")
@@ -296,6 +414,29 @@ func (t *ChuanglanInfo) getReportErrorMessage(code string) (status int16) {
 	return
 }
 
+// 可重试的错误码：账户、额度、流速、系统忙等服务商侧原因，以及HTTP调用失败
+// 105敏感短信、106消息长度错、107错误手机号、108号码个数错、116签名不合法等为短信自身问题，不重试
+func (t *ChuanglanInfo) IsRetryableError(retcode int) bool {
+	switch retcode {
+	case utils.HTTP_CALL_FAILD_EXTERNAL:
+		return true
+	case 101, 102, 103, 104, 109, 110, 117, 118, 119, 120, 122:
+		return true
+	}
+	return false
+}
+
+// 103: 提交过快；104: 系统忙；110: 不在发送时间内；122: 5分钟内相同内容提交过多
+func (t *ChuanglanInfo) IsTransientError(retcode int) bool {
+	switch retcode {
+	case utils.HTTP_CALL_FAILD_EXTERNAL:
+		return true
+	case 103, 104, 110, 122:
+		return true
+	}
+	return false
+}
+
 func (t *ChuanglanInfo) getErrorMessage(errcode int) (err error) {
 	var (
 		message string
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/common/httpRequest"
+	. "github.com/1046102779/sms/logger"
+	"github.com/pkg/errors"
+)
+
+/*
+	创蓝253语音验证码
+	>> 接口与短信接口一致：GET请求，参数account、pswd、mobile、msg(验证码)、needstatus
+	>> 响应格式与短信提交响应一致，第一行为响应时间和提交状态，第二行为呼叫ID，见parseChuanglanBody
+	>> 语音验证码账号、密码和HTTP API取自配置sms::voice_account、voice_password、voice_http_api，账号未配置时不开通
+*/
+
+// 是否已开通语音验证码
+func (t *ChuanglanInfo) IsVoiceEnabled() bool {
+	return t.VoiceHttpApi != "" && t.VoiceAccount != ""
+}
+
+// 拨打语音电话播报验证码
+func (t *ChuanglanInfo) SendVoiceCode(mobile string, code string) (callId string, retcode int, err error) {
+	Logger.Info("[%v] enter SendVoiceCode.", mobile)
+	defer Logger.Info("[%v] left SendVoiceCode.", mobile)
+	var (
+		bodyData []byte
+	)
+	if strings.TrimSpace(mobile) == "" || strings.TrimSpace(code) == "" {
+		err = errors.New("param `mobile || code` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if !t.IsVoiceEnabled() {
+		err = errors.New("chuanglan voice verification unabled")
+		retcode = SMS_VOICE_UNAVAILABLE
+		return
+	}
+	httpStr := fmt.Sprintf("%s?account=%s&pswd=%s&mobile=%s&msg=%s&needstatus=true", t.VoiceHttpApi, t.VoiceAccount, t.VoicePassword, mobile, url.QueryEscape(code))
+	if bodyData, err = httpRequest.HttpGetBody(httpStr); err != nil {
+		err = errors.Wrap(err, "SendVoiceCode")
+		retcode = utils.HTTP_CALL_FAILD_EXTERNAL
+		return
+	}
+	_, retcode, callId = parseChuanglanBody(bodyData)
+	if retcode != 0 {
+		err = t.getErrorMessage(retcode)
+	}
+	return
+}
@@ -3,28 +3,193 @@ package models
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/astaxie/beego/orm"
 
 	utils "github.com/1046102779/common"
-	. "github.com/1046102779/common/utils"
 	pb "github.com/1046102779/igrpc"
 	. "github.com/1046102779/sms/logger"
+	"github.com/pkg/errors"
 )
 
 type SmsServer struct{}
 
+// rpcx发送短信
+// Contents只有一条时所有手机号发送相同内容，否则与Mobiles一一对应
+// pb.SmsRequest只有Mobiles和Contents，不能携带公司ID、短信类型和定时发送时间：
+// 按平台自身发送的营销短信处理，过滤已退订的手机号，写入发件箱，在发送时间窗口内由后台协程发送并扣除平台短信数量
+// pb.CodeReply只有RetCode和ErrMsg，不能返回发件箱短信ID、消息ID和每个手机号的发送结果；
+// 无效的、黑名单中和已退订的手机号通过ErrMsg返回，其他手机号写入发件箱成功时RetCode为0
+// 业务错误通过RetCode和ErrMsg返回，不返回rpc错误
 func (t *SmsServer) SendSingleSms(in *pb.SmsRequest, out *pb.CodeReply) (err error) {
-	Logger.Info("enter SendSingleSms.")
-	defer Logger.Info("left SendSingleSms.")
-	defer func() {
-		err = nil
-	}()
+	Logger.Info("[%v] enter SendSingleSms.", in.Mobiles)
+	defer Logger.Info("[%v] left SendSingleSms.", in.Mobiles)
 	if in.Mobiles == nil || len(in.Mobiles) <= 0 || in.Contents == nil || len(in.Contents) <= 0 {
 		out.RetCode = utils.SOURCE_DATA_ILLEGAL
 		out.ErrMsg = fmt.Sprintf("param `mobile | content` empty")
 		return
 	}
+	if len(in.Contents) != 1 && len(in.Contents) != len(in.Mobiles) {
+		out.RetCode = utils.SOURCE_DATA_ILLEGAL
+		out.ErrMsg = fmt.Sprintf("param `contents` count unmatched with `mobiles`")
+		return
+	}
+	req := &SmsRequest{
+		CompanyId:   -1,
+		AccountType: SMS_CHUANGLAN_MARKETING_TYPE,
+		Mobiles:     in.Mobiles,
+	}
+	if len(in.Contents) == 1 {
+		req.Content = in.Contents[0]
+	} else {
+		req.Contents = in.Contents
+	}
+	sendSmsRequest(req, out)
+	return
+}
+
+// rpcx按模板名称和命名参数发送短信
+// pb没有模板短信请求，复用pb.SmsRequest：Contents[0]为模板名称，其余每项为一个模板命名参数，格式：name=value
+// 平台自身发送：短信验证码模板(MOBILE_VERIFICATION_CODE_CONTENT)立即发送，其他模板按营销短信写入发件箱
+// 模板不存在或者参数不完整时不发送，通过RetCode和ErrMsg返回缺少的变量和多余的变量
+func (t *SmsServer) SendTemplateSms(in *pb.SmsRequest, out *pb.CodeReply) (err error) {
+	Logger.Info("[%v] enter SendTemplateSms.", in.Mobiles)
+	defer Logger.Info("[%v] left SendTemplateSms.", in.Mobiles)
+	if len(in.Mobiles) <= 0 || len(in.Contents) <= 0 || strings.TrimSpace(in.Contents[0]) == "" {
+		out.RetCode = utils.SOURCE_DATA_ILLEGAL
+		out.ErrMsg = "param `mobiles | template_name` empty"
+		return
+	}
+	params, retcode, parseErr := ParseSmsTemplateParams(in.Contents[1:])
+	if parseErr != nil {
+		Logger.Error(parseErr.Error())
+		out.RetCode = int64(retcode)
+		out.ErrMsg = parseErr.Error()
+		return
+	}
+	req := &SmsRequest{
+		CompanyId:      -1,
+		AccountType:    SMS_CHUANGLAN_MARKETING_TYPE,
+		TemplateName:   strings.TrimSpace(in.Contents[0]),
+		TemplateParams: params,
+		Mobiles:        in.Mobiles,
+	}
+	if req.TemplateName == MOBILE_VERIFICATION_CODE_CONTENT {
+		req.AccountType = SMS_CHUANGLAN_VERIFICATION_TYPE
+	}
+	// 发送前校验模板和参数
+	if retcode, validateErr := ValidateSmsTemplateRequest(req); validateErr != nil {
+		Logger.Error(validateErr.Error())
+		out.RetCode = int64(retcode)
+		out.ErrMsg = errors.Cause(validateErr).Error()
+		return
+	}
+	sendSmsRequest(req, out)
+	return
+}
+
+// rpcx发送短信请求：过滤敏感词和手机号，检查剩余短信数量，验证码短信立即发送，其他短信写入发件箱，发送结果写入out
+// 部分手机号被过滤或者发送失败时RetCode为0，ErrMsg说明这些手机号；没有发送成功的手机号时RetCode为错误码
+func sendSmsRequest(req *SmsRequest, out *pb.CodeReply) {
+	// 敏感词检查：拒绝发送或者替换为*
+	if _, retcode, sensitiveErr := FilterSmsSensitiveWords(req); sensitiveErr != nil {
+		Logger.Error(sensitiveErr.Error())
+		out.RetCode = int64(retcode)
+		out.ErrMsg = errors.Cause(sensitiveErr).Error()
+		return
+	}
+	// 无效的、黑名单中和已退订营销短信的手机号不发送，通过ErrMsg返回给调用方
+	filtered, retcode, filterErr := FilterSmsRequest(req)
+	if filterErr != nil {
+		Logger.Error(filterErr.Error())
+		out.RetCode = int64(retcode)
+		out.ErrMsg = errors.Cause(filterErr).Error()
+		return
+	}
+	if len(req.Mobiles) <= 0 {
+		out.RetCode = int64(filtered.Retcode())
+		out.ErrMsg = fmt.Sprintf("all mobiles invalid, blacklisted or opted out, %s", filtered.Summary())
+		return
+	}
+	// 平台或者公司短信数量不足时不发送
+	if retcode, quotaErr := checkSmsRemaining(req); quotaErr != nil {
+		Logger.Error(quotaErr.Error())
+		out.RetCode = int64(retcode)
+		out.ErrMsg = errors.Cause(quotaErr).Error()
+		return
+	}
+	// 验证码以外的短信受发送时间窗口限制，写入发件箱，由后台协程在允许发送的时间发送
+	if req.AccountType != SMS_CHUANGLAN_VERIFICATION_TYPE {
+		if _, retcode, enqueueErr := EnqueueSms(req, time.Time{}); enqueueErr != nil {
+			Logger.Error(enqueueErr.Error())
+			out.RetCode = int64(retcode)
+			out.ErrMsg = errors.Cause(enqueueErr).Error()
+			return
+		}
+		out.ErrMsg = filtered.Summary()
+		return
+	}
+	// 按服务商优先级发送，发送记录和接收人送达状态由RouteSms写入
+	result, retcode, sendErr := RouteSms(req)
+	if sendErr != nil {
+		Logger.Error(sendErr.Error())
+		out.RetCode = int64(retcode)
+		out.ErrMsg = errors.Cause(sendErr).Error()
+		return
+	}
+	DeductSmsRemaining(req, result)
+	out.ErrMsg = joinSmsSendSummaries(filtered.Summary(), getFailedSmsSummary(req, result))
+	return
+}
+
+// 发送失败的手机号说明，如：failed: 13800138000(33),13900139000(33)，括号内为错误码；没有失败的手机号时为空
+func getFailedSmsSummary(req *SmsRequest, result *SmsResult) string {
+	items := []string{}
+	for _, mobile := range result.GetFailedMobiles(req.Mobiles) {
+		items = append(items, fmt.Sprintf("%s(%d)", mobile, result.FailedMobiles[mobile]))
+	}
+	if len(items) <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("failed: %s", strings.Join(items, ","))
+}
+
+// 拼接不为空的说明，以"; "分隔
+func joinSmsSendSummaries(summaries ...string) string {
+	items := []string{}
+	for _, summary := range summaries {
+		if summary != "" {
+			items = append(items, summary)
+		}
+	}
+	return strings.Join(items, "; ")
+}
+
+// 检查剩余短信数量：平台对应类型的短信数量已用完时不发送；公司发送时，按优先服务商的计费条数检查公司剩余短信数量
+func checkSmsRemaining(req *SmsRequest) (retcode int, err error) {
+	platformVerificationCount, platformMarketingCount, companySmsRemainingCount := GetChuanglanRemainingSMS(int64(req.CompanyId))
+	platformCount := platformMarketingCount
+	if req.AccountType == SMS_CHUANGLAN_VERIFICATION_TYPE {
+		platformCount = platformVerificationCount
+	}
+	if platformCount <= 0 {
+		err = errors.New("platform sms remaining count not enough")
+		retcode = utils.SMS_CHUANGLAN_REMAINING_NOT_ENOUGH
+		return
+	}
+	if req.CompanyId <= 0 {
+		return
+	}
+	count := len(req.Mobiles)
+	if estimates, _, estimateErr := EstimateSms(req, 0); estimateErr == nil && len(estimates) > 0 {
+		count = estimates[0].Count
+	}
+	if companySmsRemainingCount < int64(count) {
+		err = errors.Errorf("sms remaining count not enough, need %d, remaining %d", count, companySmsRemainingCount)
+		retcode = utils.SMS_CHUANGLAN_REMAINING_NOT_ENOUGH
+		return
+	}
 	return
 }
 
@@ -48,31 +213,20 @@ func (t *SmsServer) UpdateSmsRechargeInfo(in *pb.SmsRechargeOrderInfo, out *pb.S
 }
 
 func (t *SmsServer) CodeMatch(in *pb.CodeRequest, reply *pb.CodeReply) (err error) {
-	Logger.Info("[%v.%v] enter CodeMatch", in.Mobile, in.Code)
-	defer Logger.Info("[%v.%v] left CodeMatch", in.Mobile, in.Code)
-	var (
-		code string
-	)
+	Logger.Info("[%v] enter CodeMatch", in.Mobile)
+	defer Logger.Info("[%v] left CodeMatch", in.Mobile)
 	if strings.TrimSpace(in.Mobile) == "" || strings.TrimSpace(in.Code) == "" {
+		reply.RetCode = utils.SOURCE_DATA_ILLEGAL
 		reply.ErrMsg = "param `mobile or code` empty!"
 		return
 	}
-	key := "SMS:" + in.Mobile + ":LOGIN"
-	if code, err = GetCode(key); err != nil {
-		Logger.Error("get redis err: " + err.Error())
+	// pb.CodeRequest不区分用途，校验登录验证码；其他用途通过HTTP接口校验。校验成功后验证码失效
+	if retcode, verifyErr := VerifySmsVerificationCode(in.Mobile, SMS_VERIFICATION_PURPOSE_LOGIN, in.Code); verifyErr != nil {
+		Logger.Error(verifyErr.Error())
 		*reply = pb.CodeReply{
-			RetCode: utils.REDIS_GET_FAILED,
-			ErrMsg:  err.Error(),
+			RetCode: int64(retcode),
+			ErrMsg:  errors.Cause(verifyErr).Error(),
 		}
-		err = nil
-		return
-	}
-	if code != in.Code {
-		*reply = pb.CodeReply{
-			RetCode: utils.VERIFICATION_NOT_MATCH,
-			ErrMsg:  "verification code unmatched",
-		}
-
 	}
 	return
 }
@@ -1,6 +1,224 @@
 package models
 
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/orm"
+)
+
 // 公共短信接口列表,类似多态
+// 创蓝253、云片网等短信服务提供商均实现该接口，业务层只通过ISMS发送短信，不关心当前启用的是哪家服务商
 type ISMS interface {
-	SendSMS(interface{}) (int, error)
+	// 内部短信服务商ID，对应sms_service_providers表主键
+	GetSmsServiceProviderId() int
+	// 短信服务应用签名
+	GetSignName() string
+	// 单次请求最多手机号个数
+	GetMaxMobiles() int
+	// 短信计费规则
+	GetSegmentRule() *SmsSegmentRule
+	// 单条发送
+	SingleSend(req *SmsRequest) (result *SmsResult, retcode int, err error)
+	// 批量发送相同内容
+	BatchSend(req *SmsRequest) (result *SmsResult, retcode int, err error)
+	// 批量发送不同内容, req.Contents与req.Mobiles一一对应
+	MultiSend(req *SmsRequest) (result *SmsResult, retcode int, err error)
+	// 额度查询, accountType: 1.验证码短信账户；2.营销短信账户
+	QueryBalance(accountType int16) (remainingCount int, retcode int, err error)
+	// 解析服务商推送的状态报告, params为回调请求参数，body为回调请求体
+	ParseReceipts(params url.Values, body []byte) (receipts []SmsReceipt, retcode int, err error)
+	// 解析服务商推送的上行短信(用户回复), params为回调请求参数，body为回调请求体
+	ParseInbounds(params url.Values, body []byte) (inbounds []SmsInbound, retcode int, err error)
+	// 发送失败的错误码是否可重试：服务商侧故障(系统忙、无额度、HTTP调用失败等)可切换服务商重试，
+	// 短信自身问题(敏感词、号码错误、内容长度等)换服务商也会失败，不重试
+	IsRetryableError(retcode int) bool
+	// 发送失败的错误码是否为暂时性错误：提交过快、系统忙、HTTP调用失败等，稍后重新发送可能成功，
+	// 账户配置问题(无此用户、密码错误等)和短信自身问题不会随时间恢复，不重新发送
+	IsTransientError(retcode int) bool
+}
+
+// 短信发送请求
+type SmsRequest struct {
+	CompanyId      int               // 公司ID, -1: 平台自身发送，如短信验证码
+	SmsTemplateId  int               // 短信模板ID, 无模板为0
+	AccountType    int               // 短信类型: SMS_CHUANGLAN_VERIFICATION_TYPE 验证码短信; SMS_CHUANGLAN_MARKETING_TYPE 营销短信
+	TemplateName   string            // 短信模板名称，不为空时按各服务商的同名模板生成短信内容
+	TemplateArgs   []interface{}     // 模板参数，不含签名，按模板变量顺序对应
+	TemplateParams map[string]string // 模板命名参数，不为空时优先于TemplateArgs，见sms_template_render.go
+	Content        string            // 短信内容(已包含签名)，批量发送相同内容时使用
+	Contents       []string          // 短信内容列表，与Mobiles一一对应，批量发送不同内容时使用
+	Mobiles        []string          // 接收短信的手机号列表
+	OutboxId       int               // 发件箱短信ID，非发件箱发送为0
+	ExtendCode     string            // 扩展码，随短信发送给服务商，上行短信按扩展码关联原发送，为空不发送
+}
+
+// 短信发送结果
+type SmsResult struct {
+	SmsServiceProviderId int               // 实际发送短信的服务商ID
+	MessageId            string            // 第三方短信消息ID，各手机号的消息ID不同时为第一个手机号的消息ID
+	MessageIds           map[string]string // 手机号对应的第三方短信消息ID，所有手机号共用MessageId时为空
+	CountPerSingle       int               // 单条短信内容被拆分的条数
+	Count                int               // 短信使用条数
+	Fee                  int               // 扣费金额，单位：分，服务商未返回时为0
+
+	FailedMobiles  map[string]int // 发送失败的手机号及错误码，部分手机号发送成功时不为空
+	ProviderCounts map[int]int    // 各服务商使用的短信条数，部分手机号切换服务商发送时不为空
+}
+
+// 手机号对应的第三方短信消息ID
+func (t *SmsResult) GetMessageId(mobile string) string {
+	if msgid, exist := t.MessageIds[mobile]; exist {
+		return msgid
+	}
+	return t.MessageId
+}
+
+// 按手机号顺序取第一个第三方短信消息ID，作为整批短信的消息ID写入发件箱和短信发送记录
+// 每个手机号的消息ID见MessageIds，写入sms_deliveries；不拼接全部消息ID，避免超出字段长度
+func getFirstSmsMessageId(mobiles []string, msgids map[string]string) string {
+	for _, mobile := range mobiles {
+		if msgid, exist := msgids[mobile]; exist {
+			return msgid
+		}
+	}
+	return ""
+}
+
+// 发送失败的手机号，按mobiles顺序排列
+func (t *SmsResult) GetFailedMobiles(mobiles []string) (failed []string) {
+	for _, mobile := range mobiles {
+		if _, exist := t.FailedMobiles[mobile]; exist {
+			failed = append(failed, mobile)
+		}
+	}
+	return
+}
+
+// 创蓝平台账户消耗的短信条数，由其他服务商发送时为0
+func (t *SmsResult) ChuanglanCount() int {
+	instance := GetChuanglanInstance()
+	if instance == nil {
+		return 0
+	}
+	if t.ProviderCounts != nil {
+		return t.ProviderCounts[instance.SmsServiceProviderId]
+	}
+	if instance.SmsServiceProviderId == t.SmsServiceProviderId {
+		return t.Count
+	}
+	return 0
+}
+
+// 合并其他服务商发送的结果(失败的手机号切换服务商，或者按路由规则分组发送)，mobiles为原请求的手机号，sentMobiles为other发送的手机号
+func (t *SmsResult) merge(other *SmsResult, mobiles []string, sentMobiles []string) {
+	if t.ProviderCounts == nil {
+		t.ProviderCounts = map[int]int{
+			t.SmsServiceProviderId: t.Count,
+		}
+	}
+	if other.Count > 0 {
+		t.ProviderCounts[other.SmsServiceProviderId] += other.Count
+	}
+	t.Count += other.Count
+	t.Fee += other.Fee
+	if other.CountPerSingle > t.CountPerSingle {
+		t.CountPerSingle = other.CountPerSingle
+	}
+	if t.MessageIds == nil {
+		// 原结果所有手机号共用MessageId，合并前记到发送成功的每个手机号上
+		t.MessageIds = map[string]string{}
+		for _, mobile := range mobiles {
+			if _, failed := t.FailedMobiles[mobile]; !failed && t.MessageId != "" {
+				t.MessageIds[mobile] = t.MessageId
+			}
+		}
+	}
+	if t.FailedMobiles == nil {
+		t.FailedMobiles = map[string]int{}
+	}
+	for _, mobile := range sentMobiles {
+		if _, failed := other.FailedMobiles[mobile]; failed {
+			t.FailedMobiles[mobile] = other.FailedMobiles[mobile]
+			continue
+		}
+		delete(t.FailedMobiles, mobile)
+		t.MessageIds[mobile] = other.GetMessageId(mobile)
+	}
+	t.MessageId = getFirstSmsMessageId(mobiles, t.MessageIds)
+	return
+}
+
+// 短信状态报告, ReceiptStatus统一采用创蓝253状态码：0: 送达成功；11~18: 失败原因，详见sms_receipt_failed_records表
+type SmsReceipt struct {
+	MessageId     string
+	Mobile        string
+	ReceiptStatus int16
+	ReceiptAt     string
+}
+
+// 上行短信(用户回复)
+type SmsInbound struct {
+	MessageId  string // 第三方上行短信ID，服务商未返回时为空
+	Mobile     string // 回复的手机号
+	Content    string // 回复内容
+	ExtendCode string // 扩展码(接收号码尾号)，服务商未返回时为空
+	ReceivedAt string // 回复时间，格式：yyyyMMddHHmm
+}
+
+// 扣除公司所发送的短信数量和创蓝平台短信数量, 平台自身发送(companyId<=0)只扣除平台数量
+func DeductSmsRemaining(req *SmsRequest, result *SmsResult) {
+	var (
+		companySmsInc int64
+	)
+	if req == nil || result == nil {
+		return
+	}
+	if req.CompanyId > 0 {
+		companySmsInc = int64(-1 * result.Count)
+	}
+	UpdateChuanglanRemaingSMS(int64(req.CompanyId), 0, int64(-1*result.ChuanglanCount()), companySmsInc)
+	return
+}
+
+// 根据请求选择服务商的单条、批量相同内容或者批量不同内容发送接口
+func dispatchSms(provider ISMS, req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	switch {
+	case len(req.Contents) > 0:
+		result, retcode, err = provider.MultiSend(req)
+	case len(req.Mobiles) == 1:
+		result, retcode, err = provider.SingleSend(req)
+	default:
+		result, retcode, err = provider.BatchSend(req)
+	}
+	if result == nil {
+		result = &SmsResult{
+			SmsServiceProviderId: provider.GetSmsServiceProviderId(),
+		}
+	}
+	return
+}
+
+// 增加短信发送记录
+func insertSmsSendRecord(req *SmsRequest, result *SmsResult, sendRetcode int) (retcode int, err error) {
+	content := req.Content
+	if len(req.Contents) > 0 {
+		content = strings.Join(req.Contents, ",")
+	}
+	o := orm.NewOrm()
+	record := &SmsSendRecords{
+		SmsTemplateId:        req.SmsTemplateId,
+		SmsServiceProviderId: result.SmsServiceProviderId,
+		CompanyId:            req.CompanyId,
+		Content:              content,
+		ReceiverMobiles:      strings.Join(req.Mobiles, ","),
+		SendStatus:           fmt.Sprintf("%d", sendRetcode),
+		Count:                result.Count,
+		CountPerContent:      int16(result.CountPerSingle),
+		MessageId:            result.MessageId,
+		SendAt:               time.Now(),
+	}
+	return record.InsertSmsSendRecordNoLock(&o)
 }
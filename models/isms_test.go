@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestSmsResultMerge(t *testing.T) {
+	// 创蓝批量发送所有手机号共用消息ID，失败的手机号切换云片网发送
+	result := &SmsResult{
+		SmsServiceProviderId: 1,
+		MessageId:            "cl-1",
+		Count:                2,
+		FailedMobiles:        map[string]int{"8613800000003": 104},
+	}
+	other := &SmsResult{
+		SmsServiceProviderId: 2,
+		MessageId:            "yp-3",
+		MessageIds:           map[string]string{"8613800000003": "yp-3"},
+		Count:                1,
+	}
+	mobiles := []string{"8613800000001", "8613800000002", "8613800000003"}
+	result.merge(other, mobiles, []string{"8613800000003"})
+	expected := map[string]string{
+		"8613800000001": "cl-1",
+		"8613800000002": "cl-1",
+		"8613800000003": "yp-3",
+	}
+	for mobile, msgid := range expected {
+		if result.GetMessageId(mobile) != msgid {
+			t.Errorf("GetMessageId(%s) = %q, expected %q", mobile, result.GetMessageId(mobile), msgid)
+		}
+	}
+	if result.MessageId != "cl-1" {
+		t.Errorf("MessageId = %q, expected %q", result.MessageId, "cl-1")
+	}
+	if len(result.FailedMobiles) != 0 {
+		t.Errorf("FailedMobiles = %v, expected empty", result.FailedMobiles)
+	}
+	if result.Count != 3 || result.ProviderCounts[1] != 2 || result.ProviderCounts[2] != 1 {
+		t.Errorf("Count = %d, ProviderCounts = %v, expected 3, map[1:2 2:1]", result.Count, result.ProviderCounts)
+	}
+}
+
+func TestGetFirstSmsMessageId(t *testing.T) {
+	msgids := map[string]string{
+		"8613800000002": "yp-2",
+		"8613800000003": "yp-3",
+	}
+	cases := []struct {
+		mobiles  []string
+		expected string
+	}{
+		{[]string{"8613800000001", "8613800000002", "8613800000003"}, "yp-2"},
+		{[]string{"8613800000003", "8613800000002"}, "yp-3"},
+		{[]string{"8613800000001"}, ""},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		if msgid := getFirstSmsMessageId(c.mobiles, msgids); msgid != c.expected {
+			t.Errorf("getFirstSmsMessageId(%v) = %q, expected %q", c.mobiles, msgid, c.expected)
+		}
+	}
+}
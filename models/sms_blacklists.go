@@ -0,0 +1,327 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/1046102779/sms/phone"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	手机号黑名单
+	>> 状态报告为DTBLACK(15: 黑名单号码)、REJECTD(14: 审核驳回)时，手机号自动加入全局黑名单(company_id=0)
+	>> 公司也可以手动添加、删除自己的黑名单
+	>> 每次发送前(HTTP接口、rpcx接口、发件箱发送)去掉全局和公司黑名单中的手机号，验证码短信同样过滤；
+	   被过滤的手机号返回给调用方，不静默丢弃
+	>> 过滤黑名单前先规范化手机号(见phone包)：格式错误、号段不存在、重复以及不允许的国际号码不发送，
+	   返回每个号码的拒绝原因
+*/
+
+const (
+	// 错误码
+	SMS_MOBILE_BLACKLISTED = 12034 // 手机号在黑名单中
+	SMS_MOBILE_INVALID     = 12035 // 手机号格式错误或者号段不存在
+)
+
+var (
+	// 黑名单来源：10: 状态报告；20: 公司手动添加
+	SMS_BLACKLIST_SOURCE_RECEIPT = 10
+	SMS_BLACKLIST_SOURCE_MANUAL  = 20
+)
+
+type SmsBlacklists struct {
+	Id            int       `orm:"column(sms_blacklist_id);auto"`
+	CompanyId     int       `orm:"column(company_id);null"`
+	Mobile        string    `orm:"column(mobile);size(20);null"`
+	Source        int16     `orm:"column(source);null"`
+	ReceiptStatus int16     `orm:"column(receipt_status);null"`
+	Remark        string    `orm:"column(remark);size(200);null"`
+	Status        int16     `orm:"column(status);null"`
+	UpdatedAt     time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt     time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsBlacklists) TableName() string {
+	return "sms_blacklists"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsBlacklists))
+}
+
+// 发送前被过滤的手机号
+type SmsFilteredMobiles struct {
+	InvalidMobiles   []phone.Rejection // 无效的手机号及原因
+	BlacklistMobiles []string          // 黑名单中的手机号
+	OptOutMobiles    []string          // 已退订营销短信的手机号
+}
+
+// 被过滤的手机号个数
+func (t *SmsFilteredMobiles) Count() int {
+	return len(t.InvalidMobiles) + len(t.BlacklistMobiles) + len(t.OptOutMobiles)
+}
+
+// 所有手机号均被过滤时返回的错误码
+func (t *SmsFilteredMobiles) Retcode() int {
+	if len(t.BlacklistMobiles) <= 0 && len(t.OptOutMobiles) <= 0 {
+		return SMS_MOBILE_INVALID
+	}
+	if len(t.BlacklistMobiles) > 0 && len(t.OptOutMobiles) <= 0 {
+		return SMS_MOBILE_BLACKLISTED
+	}
+	return SMS_MOBILE_OPTED_OUT
+}
+
+// 无效的手机号，重复的手机号已保留一个发送，不包含在内
+func (t *SmsFilteredMobiles) InvalidMobileList() (mobiles []string) {
+	mobiles = []string{}
+	for _, rejection := range t.InvalidMobiles {
+		if rejection.Reason != phone.REJECT_DUPLICATE {
+			mobiles = append(mobiles, rejection.Mobile)
+		}
+	}
+	return
+}
+
+// 被过滤的手机号说明，如：invalid: 123; blacklisted: 13800138000; opted out: 13900139000，没有被过滤的手机号时为空
+func (t *SmsFilteredMobiles) Summary() string {
+	items := []string{}
+	for _, item := range []struct {
+		name    string
+		mobiles []string
+	}{
+		{"invalid", t.InvalidMobileList()},
+		{"blacklisted", t.BlacklistMobiles},
+		{"opted out", t.OptOutMobiles},
+	} {
+		if len(item.mobiles) > 0 {
+			items = append(items, fmt.Sprintf("%s: %s", item.name, strings.Join(item.mobiles, ",")))
+		}
+	}
+	return strings.Join(items, "; ")
+}
+
+// 添加黑名单手机号，已存在则更新来源，已删除则恢复
+func AddSmsBlacklist(companyId int, mobile string, source int, receiptStatus int16, remark string) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter AddSmsBlacklist.", companyId, mobile)
+	defer Logger.Info("[%v.%v] left AddSmsBlacklist.", companyId, mobile)
+	var (
+		blacklists []SmsBlacklists = []SmsBlacklists{}
+	)
+	if mobile, retcode, err = normalizeSmsMobile(mobile); err != nil {
+		err = errors.Wrap(err, "AddSmsBlacklist")
+		return
+	}
+	now := time.Now()
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsBlacklists{}).TableName()).Filter("company_id", companyId).Filter("mobile", mobile).All(&blacklists); err != nil {
+		err = errors.Wrap(err, "AddSmsBlacklist")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if len(blacklists) > 0 {
+		blacklists[0].Source = int16(source)
+		blacklists[0].ReceiptStatus = receiptStatus
+		blacklists[0].Remark = remark
+		blacklists[0].Status = int16(utils.STATUS_VALID)
+		blacklists[0].UpdatedAt = now
+		if _, err = o.Update(&blacklists[0], "source", "receipt_status", "remark", "status", "updated_at"); err != nil {
+			err = errors.Wrap(err, "AddSmsBlacklist")
+			retcode = utils.DB_UPDATE_ERROR
+			return
+		}
+		return
+	}
+	blacklist := &SmsBlacklists{
+		CompanyId:     companyId,
+		Mobile:        mobile,
+		Source:        int16(source),
+		ReceiptStatus: receiptStatus,
+		Remark:        remark,
+		Status:        int16(utils.STATUS_VALID),
+		UpdatedAt:     now,
+		CreatedAt:     now,
+	}
+	if _, err = o.Insert(blacklist); err != nil {
+		err = errors.Wrap(err, "AddSmsBlacklist")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 删除公司的黑名单手机号；全局黑名单不受影响
+func RemoveSmsBlacklist(companyId int, mobile string) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter RemoveSmsBlacklist.", companyId, mobile)
+	defer Logger.Info("[%v.%v] left RemoveSmsBlacklist.", companyId, mobile)
+	if normalized, _, normalizeErr := normalizeSmsMobile(mobile); normalizeErr == nil {
+		mobile = normalized
+	}
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsBlacklists{}).TableName()).Filter("company_id", companyId).Filter("mobile", strings.TrimSpace(mobile)).Filter("status", utils.STATUS_VALID).Update(orm.Params{
+		"status":     SMS_STATUS_DELETED,
+		"updated_at": time.Now(),
+	}); err != nil {
+		err = errors.Wrap(err, "RemoveSmsBlacklist")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 获取公司的黑名单列表，mobile不为空时只查询该手机号
+func GetSmsBlacklists(companyId int, mobile string, offset int64, limit int64) (blacklists []SmsBlacklists, count int64, retcode int, err error) {
+	Logger.Info("[%v] enter GetSmsBlacklists.", companyId)
+	defer Logger.Info("[%v] left GetSmsBlacklists.", companyId)
+	blacklists = []SmsBlacklists{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsBlacklists{}).TableName()).Filter("company_id", companyId).Filter("status", utils.STATUS_VALID)
+	if mobile != "" {
+		qs = qs.Filter("mobile", mobile)
+	}
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetSmsBlacklists")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("-id").Limit(limit, offset).All(&blacklists); err != nil {
+		err = errors.Wrap(err, "GetSmsBlacklists")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+// 状态报告为黑名单号码或者审核驳回时，手机号加入全局黑名单
+func addSmsBlacklistByReceipt(receipt *SmsReceipt) {
+	var (
+		remark string
+	)
+	switch receipt.ReceiptStatus {
+	case 14:
+		remark = "REJECTD"
+	case 15:
+		remark = "DTBLACK"
+	default:
+		return
+	}
+	if _, err := AddSmsBlacklist(0, receipt.Mobile, SMS_BLACKLIST_SOURCE_RECEIPT, receipt.ReceiptStatus, remark); err != nil {
+		Logger.Error(err.Error())
+	}
+	return
+}
+
+// 查询手机号中在全局黑名单或者公司黑名单中的手机号
+func GetSmsBlacklistMobiles(companyId int, mobiles []string) (blacklistMobiles map[string]bool, retcode int, err error) {
+	var (
+		blacklists []SmsBlacklists
+	)
+	blacklistMobiles = map[string]bool{}
+	o := orm.NewOrm()
+	for start := 0; start < len(mobiles); start += SMS_OPT_OUT_QUERY_BATCH {
+		end := start + SMS_OPT_OUT_QUERY_BATCH
+		if end > len(mobiles) {
+			end = len(mobiles)
+		}
+		blacklists = []SmsBlacklists{}
+		if _, err = o.QueryTable((&SmsBlacklists{}).TableName()).Filter("company_id__in", 0, companyId).Filter("mobile__in", mobiles[start:end]).Filter("status", utils.STATUS_VALID).All(&blacklists, "Mobile"); err != nil {
+			err = errors.Wrap(err, "GetSmsBlacklistMobiles")
+			retcode = utils.DB_READ_ERROR
+			return
+		}
+		for index := 0; index < len(blacklists); index++ {
+			blacklistMobiles[blacklists[index].Mobile] = true
+		}
+	}
+	return
+}
+
+// 去掉请求中被排除的手机号(及对应的短信内容)，返回去掉的手机号
+func stripSmsRequestMobiles(req *SmsRequest, excluded map[string]bool) (strippedMobiles []string) {
+	if len(excluded) <= 0 {
+		return
+	}
+	mobiles := []string{}
+	contents := []string{}
+	for index, mobile := range req.Mobiles {
+		if excluded[mobile] {
+			strippedMobiles = append(strippedMobiles, mobile)
+			continue
+		}
+		mobiles = append(mobiles, mobile)
+		if len(req.Contents) > index {
+			contents = append(contents, req.Contents[index])
+		}
+	}
+	req.Mobiles = mobiles
+	if len(req.Contents) > 0 {
+		req.Contents = contents
+	}
+	return
+}
+
+// 去掉请求中黑名单里的手机号，返回去掉的手机号；所有短信类型均处理
+func StripSmsBlacklist(req *SmsRequest) (blacklistMobiles []string, retcode int, err error) {
+	if req == nil || len(req.Mobiles) <= 0 {
+		return
+	}
+	blacklists, retcode, err := GetSmsBlacklistMobiles(req.CompanyId, req.Mobiles)
+	if err != nil {
+		return
+	}
+	blacklistMobiles = stripSmsRequestMobiles(req, blacklists)
+	return
+}
+
+// 规范化单个手机号，中国大陆手机号为11位号码，国际号码为E.164格式
+func normalizeSmsMobile(mobile string) (normalized string, retcode int, err error) {
+	number, reason := phone.Parse(mobile)
+	if number == nil {
+		err = errors.Errorf("param `mobile` %s", reason)
+		retcode = SMS_MOBILE_INVALID
+		return
+	}
+	return number.SendFormat(), 0, nil
+}
+
+// 规范化请求中的手机号，中国大陆手机号统一为11位号码；去掉无效和重复的手机号(及对应的短信内容)
+func NormalizeSmsMobiles(req *SmsRequest) (rejections []phone.Rejection) {
+	if req == nil || len(req.Mobiles) <= 0 {
+		return
+	}
+	numbers, indexes, rejections := phone.Clean(req.Mobiles, conf.SmsAllowInternational)
+	mobiles := make([]string, 0, len(numbers))
+	contents := make([]string, 0, len(numbers))
+	for index, number := range numbers {
+		mobiles = append(mobiles, number.SendFormat())
+		if len(req.Contents) > indexes[index] {
+			contents = append(contents, req.Contents[indexes[index]])
+		}
+	}
+	req.Mobiles = mobiles
+	if len(req.Contents) > 0 {
+		req.Contents = contents
+	}
+	return
+}
+
+// 发送前去掉无效的手机号、黑名单中的手机号和已退订营销短信的手机号
+func FilterSmsRequest(req *SmsRequest) (filtered *SmsFilteredMobiles, retcode int, err error) {
+	filtered = &SmsFilteredMobiles{}
+	filtered.InvalidMobiles = NormalizeSmsMobiles(req)
+	if filtered.BlacklistMobiles, retcode, err = StripSmsBlacklist(req); err != nil {
+		err = errors.Wrap(err, "FilterSmsRequest")
+		return
+	}
+	if filtered.OptOutMobiles, retcode, err = StripSmsOptOuts(req); err != nil {
+		err = errors.Wrap(err, "FilterSmsRequest")
+		return
+	}
+	return
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/1046102779/common/utils"
+	. "github.com/1046102779/sms/logger"
+	"github.com/pkg/errors"
+)
+
+/*
+	服务商回调认证
+	>> 认证配置保存在sms_service_providers表，随服务商注册表一起加载
+	>> 来源IP白名单：callback_allow_ips，逗号分隔的IP或者CIDR，空表示不限制
+	>> 认证方式：callback_sign_type
+	   空：不认证
+	   token：回调地址携带token参数，与callback_secret一致
+	   hmac_sha256：回调携带timestamp(unix时间戳，单位：秒)和sign参数，
+	       sign = hex(HMAC-SHA256(callback_secret, 除sign外的参数按参数名升序拼接的k=v&k=v + "\n" + 请求体))
+	>> 防重放：携带timestamp时，与当前时间偏差不能超过callback_max_skew秒；
+	   hmac_sha256签名在偏差时间内只能使用一次(redis SETNX)
+	>> 认证失败的回调按服务商编码和失败原因计数，通过/v1/sms/providers/callback_rejections查看
+	>> 来源IP为连接来源地址，经可信反向代理转发时取代理添加的X-Forwarded-For，见sms_client_ip.go
+*/
+
+const (
+	SMS_CALLBACK_SIGN_NONE        = ""
+	SMS_CALLBACK_SIGN_TOKEN       = "token"
+	SMS_CALLBACK_SIGN_HMAC_SHA256 = "hmac_sha256"
+
+	// 回调认证失败原因
+	SMS_CALLBACK_REJECT_IP_NOT_ALLOWED    = "ip_not_allowed"
+	SMS_CALLBACK_REJECT_TOKEN_MISMATCH    = "token_mismatch"
+	SMS_CALLBACK_REJECT_SIGN_MISSING      = "sign_missing"
+	SMS_CALLBACK_REJECT_SIGN_MISMATCH     = "sign_mismatch"
+	SMS_CALLBACK_REJECT_SIGN_UNSUPPORTED  = "sign_unsupported"
+	SMS_CALLBACK_REJECT_TIMESTAMP_INVALID = "timestamp_invalid"
+	SMS_CALLBACK_REJECT_TIMESTAMP_EXPIRED = "timestamp_expired"
+	SMS_CALLBACK_REJECT_REPLAYED          = "replayed"
+
+	// 错误码
+	SMS_CALLBACK_VERIFY_FAILED = 12032 // 服务商回调认证失败
+)
+
+var (
+	SMS_CALLBACK_DEFAULT_MAX_SKEW = 300 // 回调时间戳默认允许的最大偏差(秒)
+
+	smsCallbackRejections      = map[string]map[string]int64{} // 服务商编码 -> 失败原因 -> 次数
+	smsCallbackRejectedAt      = map[string]time.Time{}
+	smsCallbackRejectionsMutex sync.Mutex
+)
+
+// 服务商回调认证配置
+type smsCallbackAuth struct {
+	signType  string
+	secret    string
+	allowList *smsIpList
+	maxSkew   time.Duration
+}
+
+// 回调认证失败统计
+type SmsCallbackRejectionInfo struct {
+	Code       string           `json:"code"`
+	Total      int64            `json:"total"`
+	Reasons    map[string]int64 `json:"reasons"`
+	RejectedAt time.Time        `json:"rejected_at"`
+}
+
+func newSmsCallbackAuth(provider *SmsServiceProviders) (auth *smsCallbackAuth) {
+	var (
+		invalid []string
+	)
+	auth = &smsCallbackAuth{
+		signType: strings.ToLower(strings.TrimSpace(provider.CallbackSignType)),
+		secret:   provider.CallbackSecret,
+		maxSkew:  time.Duration(SMS_CALLBACK_DEFAULT_MAX_SKEW) * time.Second,
+	}
+	if provider.CallbackMaxSkew > 0 {
+		auth.maxSkew = time.Duration(provider.CallbackMaxSkew) * time.Second
+	}
+	if auth.allowList, invalid = parseSmsIpList(strings.Split(provider.CallbackAllowIps, ",")); len(invalid) > 0 {
+		Logger.Warn("[%v.%v] invalid callback allow ip `%v`.", provider.Id, provider.Code, strings.Join(invalid, ","))
+	}
+	return
+}
+
+func (t *smsCallbackAuth) isIpAllowed(clientIp string) bool {
+	return t.allowList.empty() || t.allowList.contains(clientIp)
+}
+
+// 校验timestamp参数，未携带且不强制要求时跳过
+func (t *smsCallbackAuth) checkTimestamp(params url.Values, required bool) (reason string) {
+	value := strings.TrimSpace(params.Get("timestamp"))
+	if value == "" {
+		if required {
+			return SMS_CALLBACK_REJECT_TIMESTAMP_INVALID
+		}
+		return ""
+	}
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || timestamp <= 0 {
+		return SMS_CALLBACK_REJECT_TIMESTAMP_INVALID
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > t.maxSkew {
+		return SMS_CALLBACK_REJECT_TIMESTAMP_EXPIRED
+	}
+	return ""
+}
+
+// 待签名内容：除sign外的参数按参数名升序拼接 + "\n" + 请求体
+func getSmsCallbackSignContent(params url.Values, body []byte) string {
+	keys := []string{}
+	for key := range params {
+		if key != "sign" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		for _, value := range params[key] {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	return strings.Join(pairs, "&") + "\n" + string(body)
+}
+
+func (t *smsCallbackAuth) verify(code string, clientIp string, params url.Values, body []byte) (reason string) {
+	if !t.isIpAllowed(clientIp) {
+		return SMS_CALLBACK_REJECT_IP_NOT_ALLOWED
+	}
+	switch t.signType {
+	case SMS_CALLBACK_SIGN_NONE:
+		return t.checkTimestamp(params, false)
+	case SMS_CALLBACK_SIGN_TOKEN:
+		token := params.Get("token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(t.secret)) != 1 {
+			return SMS_CALLBACK_REJECT_TOKEN_MISMATCH
+		}
+		return t.checkTimestamp(params, false)
+	case SMS_CALLBACK_SIGN_HMAC_SHA256:
+		sign := strings.ToLower(strings.TrimSpace(params.Get("sign")))
+		if sign == "" {
+			return SMS_CALLBACK_REJECT_SIGN_MISSING
+		}
+		if reason = t.checkTimestamp(params, true); reason != "" {
+			return
+		}
+		mac := hmac.New(sha256.New, []byte(t.secret))
+		mac.Write([]byte(getSmsCallbackSignContent(params, body)))
+		if !hmac.Equal([]byte(sign), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			return SMS_CALLBACK_REJECT_SIGN_MISMATCH
+		}
+		// 同一签名在偏差时间内只能使用一次
+		key := fmt.Sprintf("SMS:CALLBACK:%s:%s", code, sign)
+		ok, err := RedisClient.SetNX(key, 1, 2*t.maxSkew).Result()
+		if err != nil {
+			// redis不可用时不拒绝回调，仅依赖时间戳防重放
+			Logger.Error(err.Error())
+			return ""
+		}
+		if !ok {
+			return SMS_CALLBACK_REJECT_REPLAYED
+		}
+		return ""
+	}
+	return SMS_CALLBACK_REJECT_SIGN_UNSUPPORTED
+}
+
+// 校验服务商回调的来源IP、签名和时间戳，失败时记录拒绝次数
+func VerifySmsCallback(code string, clientIp string, params url.Values, body []byte) (retcode int, err error) {
+	registryMutex.RLock()
+	auth, exist := smsProviderAuthsByCode[code]
+	registryMutex.RUnlock()
+	if !exist {
+		// 未启用的服务商不计数，避免任意编码撑大统计
+		err = errors.New("sms service provider `" + code + "` not exist or unabled")
+		retcode = SMS_CALLBACK_VERIFY_FAILED
+		return
+	}
+	if reason := auth.verify(code, clientIp, params, body); reason != "" {
+		incrSmsCallbackRejection(code, reason)
+		Logger.Warn("[%v] sms callback from `%v` rejected: %v.", code, clientIp, reason)
+		err = errors.New("sms callback verify failed: " + reason)
+		retcode = SMS_CALLBACK_VERIFY_FAILED
+		return
+	}
+	return
+}
+
+func incrSmsCallbackRejection(code string, reason string) {
+	smsCallbackRejectionsMutex.Lock()
+	defer smsCallbackRejectionsMutex.Unlock()
+	if _, exist := smsCallbackRejections[code]; !exist {
+		smsCallbackRejections[code] = map[string]int64{}
+	}
+	smsCallbackRejections[code][reason]++
+	smsCallbackRejectedAt[code] = time.Now()
+}
+
+// 获取各服务商回调认证失败统计，按服务商编码升序
+func GetSmsCallbackRejections() (rejections []*SmsCallbackRejectionInfo) {
+	smsCallbackRejectionsMutex.Lock()
+	defer smsCallbackRejectionsMutex.Unlock()
+	codes := []string{}
+	for code := range smsCallbackRejections {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	rejections = []*SmsCallbackRejectionInfo{}
+	for _, code := range codes {
+		info := &SmsCallbackRejectionInfo{
+			Code:       code,
+			Reasons:    map[string]int64{},
+			RejectedAt: smsCallbackRejectedAt[code],
+		}
+		for reason, count := range smsCallbackRejections[code] {
+			info.Reasons[reason] = count
+			info.Total += count
+		}
+		rejections = append(rejections, info)
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// 群发活动接收人状态：10: 待发送；20: 已提交服务商；30: 发送失败；40: 已取消
+	SMS_CAMPAIGN_RECIPIENT_PENDING   = 10
+	SMS_CAMPAIGN_RECIPIENT_SUBMITTED = 20
+	SMS_CAMPAIGN_RECIPIENT_FAILED    = 30
+	SMS_CAMPAIGN_RECIPIENT_CANCELED  = 40
+
+	SMS_CAMPAIGN_RECIPIENT_INSERT_BATCH = 1000 // 批量写入接收人条数
+)
+
+type SmsCampaignRecipients struct {
+	Id                 int       `orm:"column(sms_campaign_recipient_id);auto"`
+	SmsCampaignId      int       `orm:"column(sms_campaign_id);null"`
+	SmsOutboxMessageId int       `orm:"column(sms_outbox_message_id);null"`
+	Mobile             string    `orm:"column(mobile);size(20);null"`
+	Status             int16     `orm:"column(status);null"`
+	Retcode            int       `orm:"column(retcode);null"`
+	UpdatedAt          time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt          time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsCampaignRecipients) TableName() string {
+	return "sms_campaign_recipients"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsCampaignRecipients))
+}
+
+// 批量写入群发活动接收人
+func InsertSmsCampaignRecipientsNoLock(o *orm.Ormer, recipients []SmsCampaignRecipients) (retcode int, err error) {
+	Logger.Info("[%v] enter InsertSmsCampaignRecipientsNoLock.", len(recipients))
+	defer Logger.Info("[%v] left InsertSmsCampaignRecipientsNoLock.", len(recipients))
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if len(recipients) <= 0 {
+		return
+	}
+	if _, err = (*o).InsertMulti(SMS_CAMPAIGN_RECIPIENT_INSERT_BATCH, recipients); err != nil {
+		err = errors.Wrap(err, "InsertSmsCampaignRecipientsNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 更新分批短信中仍待发送的接收人状态，mobiles不为空时只更新这些接收人，返回更新条数
+func UpdateSmsCampaignRecipientsNoLock(o *orm.Ormer, outboxId int, status int, retcode int, mobiles ...string) (num int64, err error) {
+	Logger.Info("[%v.%v] enter UpdateSmsCampaignRecipientsNoLock.", outboxId, status)
+	defer Logger.Info("[%v.%v] left UpdateSmsCampaignRecipientsNoLock.", outboxId, status)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		return
+	}
+	qs := (*o).QueryTable((&SmsCampaignRecipients{}).TableName()).Filter("sms_outbox_message_id", outboxId).Filter("status", SMS_CAMPAIGN_RECIPIENT_PENDING)
+	if len(mobiles) > 0 {
+		qs = qs.Filter("mobile__in", mobiles)
+	}
+	num, err = qs.Update(orm.Params{
+		"status":     status,
+		"retcode":    retcode,
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "UpdateSmsCampaignRecipientsNoLock")
+		return
+	}
+	return
+}
+
+// 获取群发活动接收人列表，status为0时不过滤状态
+func GetSmsCampaignRecipients(campaignId int, status int, offset int64, limit int64) (recipients []SmsCampaignRecipients, count int64, retcode int, err error) {
+	Logger.Info("[%v.%v] enter GetSmsCampaignRecipients.", campaignId, status)
+	defer Logger.Info("[%v.%v] left GetSmsCampaignRecipients.", campaignId, status)
+	recipients = []SmsCampaignRecipients{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsCampaignRecipients{}).TableName()).Filter("sms_campaign_id", campaignId)
+	if status > 0 {
+		qs = qs.Filter("status", status)
+	}
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetSmsCampaignRecipients")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("id").Limit(limit, offset).All(&recipients); err != nil {
+		err = errors.Wrap(err, "GetSmsCampaignRecipients")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
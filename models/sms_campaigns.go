@@ -0,0 +1,523 @@
+package models
+
+import (
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	营销短信群发活动
+	>> 活动接收人按服务商单次请求最多手机号个数(创蓝50000，云片1000)的最小值分批，每批写入一条发件箱短信，
+	   由发件箱后台协程发送，发送时间、发送时间窗口和重新发送与普通短信一致
+	>> 每批短信发送完成后，更新该批接收人状态和活动发送进度，所有分批短信发送完成后活动完成
+	>> 暂停：待发送的分批短信置为已暂停，不再被取出发送；恢复：重新置为待发送
+	>> 取消：待发送和已暂停的分批短信置为已取消，正在发送的分批短信不受影响
+	>> 发送中的分批短信在发送前和暂时性错误重新发送前检查活动状态，活动已暂停或者已取消时不再发送
+*/
+
+var (
+	// 群发活动状态：10: 进行中；20: 已暂停；30: 已完成；40: 已取消
+	SMS_CAMPAIGN_ACTIVE    = 10
+	SMS_CAMPAIGN_PAUSED    = 20
+	SMS_CAMPAIGN_COMPLETED = 30
+	SMS_CAMPAIGN_CANCELED  = 40
+
+	SMS_CAMPAIGN_DEFAULT_CHUNK_SIZE = 1000 // 没有启用的服务商时的分批大小
+)
+
+type SmsCampaigns struct {
+	Id             int       `orm:"column(sms_campaign_id);auto"`
+	CompanyId      int       `orm:"column(company_id);null"`
+	Name           string    `orm:"column(name);size(100);null"`
+	SmsTemplateId  int       `orm:"column(sms_template_id);null"`
+	Content        string    `orm:"column(content);size(1000);null"`
+	SendAt         time.Time `orm:"column(send_at);type(datetime);null"`
+	Status         int16     `orm:"column(status);null"`
+	TotalCount     int       `orm:"column(total_count);null"`
+	ChunkCount     int       `orm:"column(chunk_count);null"`
+	SubmittedCount int       `orm:"column(submitted_count);null"`
+	FailedCount    int       `orm:"column(failed_count);null"`
+	CanceledCount  int       `orm:"column(canceled_count);null"`
+	UpdatedAt      time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt      time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsCampaigns) TableName() string {
+	return "sms_campaigns"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsCampaigns))
+}
+
+func (t *SmsCampaigns) ReadSmsCampaignNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter ReadSmsCampaignNoLock.", t.Id)
+	defer Logger.Info("[%v] left ReadSmsCampaignNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if err = (*o).Read(t); err != nil {
+		err = errors.Wrap(err, "ReadSmsCampaignNoLock")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+func (t *SmsCampaigns) InsertSmsCampaignNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter InsertSmsCampaignNoLock.", t.CompanyId, t.Name)
+	defer Logger.Info("[%v.%v] left InsertSmsCampaignNoLock.", t.CompanyId, t.Name)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Insert(t); err != nil {
+		err = errors.Wrap(err, "InsertSmsCampaignNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 按状态条件更新活动状态，返回是否更新成功
+func (t *SmsCampaigns) UpdateSmsCampaignStatusNoLock(o *orm.Ormer, fromStatus []int, toStatus int) (updated bool, retcode int, err error) {
+	Logger.Info("[%v.%v] enter UpdateSmsCampaignStatusNoLock.", t.Id, toStatus)
+	defer Logger.Info("[%v.%v] left UpdateSmsCampaignStatusNoLock.", t.Id, toStatus)
+	var (
+		num int64
+	)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	num, err = (*o).QueryTable(t.TableName()).Filter("id", t.Id).Filter("status__in", fromStatus).Update(orm.Params{
+		"status":     toStatus,
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "UpdateSmsCampaignStatusNoLock")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	if updated = num > 0; updated {
+		t.Status = int16(toStatus)
+	}
+	return
+}
+
+// 分批大小：已启用服务商单次请求最多手机号个数的最小值
+func GetSmsCampaignChunkSize() (chunkSize int) {
+	for _, provider := range GetSmsProviders() {
+		if chunkSize <= 0 || provider.GetMaxMobiles() < chunkSize {
+			chunkSize = provider.GetMaxMobiles()
+		}
+	}
+	if chunkSize <= 0 {
+		chunkSize = SMS_CAMPAIGN_DEFAULT_CHUNK_SIZE
+	}
+	return
+}
+
+// 创建群发活动：接收人分批写入发件箱，req为活动的短信请求，req.Mobiles为所有接收人
+func CreateSmsCampaign(campaign *SmsCampaigns, req *SmsRequest, sendAt time.Time) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter CreateSmsCampaign.", campaign.CompanyId, campaign.Name)
+	defer Logger.Info("[%v.%v] left CreateSmsCampaign.", campaign.CompanyId, campaign.Name)
+	var (
+		message *SmsOutboxMessages
+	)
+	if req == nil || len(req.Mobiles) <= 0 || len(req.Contents) > 0 {
+		err = errors.New("param `mobiles` empty or `contents` not supported")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	now := time.Now()
+	chunkSize := GetSmsCampaignChunkSize()
+	campaign.Status = int16(SMS_CAMPAIGN_ACTIVE)
+	campaign.TotalCount = len(req.Mobiles)
+	campaign.ChunkCount = (len(req.Mobiles) + chunkSize - 1) / chunkSize
+	campaign.UpdatedAt = now
+	campaign.CreatedAt = now
+	o := orm.NewOrm()
+	if err = o.Begin(); err != nil {
+		err = errors.Wrap(err, "CreateSmsCampaign")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	defer func() {
+		if err != nil {
+			o.Rollback()
+		}
+	}()
+	if retcode, err = campaign.InsertSmsCampaignNoLock(&o); err != nil {
+		err = errors.Wrap(err, "CreateSmsCampaign")
+		return
+	}
+	for start := 0; start < len(req.Mobiles); start += chunkSize {
+		end := start + chunkSize
+		if end > len(req.Mobiles) {
+			end = len(req.Mobiles)
+		}
+		chunkReq := *req
+		chunkReq.Mobiles = req.Mobiles[start:end]
+		if message, retcode, err = newSmsOutboxMessage(&chunkReq, sendAt); err != nil {
+			err = errors.Wrap(err, "CreateSmsCampaign")
+			return
+		}
+		message.SmsCampaignId = campaign.Id
+		if retcode, err = message.InsertSmsOutboxMessageNoLock(&o); err != nil {
+			err = errors.Wrap(err, "CreateSmsCampaign")
+			return
+		}
+		if retcode, err = InsertQueuedSmsDeliveriesNoLock(&o, message, chunkReq.Mobiles); err != nil {
+			err = errors.Wrap(err, "CreateSmsCampaign")
+			return
+		}
+		if start == 0 {
+			campaign.SendAt = message.SendAt
+		}
+		recipients := make([]SmsCampaignRecipients, 0, end-start)
+		for _, mobile := range chunkReq.Mobiles {
+			recipients = append(recipients, SmsCampaignRecipients{
+				SmsCampaignId:      campaign.Id,
+				SmsOutboxMessageId: message.Id,
+				Mobile:             mobile,
+				Status:             int16(SMS_CAMPAIGN_RECIPIENT_PENDING),
+				UpdatedAt:          now,
+				CreatedAt:          now,
+			})
+		}
+		if retcode, err = InsertSmsCampaignRecipientsNoLock(&o, recipients); err != nil {
+			err = errors.Wrap(err, "CreateSmsCampaign")
+			return
+		}
+	}
+	if _, err = o.QueryTable(campaign.TableName()).Filter("id", campaign.Id).Update(orm.Params{
+		"send_at": campaign.SendAt,
+	}); err != nil {
+		err = errors.Wrap(err, "CreateSmsCampaign")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	if err = o.Commit(); err != nil {
+		err = errors.Wrap(err, "CreateSmsCampaign")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 活动是否还有未发送完成的分批短信，没有则活动完成
+func completeSmsCampaignIfDone(o *orm.Ormer, campaign *SmsCampaigns) (retcode int, err error) {
+	var (
+		remaining int64
+	)
+	remaining, err = (*o).QueryTable((&SmsOutboxMessages{}).TableName()).Filter("sms_campaign_id", campaign.Id).Filter("status__in", SMS_OUTBOX_PENDING, SMS_OUTBOX_SENDING, SMS_OUTBOX_PAUSED).Count()
+	if err != nil {
+		err = errors.Wrap(err, "completeSmsCampaignIfDone")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if remaining > 0 {
+		return
+	}
+	if _, retcode, err = campaign.UpdateSmsCampaignStatusNoLock(o, []int{SMS_CAMPAIGN_ACTIVE}, SMS_CAMPAIGN_COMPLETED); err != nil {
+		err = errors.Wrap(err, "completeSmsCampaignIfDone")
+		return
+	}
+	return
+}
+
+// 分批短信发送完成后，更新接收人状态和活动发送进度；同一批短信重复调用时不会重复计数
+func UpdateSmsCampaignProgress(message *SmsOutboxMessages) {
+	Logger.Info("[%v.%v] enter UpdateSmsCampaignProgress.", message.SmsCampaignId, message.Id)
+	defer Logger.Info("[%v.%v] left UpdateSmsCampaignProgress.", message.SmsCampaignId, message.Id)
+	var (
+		recipientStatus int    = SMS_CAMPAIGN_RECIPIENT_SUBMITTED
+		countField      string = "submitted_count"
+	)
+	if message.Status == int16(SMS_OUTBOX_FAILED) {
+		recipientStatus = SMS_CAMPAIGN_RECIPIENT_FAILED
+		countField = "failed_count"
+	}
+	o := orm.NewOrm()
+	num, err := UpdateSmsCampaignRecipientsNoLock(&o, message.Id, recipientStatus, message.Retcode)
+	if err != nil {
+		Logger.Error(err.Error())
+		return
+	}
+	campaign := &SmsCampaigns{
+		Id: message.SmsCampaignId,
+	}
+	if num > 0 {
+		if _, err = o.QueryTable(campaign.TableName()).Filter("id", campaign.Id).Update(orm.Params{
+			countField:   orm.ColValue(orm.ColAdd, num),
+			"updated_at": time.Now(),
+		}); err != nil {
+			Logger.Error(errors.Wrap(err, "UpdateSmsCampaignProgress").Error())
+			return
+		}
+	}
+	if _, err = completeSmsCampaignIfDone(&o, campaign); err != nil {
+		Logger.Error(err.Error())
+	}
+	return
+}
+
+// 分批短信发送前被过滤的接收人记为发送失败，分批短信中其他接收人仍正常发送
+func FailSmsCampaignRecipients(message *SmsOutboxMessages, mobiles []string, retcode int) {
+	Logger.Info("[%v.%v] enter FailSmsCampaignRecipients.", message.SmsCampaignId, message.Id)
+	defer Logger.Info("[%v.%v] left FailSmsCampaignRecipients.", message.SmsCampaignId, message.Id)
+	if len(mobiles) <= 0 {
+		return
+	}
+	o := orm.NewOrm()
+	num, err := UpdateSmsCampaignRecipientsNoLock(&o, message.Id, SMS_CAMPAIGN_RECIPIENT_FAILED, retcode, mobiles...)
+	if err != nil {
+		Logger.Error(err.Error())
+		return
+	}
+	if num > 0 {
+		if _, err = o.QueryTable((&SmsCampaigns{}).TableName()).Filter("id", message.SmsCampaignId).Update(orm.Params{
+			"failed_count": orm.ColValue(orm.ColAdd, num),
+			"updated_at":   time.Now(),
+		}); err != nil {
+			Logger.Error(errors.Wrap(err, "FailSmsCampaignRecipients").Error())
+		}
+	}
+	return
+}
+
+// 分批短信所属活动已暂停或者已取消时，返回分批短信应置为的状态(SMS_OUTBOX_PAUSED或者SMS_OUTBOX_CANCELED)，否则返回0
+// 暂停和取消只处理待发送的分批短信，发送中的分批短信在发送前和重新发送前检查活动状态
+func getSmsCampaignHoldStatus(campaignId int) (status int) {
+	o := orm.NewOrm()
+	campaign := &SmsCampaigns{
+		Id: campaignId,
+	}
+	if _, err := campaign.ReadSmsCampaignNoLock(&o); err != nil {
+		// 查询失败时不影响发送
+		Logger.Error(err.Error())
+		return
+	}
+	switch int(campaign.Status) {
+	case SMS_CAMPAIGN_PAUSED:
+		status = SMS_OUTBOX_PAUSED
+	case SMS_CAMPAIGN_CANCELED:
+		status = SMS_OUTBOX_CANCELED
+	}
+	return
+}
+
+// 活动取消后才停止发送的分批短信，接收人记为已取消并更新活动取消数量
+func CancelSmsCampaignRecipients(message *SmsOutboxMessages) {
+	Logger.Info("[%v.%v] enter CancelSmsCampaignRecipients.", message.SmsCampaignId, message.Id)
+	defer Logger.Info("[%v.%v] left CancelSmsCampaignRecipients.", message.SmsCampaignId, message.Id)
+	o := orm.NewOrm()
+	num, err := UpdateSmsCampaignRecipientsNoLock(&o, message.Id, SMS_CAMPAIGN_RECIPIENT_CANCELED, 0)
+	if err != nil {
+		Logger.Error(err.Error())
+		return
+	}
+	if num > 0 {
+		if _, err = o.QueryTable((&SmsCampaigns{}).TableName()).Filter("id", message.SmsCampaignId).Update(orm.Params{
+			"canceled_count": orm.ColValue(orm.ColAdd, num),
+			"updated_at":     time.Now(),
+		}); err != nil {
+			Logger.Error(errors.Wrap(err, "CancelSmsCampaignRecipients").Error())
+		}
+	}
+	return
+}
+
+// 读取公司的群发活动
+func getCompanySmsCampaign(o *orm.Ormer, companyId int, campaignId int) (campaign *SmsCampaigns, retcode int, err error) {
+	campaign = &SmsCampaigns{
+		Id: campaignId,
+	}
+	if retcode, err = campaign.ReadSmsCampaignNoLock(o); err != nil {
+		return
+	}
+	if campaign.CompanyId != companyId {
+		err = errors.New("sms campaign not exist")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	return
+}
+
+// 暂停进行中的群发活动
+func PauseSmsCampaign(companyId int, campaignId int) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter PauseSmsCampaign.", companyId, campaignId)
+	defer Logger.Info("[%v.%v] left PauseSmsCampaign.", companyId, campaignId)
+	o := orm.NewOrm()
+	campaign, retcode, err := getCompanySmsCampaign(&o, companyId, campaignId)
+	if err != nil {
+		err = errors.Wrap(err, "PauseSmsCampaign")
+		return
+	}
+	updated, retcode, err := campaign.UpdateSmsCampaignStatusNoLock(&o, []int{SMS_CAMPAIGN_ACTIVE}, SMS_CAMPAIGN_PAUSED)
+	if err != nil {
+		err = errors.Wrap(err, "PauseSmsCampaign")
+		return
+	}
+	if !updated {
+		err = errors.New("sms campaign not active")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("sms_campaign_id", campaign.Id).Filter("status", SMS_OUTBOX_PENDING).Update(orm.Params{
+		"status":     SMS_OUTBOX_PAUSED,
+		"updated_at": time.Now(),
+	}); err != nil {
+		err = errors.Wrap(err, "PauseSmsCampaign")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 恢复已暂停的群发活动，未到活动发送时间的分批短信仍按活动发送时间发送
+func ResumeSmsCampaign(companyId int, campaignId int) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter ResumeSmsCampaign.", companyId, campaignId)
+	defer Logger.Info("[%v.%v] left ResumeSmsCampaign.", companyId, campaignId)
+	o := orm.NewOrm()
+	campaign, retcode, err := getCompanySmsCampaign(&o, companyId, campaignId)
+	if err != nil {
+		err = errors.Wrap(err, "ResumeSmsCampaign")
+		return
+	}
+	updated, retcode, err := campaign.UpdateSmsCampaignStatusNoLock(&o, []int{SMS_CAMPAIGN_PAUSED}, SMS_CAMPAIGN_ACTIVE)
+	if err != nil {
+		err = errors.Wrap(err, "ResumeSmsCampaign")
+		return
+	}
+	if !updated {
+		err = errors.New("sms campaign not paused")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	now := time.Now()
+	sendAt := campaign.SendAt
+	if sendAt.Before(now) {
+		sendAt = now
+	}
+	if _, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("sms_campaign_id", campaign.Id).Filter("status", SMS_OUTBOX_PAUSED).Update(orm.Params{
+		"status":     SMS_OUTBOX_PENDING,
+		"visible_at": GetAllowedSmsSendTime(companyId, SMS_CHUANGLAN_MARKETING_TYPE, sendAt),
+		"updated_at": now,
+	}); err != nil {
+		err = errors.Wrap(err, "ResumeSmsCampaign")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	// 暂停期间所有分批短信已发送完成
+	if retcode, err = completeSmsCampaignIfDone(&o, campaign); err != nil {
+		err = errors.Wrap(err, "ResumeSmsCampaign")
+		return
+	}
+	return
+}
+
+// 取消进行中或已暂停的群发活动
+func CancelSmsCampaign(companyId int, campaignId int) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter CancelSmsCampaign.", companyId, campaignId)
+	defer Logger.Info("[%v.%v] left CancelSmsCampaign.", companyId, campaignId)
+	var (
+		messages []SmsOutboxMessages = []SmsOutboxMessages{}
+		num      int64
+		canceled int64
+	)
+	o := orm.NewOrm()
+	campaign, retcode, err := getCompanySmsCampaign(&o, companyId, campaignId)
+	if err != nil {
+		err = errors.Wrap(err, "CancelSmsCampaign")
+		return
+	}
+	updated, retcode, err := campaign.UpdateSmsCampaignStatusNoLock(&o, []int{SMS_CAMPAIGN_ACTIVE, SMS_CAMPAIGN_PAUSED}, SMS_CAMPAIGN_CANCELED)
+	if err != nil {
+		err = errors.Wrap(err, "CancelSmsCampaign")
+		return
+	}
+	if !updated {
+		err = errors.New("sms campaign already completed or canceled")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	now := time.Now()
+	if _, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("sms_campaign_id", campaign.Id).Filter("status__in", SMS_OUTBOX_PENDING, SMS_OUTBOX_PAUSED).Update(orm.Params{
+		"status":     SMS_OUTBOX_CANCELED,
+		"updated_at": now,
+	}); err != nil {
+		err = errors.Wrap(err, "CancelSmsCampaign")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	if _, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("sms_campaign_id", campaign.Id).Filter("status", SMS_OUTBOX_CANCELED).All(&messages, "Id"); err != nil {
+		err = errors.Wrap(err, "CancelSmsCampaign")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	for index := 0; index < len(messages); index++ {
+		if num, err = UpdateSmsCampaignRecipientsNoLock(&o, messages[index].Id, SMS_CAMPAIGN_RECIPIENT_CANCELED, 0); err != nil {
+			err = errors.Wrap(err, "CancelSmsCampaign")
+			retcode = utils.DB_UPDATE_ERROR
+			return
+		}
+		canceled += num
+	}
+	if _, err = o.QueryTable(campaign.TableName()).Filter("id", campaign.Id).Update(orm.Params{
+		"canceled_count": orm.ColValue(orm.ColAdd, canceled),
+		"updated_at":     now,
+	}); err != nil {
+		err = errors.Wrap(err, "CancelSmsCampaign")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 获取公司的群发活动及其分批短信
+func GetSmsCampaign(companyId int, campaignId int) (campaign *SmsCampaigns, chunks []SmsOutboxMessages, retcode int, err error) {
+	Logger.Info("[%v.%v] enter GetSmsCampaign.", companyId, campaignId)
+	defer Logger.Info("[%v.%v] left GetSmsCampaign.", companyId, campaignId)
+	chunks = []SmsOutboxMessages{}
+	o := orm.NewOrm()
+	if campaign, retcode, err = getCompanySmsCampaign(&o, companyId, campaignId); err != nil {
+		err = errors.Wrap(err, "GetSmsCampaign")
+		return
+	}
+	if _, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("sms_campaign_id", campaign.Id).OrderBy("id").All(&chunks); err != nil {
+		err = errors.Wrap(err, "GetSmsCampaign")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+// 获取公司的群发活动列表，按创建时间倒序
+func GetSmsCampaigns(companyId int, offset int64, limit int64) (campaigns []SmsCampaigns, count int64, retcode int, err error) {
+	Logger.Info("[%v] enter GetSmsCampaigns.", companyId)
+	defer Logger.Info("[%v] left GetSmsCampaigns.", companyId)
+	campaigns = []SmsCampaigns{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsCampaigns{}).TableName()).Filter("company_id", companyId)
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetSmsCampaigns")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("-id").Limit(limit, offset).All(&campaigns); err != nil {
+		err = errors.Wrap(err, "GetSmsCampaigns")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"net"
+	"strings"
+
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+)
+
+/*
+	客户端IP
+	>> 默认取连接的来源地址(RemoteAddr)，X-Forwarded-For可被客户端任意伪造，不直接采用
+	>> 来源地址为可信反向代理(sms::trusted_proxies)时，从X-Forwarded-For最右侧开始跳过可信代理，
+	   第一个不是可信代理的地址为客户端IP，即最后一个可信代理看到的来源地址
+	>> 用于服务商回调来源IP白名单和验证码获取频率限制
+*/
+
+var (
+	smsTrustedProxies *smsIpList = &smsIpList{} // 可信反向代理
+)
+
+// IP或者CIDR列表
+type smsIpList struct {
+	ips  []net.IP
+	nets []*net.IPNet
+}
+
+func init() {
+	var (
+		invalid []string
+	)
+	if smsTrustedProxies, invalid = parseSmsIpList(conf.SmsTrustedProxies); len(invalid) > 0 {
+		Logger.Warn("invalid trusted proxies `%v`.", strings.Join(invalid, ","))
+	}
+}
+
+// 解析IP或者CIDR列表，invalid为无法解析的项
+func parseSmsIpList(items []string) (list *smsIpList, invalid []string) {
+	list = &smsIpList{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			if _, ipNet, err := net.ParseCIDR(item); err == nil {
+				list.nets = append(list.nets, ipNet)
+				continue
+			}
+		} else if ip := net.ParseIP(item); ip != nil {
+			list.ips = append(list.ips, ip)
+			continue
+		}
+		invalid = append(invalid, item)
+	}
+	return
+}
+
+func (t *smsIpList) empty() bool {
+	return len(t.ips) <= 0 && len(t.nets) <= 0
+}
+
+func (t *smsIpList) contains(value string) bool {
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return false
+	}
+	for index := 0; index < len(t.ips); index++ {
+		if t.ips[index].Equal(ip) {
+			return true
+		}
+	}
+	for index := 0; index < len(t.nets); index++ {
+		if t.nets[index].Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 获取客户端IP, remoteAddr为连接来源地址(ip:port)，forwardedFor为所有X-Forwarded-For请求头
+func GetClientIp(remoteAddr string, forwardedFor []string) string {
+	return getClientIp(smsTrustedProxies, remoteAddr, forwardedFor)
+}
+
+func getClientIp(trustedProxies *smsIpList, remoteAddr string, forwardedFor []string) (clientIp string) {
+	clientIp = strings.TrimSpace(remoteAddr)
+	if host, _, err := net.SplitHostPort(clientIp); err == nil {
+		clientIp = host
+	}
+	if !trustedProxies.contains(clientIp) {
+		return
+	}
+	hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+	for index := len(hops) - 1; index >= 0; index-- {
+		hop := strings.TrimSpace(hops[index])
+		if hop == "" {
+			continue
+		}
+		// 无法解析的地址不是可信代理添加的，不再向左查找
+		if net.ParseIP(hop) == nil {
+			return
+		}
+		if !trustedProxies.contains(hop) {
+			return hop
+		}
+	}
+	return
+}
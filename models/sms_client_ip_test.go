@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestGetClientIp(t *testing.T) {
+	trustedProxies, invalid := parseSmsIpList([]string{"10.0.0.1", "192.168.0.0/16", "bad"})
+	if len(invalid) != 1 || invalid[0] != "bad" {
+		t.Fatalf("parseSmsIpList invalid = %v, expected [bad]", invalid)
+	}
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{"直连", "1.2.3.4:5678", nil, "1.2.3.4"},
+		{"直连伪造X-Forwarded-For", "1.2.3.4:5678", []string{"9.9.9.9"}, "1.2.3.4"},
+		{"可信代理", "10.0.0.1:80", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"可信代理前伪造", "10.0.0.1:80", []string{"9.9.9.9, 1.2.3.4"}, "1.2.3.4"},
+		{"多级可信代理", "10.0.0.1:80", []string{"9.9.9.9, 1.2.3.4, 192.168.1.10"}, "1.2.3.4"},
+		{"多个请求头", "10.0.0.1:80", []string{"9.9.9.9", "1.2.3.4"}, "1.2.3.4"},
+		{"可信代理无X-Forwarded-For", "10.0.0.1:80", nil, "10.0.0.1"},
+		{"全部为可信代理", "10.0.0.1:80", []string{"192.168.1.10"}, "10.0.0.1"},
+		{"无法解析的地址", "10.0.0.1:80", []string{"1.2.3.4, unknown"}, "10.0.0.1"},
+		{"IPv6", "[2001:db8::1]:443", []string{"9.9.9.9"}, "2001:db8::1"},
+		{"无端口", "1.2.3.4", nil, "1.2.3.4"},
+	}
+	for _, c := range cases {
+		if clientIp := getClientIp(trustedProxies, c.remoteAddr, c.forwardedFor); clientIp != c.expected {
+			t.Errorf("%s: getClientIp(%q, %q) = %q, expected %q", c.name, c.remoteAddr, c.forwardedFor, clientIp, c.expected)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信送达状态
+	>> 每个接收人一条记录，按服务商ID、第三方短信消息ID和手机号与状态报告对应
+	>> 状态流转：
+		写入发件箱                  => 10 待发送(queued)
+		服务商接收成功               => 20 已提交(submitted)
+		最终发送失败                 => 40 失败(failed)
+		状态报告DELIVRD             => 30 已送达(delivered)
+		状态报告EXPIRED             => 50 已过期(expired)
+		状态报告UNKNOWN             => 60 未知(unknown)，后续状态报告可再次更新
+		其他失败状态报告              => 40 失败(failed)
+	>> 已送达、失败、已过期为最终状态，不再更新
+	>> 状态报告先于提交结果到达或者找不到对应记录时，直接写入一条状态报告对应状态的记录
+*/
+
+var (
+	SMS_DELIVERY_QUEUED    = 10
+	SMS_DELIVERY_SUBMITTED = 20
+	SMS_DELIVERY_DELIVERED = 30
+	SMS_DELIVERY_FAILED    = 40
+	SMS_DELIVERY_EXPIRED   = 50
+	SMS_DELIVERY_UNKNOWN   = 60
+
+	SMS_DELIVERY_INSERT_BATCH = 1000 // 批量写入条数
+)
+
+type SmsDeliveries struct {
+	Id                   int       `orm:"column(sms_delivery_id);auto"`
+	SmsOutboxMessageId   int       `orm:"column(sms_outbox_message_id);null"`
+	SmsServiceProviderId int       `orm:"column(sms_service_provider_id);null"`
+	MessageId            string    `orm:"column(message_id);size(100);null"`
+	Mobile               string    `orm:"column(mobile);size(20);null"`
+	ExtendCode           string    `orm:"column(extend_code);size(20);null"`
+	Status               int16     `orm:"column(status);null"`
+	ReceiptStatus        int16     `orm:"column(receipt_status);null"`
+	Retcode              int       `orm:"column(retcode);null"`
+	SubmittedAt          time.Time `orm:"column(submitted_at);type(datetime);null"`
+	ReceiptAt            string    `orm:"column(receipt_at);size(30);null"`
+	UpdatedAt            time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt            time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsDeliveries) TableName() string {
+	return "sms_deliveries"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsDeliveries))
+}
+
+// 状态报告状态码(统一采用创蓝253状态码)对应的送达状态
+func getSmsDeliveryStatus(receiptStatus int16) int {
+	switch receiptStatus {
+	case 0: // DELIVRD
+		return SMS_DELIVERY_DELIVERED
+	case 11: // EXPIRED
+		return SMS_DELIVERY_EXPIRED
+	case 13: // UNKNOWN
+		return SMS_DELIVERY_UNKNOWN
+	}
+	return SMS_DELIVERY_FAILED
+}
+
+// 发件箱短信的接收人写入待发送状态
+func InsertQueuedSmsDeliveriesNoLock(o *orm.Ormer, message *SmsOutboxMessages, mobiles []string) (retcode int, err error) {
+	Logger.Info("[%v] enter InsertQueuedSmsDeliveriesNoLock.", message.Id)
+	defer Logger.Info("[%v] left InsertQueuedSmsDeliveriesNoLock.", message.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	now := time.Now()
+	deliveries := make([]SmsDeliveries, 0, len(mobiles))
+	for _, mobile := range mobiles {
+		deliveries = append(deliveries, SmsDeliveries{
+			SmsOutboxMessageId: message.Id,
+			Mobile:             mobile,
+			Status:             int16(SMS_DELIVERY_QUEUED),
+			UpdatedAt:          now,
+			CreatedAt:          now,
+		})
+	}
+	if len(deliveries) <= 0 {
+		return
+	}
+	if _, err = (*o).InsertMulti(SMS_DELIVERY_INSERT_BATCH, deliveries); err != nil {
+		err = errors.Wrap(err, "InsertQueuedSmsDeliveriesNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 服务商接收成功：发件箱短信更新待发送的记录，非发件箱短信直接写入已提交的记录
+func recordSmsDeliveriesSubmitted(req *SmsRequest, result *SmsResult) (retcode int, err error) {
+	now := time.Now()
+	o := orm.NewOrm()
+	if req.OutboxId <= 0 {
+		deliveries := make([]SmsDeliveries, 0, len(req.Mobiles))
+		for _, mobile := range req.Mobiles {
+			if _, failed := result.FailedMobiles[mobile]; failed {
+				continue
+			}
+			deliveries = append(deliveries, SmsDeliveries{
+				SmsServiceProviderId: result.SmsServiceProviderId,
+				MessageId:            result.GetMessageId(mobile),
+				Mobile:               mobile,
+				ExtendCode:           req.ExtendCode,
+				Status:               int16(SMS_DELIVERY_SUBMITTED),
+				SubmittedAt:          now,
+				UpdatedAt:            now,
+				CreatedAt:            now,
+			})
+		}
+		if _, err = o.InsertMulti(SMS_DELIVERY_INSERT_BATCH, deliveries); err != nil {
+			err = errors.Wrap(err, "recordSmsDeliveriesSubmitted")
+			retcode = utils.DB_INSERT_ERROR
+		}
+		return
+	}
+	qs := o.QueryTable((&SmsDeliveries{}).TableName()).Filter("sms_outbox_message_id", req.OutboxId).Filter("status", SMS_DELIVERY_QUEUED)
+	params := orm.Params{
+		"sms_service_provider_id": result.SmsServiceProviderId,
+		"message_id":              result.MessageId,
+		"extend_code":             req.ExtendCode,
+		"status":                  SMS_DELIVERY_SUBMITTED,
+		"submitted_at":            now,
+		"updated_at":              now,
+	}
+	// 所有接收人共用同一个第三方短信消息ID
+	if len(result.MessageIds) <= 0 && len(result.FailedMobiles) <= 0 {
+		if _, err = qs.Update(params); err != nil {
+			err = errors.Wrap(err, "recordSmsDeliveriesSubmitted")
+			retcode = utils.DB_UPDATE_ERROR
+		}
+		return
+	}
+	for _, mobile := range req.Mobiles {
+		// 发送失败的接收人由发件箱记为发送失败
+		if _, failed := result.FailedMobiles[mobile]; failed {
+			continue
+		}
+		params["message_id"] = result.GetMessageId(mobile)
+		if _, err = qs.Filter("mobile", mobile).Update(params); err != nil {
+			err = errors.Wrap(err, "recordSmsDeliveriesSubmitted")
+			retcode = utils.DB_UPDATE_ERROR
+			return
+		}
+	}
+	return
+}
+
+// 发件箱短信最终发送失败，mobiles不为空时只更新这些接收人(如：发送前被过滤的手机号)
+func MarkSmsDeliveriesFailed(outboxId int, sendRetcode int, mobiles ...string) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter MarkSmsDeliveriesFailed.", outboxId, sendRetcode)
+	defer Logger.Info("[%v.%v] left MarkSmsDeliveriesFailed.", outboxId, sendRetcode)
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsDeliveries{}).TableName()).Filter("sms_outbox_message_id", outboxId).Filter("status", SMS_DELIVERY_QUEUED)
+	if len(mobiles) > 0 {
+		qs = qs.Filter("mobile__in", mobiles)
+	}
+	if _, err = qs.Update(orm.Params{
+		"status":     SMS_DELIVERY_FAILED,
+		"retcode":    sendRetcode,
+		"updated_at": time.Now(),
+	}); err != nil {
+		err = errors.Wrap(err, "MarkSmsDeliveriesFailed")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 按状态报告更新送达状态，只更新未到达最终状态的记录
+func updateSmsDeliveryByReceipt(o *orm.Ormer, providerId int, receipt *SmsReceipt) (retcode int, err error) {
+	var (
+		num int64
+	)
+	status := getSmsDeliveryStatus(receipt.ReceiptStatus)
+	now := time.Now()
+	qs := (*o).QueryTable((&SmsDeliveries{}).TableName()).Filter("sms_service_provider_id", providerId).Filter("message_id", receipt.MessageId).Filter("mobile", receipt.Mobile)
+	num, err = qs.Filter("status__in", SMS_DELIVERY_QUEUED, SMS_DELIVERY_SUBMITTED, SMS_DELIVERY_UNKNOWN).Update(orm.Params{
+		"status":         status,
+		"receipt_status": receipt.ReceiptStatus,
+		"receipt_at":     receipt.ReceiptAt,
+		"updated_at":     now,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "updateSmsDeliveryByReceipt")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	if num > 0 || qs.Exist() {
+		return
+	}
+	// 没有对应的发送记录
+	delivery := &SmsDeliveries{
+		SmsServiceProviderId: providerId,
+		MessageId:            receipt.MessageId,
+		Mobile:               receipt.Mobile,
+		Status:               int16(status),
+		ReceiptStatus:        receipt.ReceiptStatus,
+		ReceiptAt:            receipt.ReceiptAt,
+		UpdatedAt:            now,
+		CreatedAt:            now,
+	}
+	if _, err = (*o).Insert(delivery); err != nil {
+		err = errors.Wrap(err, "updateSmsDeliveryByReceipt")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 获取发件箱短信所有接收人的送达状态
+func GetSmsDeliveriesByOutboxId(outboxId int) (deliveries []SmsDeliveries, retcode int, err error) {
+	Logger.Info("[%v] enter GetSmsDeliveriesByOutboxId.", outboxId)
+	defer Logger.Info("[%v] left GetSmsDeliveriesByOutboxId.", outboxId)
+	deliveries = []SmsDeliveries{}
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsDeliveries{}).TableName()).Filter("sms_outbox_message_id", outboxId).OrderBy("id").All(&deliveries); err != nil {
+		err = errors.Wrap(err, "GetSmsDeliveriesByOutboxId")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
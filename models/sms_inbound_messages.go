@@ -0,0 +1,169 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	上行短信(用户回复)
+	>> 创蓝、云片网推送的上行短信统一写入sms_inbound_messages表
+	>> 创蓝、云片网的上行短信都不携带原短信的第三方消息ID，按扩展码关联原发送：
+	   发件箱短信发送时携带由发件箱短信ID生成的扩展码(见GetSmsExtendCode)，并记录在送达记录中；
+	   用户回复的接收号码以该扩展码结尾(创蓝destcode、云片base_extend+extend)
+	>> 关联范围：同一服务商发往该手机号、提交时间在SMS_INBOUND_LINK_WINDOW内的送达记录，
+	   优先取扩展码匹配的最近一条；没有匹配的扩展码时(如非发件箱发送)，才取最近一条送达记录
+	>> 通过送达记录的发件箱短信得到公司ID和群发活动ID
+	>> 找不到原发送时，公司ID和群发活动ID为0，仍然保存
+*/
+
+var (
+	SMS_INBOUND_LINK_WINDOW = 72 * time.Hour // 关联原发送的时间范围
+	SMS_INBOUND_LINK_LIMIT  = 100            // 关联原发送时最多查询的送达记录条数
+
+	SMS_EXTEND_CODE_LENGTH = 4 // 扩展码位数，服务商账户的扩展码位数需不少于该值
+)
+
+type SmsInboundMessages struct {
+	Id                   int       `orm:"column(sms_inbound_message_id);auto"`
+	SmsServiceProviderId int       `orm:"column(sms_service_provider_id);null"`
+	MessageId            string    `orm:"column(message_id);size(100);null"`
+	Mobile               string    `orm:"column(mobile);size(20);null"`
+	Content              string    `orm:"column(content);size(1000);null"`
+	ExtendCode           string    `orm:"column(extend_code);size(50);null"`
+	ReceivedAt           string    `orm:"column(received_at);size(30);null"`
+	CompanyId            int       `orm:"column(company_id);null"`
+	SmsOutboxMessageId   int       `orm:"column(sms_outbox_message_id);null"`
+	SmsCampaignId        int       `orm:"column(sms_campaign_id);null"`
+	SmsDeliveryId        int       `orm:"column(sms_delivery_id);null"`
+	CreatedAt            time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsInboundMessages) TableName() string {
+	return "sms_inbound_messages"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsInboundMessages))
+}
+
+// 发件箱短信的扩展码：发件箱短信ID的后SMS_EXTEND_CODE_LENGTH位，不足补0；非发件箱发送为空
+func GetSmsExtendCode(outboxId int) string {
+	if outboxId <= 0 {
+		return ""
+	}
+	code := fmt.Sprintf("%0*d", SMS_EXTEND_CODE_LENGTH, outboxId)
+	return code[len(code)-SMS_EXTEND_CODE_LENGTH:]
+}
+
+// 从送达记录中选择上行短信对应的原发送：优先取扩展码匹配的，否则取第一条；deliveries按ID倒序，最近的在前
+func matchSmsDelivery(extendCode string, deliveries []SmsDeliveries) (delivery *SmsDeliveries) {
+	if len(deliveries) <= 0 {
+		return
+	}
+	if extendCode = strings.TrimSpace(extendCode); extendCode != "" {
+		for index := 0; index < len(deliveries); index++ {
+			if deliveries[index].ExtendCode != "" && strings.HasSuffix(extendCode, deliveries[index].ExtendCode) {
+				return &deliveries[index]
+			}
+		}
+	}
+	return &deliveries[0]
+}
+
+// 关联上行短信对应的原发送：同一服务商发往该手机号的送达记录，优先按扩展码匹配
+func (t *SmsInboundMessages) linkSmsDeliveryNoLock(o *orm.Ormer) (retcode int, err error) {
+	var (
+		deliveries []SmsDeliveries = []SmsDeliveries{}
+	)
+	_, err = (*o).QueryTable((&SmsDeliveries{}).TableName()).Filter("sms_service_provider_id", t.SmsServiceProviderId).Filter("mobile", t.Mobile).Filter("submitted_at__gte", time.Now().Add(-SMS_INBOUND_LINK_WINDOW)).OrderBy("-id").Limit(SMS_INBOUND_LINK_LIMIT).All(&deliveries)
+	if err != nil {
+		err = errors.Wrap(err, "linkSmsDeliveryNoLock")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	delivery := matchSmsDelivery(t.ExtendCode, deliveries)
+	if delivery == nil {
+		return
+	}
+	t.SmsDeliveryId = delivery.Id
+	if delivery.SmsOutboxMessageId <= 0 {
+		return
+	}
+	message := &SmsOutboxMessages{
+		Id: delivery.SmsOutboxMessageId,
+	}
+	if retcode, err = message.ReadSmsOutboxMessageNoLock(o); err != nil {
+		err = errors.Wrap(err, "linkSmsDeliveryNoLock")
+		return
+	}
+	t.SmsOutboxMessageId = message.Id
+	t.CompanyId = message.CompanyId
+	t.SmsCampaignId = message.SmsCampaignId
+	return
+}
+
+// 保存服务商推送的上行短信，并关联原发送
+func SaveSmsInbounds(providerId int, inbounds []SmsInbound) (messages []*SmsInboundMessages, retcode int, err error) {
+	Logger.Info("[%v] enter SaveSmsInbounds.", providerId)
+	defer Logger.Info("[%v] left SaveSmsInbounds.", providerId)
+	now := time.Now()
+	o := orm.NewOrm()
+	for index := 0; index < len(inbounds); index++ {
+		message := &SmsInboundMessages{
+			SmsServiceProviderId: providerId,
+			MessageId:            inbounds[index].MessageId,
+			Mobile:               inbounds[index].Mobile,
+			Content:              inbounds[index].Content,
+			ExtendCode:           inbounds[index].ExtendCode,
+			ReceivedAt:           inbounds[index].ReceivedAt,
+			CreatedAt:            now,
+		}
+		if retcode, err = message.linkSmsDeliveryNoLock(&o); err != nil {
+			// 关联失败不影响保存
+			Logger.Error(err.Error())
+			retcode, err = 0, nil
+		}
+		if _, err = o.Insert(message); err != nil {
+			err = errors.Wrap(err, "SaveSmsInbounds")
+			retcode = utils.DB_INSERT_ERROR
+			return
+		}
+		messages = append(messages, message)
+		// 退订回复
+		handleSmsInboundOptOut(message)
+	}
+	return
+}
+
+// 获取公司收到的上行短信，campaignId大于0时只查询该群发活动的回复，mobile不为空时只查询该手机号的回复
+func GetSmsInboundMessages(companyId int, campaignId int, mobile string, offset int64, limit int64) (messages []SmsInboundMessages, count int64, retcode int, err error) {
+	Logger.Info("[%v.%v] enter GetSmsInboundMessages.", companyId, campaignId)
+	defer Logger.Info("[%v.%v] left GetSmsInboundMessages.", companyId, campaignId)
+	messages = []SmsInboundMessages{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsInboundMessages{}).TableName()).Filter("company_id", companyId)
+	if campaignId > 0 {
+		qs = qs.Filter("sms_campaign_id", campaignId)
+	}
+	if mobile != "" {
+		qs = qs.Filter("mobile", mobile)
+	}
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetSmsInboundMessages")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("-id").Limit(limit, offset).All(&messages); err != nil {
+		err = errors.Wrap(err, "GetSmsInboundMessages")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
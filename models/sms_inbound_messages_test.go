@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestGetSmsExtendCode(t *testing.T) {
+	cases := []struct {
+		outboxId int
+		expected string
+	}{
+		{0, ""},
+		{7, "0007"},
+		{1234, "1234"},
+		{56789, "6789"},
+	}
+	for _, c := range cases {
+		if code := GetSmsExtendCode(c.outboxId); code != c.expected {
+			t.Errorf("GetSmsExtendCode(%d) = %q, expected %q", c.outboxId, code, c.expected)
+		}
+	}
+}
+
+func TestMatchSmsDelivery(t *testing.T) {
+	deliveries := []SmsDeliveries{
+		{Id: 3},
+		{Id: 2, ExtendCode: "0002"},
+		{Id: 1, ExtendCode: "0001"},
+	}
+	cases := []struct {
+		name       string
+		extendCode string
+		deliveries []SmsDeliveries
+		expected   int
+	}{
+		{"无送达记录", "0001", nil, 0},
+		{"扩展码匹配", "0001", deliveries, 1},
+		{"接收号码以扩展码结尾", "10690000002", deliveries, 2},
+		{"扩展码不匹配取最近一条", "0009", deliveries, 3},
+		{"无扩展码取最近一条", "", deliveries, 3},
+	}
+	for _, c := range cases {
+		delivery := matchSmsDelivery(c.extendCode, c.deliveries)
+		id := 0
+		if delivery != nil {
+			id = delivery.Id
+		}
+		if id != c.expected {
+			t.Errorf("%s: matchSmsDelivery(%q) = %d, expected %d", c.name, c.extendCode, id, c.expected)
+		}
+	}
+}
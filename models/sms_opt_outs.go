@@ -0,0 +1,204 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	营销短信退订
+	>> 上行短信内容(去掉首尾空白和标点)与退订关键字(conf.SmsUnsubscribeKeywords)一致时，记为退订
+	>> 上行短信关联到原发送的公司时，只退订该公司的营销短信；未关联时退订所有公司的营销短信(company_id=0)
+	>> 公司也可以手动添加、删除退订手机号
+	>> 营销短信写入发件箱前、以及发件箱发送前，去掉已退订的手机号(见FilterSmsRequest)；验证码短信不受影响
+*/
+
+const (
+	// 错误码
+	SMS_MOBILE_OPTED_OUT = 12033 // 手机号已退订营销短信
+)
+
+var (
+	// 退订来源：10: 用户回复退订关键字；20: 公司手动添加
+	SMS_OPT_OUT_SOURCE_REPLY  = 10
+	SMS_OPT_OUT_SOURCE_MANUAL = 20
+
+	SMS_OPT_OUT_QUERY_BATCH = 1000 // 每次查询手机号个数
+)
+
+type SmsOptOuts struct {
+	Id                  int       `orm:"column(sms_opt_out_id);auto"`
+	CompanyId           int       `orm:"column(company_id);null"`
+	Mobile              string    `orm:"column(mobile);size(20);null"`
+	Source              int16     `orm:"column(source);null"`
+	SmsInboundMessageId int       `orm:"column(sms_inbound_message_id);null"`
+	Status              int16     `orm:"column(status);null"`
+	UpdatedAt           time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt           time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsOptOuts) TableName() string {
+	return "sms_opt_outs"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsOptOuts))
+}
+
+// 上行短信内容是否为退订回复
+func IsSmsUnsubscribeReply(content string) bool {
+	content = strings.ToUpper(strings.Trim(content, " \t\r\n。.，,！!？?；;：:、'\"“”"))
+	if content == "" {
+		return false
+	}
+	for _, keyword := range conf.SmsUnsubscribeKeywords {
+		if content == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// 添加退订手机号，已退订则更新来源，已删除则恢复
+func AddSmsOptOut(companyId int, mobile string, source int, inboundMessageId int) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter AddSmsOptOut.", companyId, mobile)
+	defer Logger.Info("[%v.%v] left AddSmsOptOut.", companyId, mobile)
+	var (
+		optOuts []SmsOptOuts = []SmsOptOuts{}
+	)
+	if mobile, retcode, err = normalizeSmsMobile(mobile); err != nil {
+		err = errors.Wrap(err, "AddSmsOptOut")
+		return
+	}
+	now := time.Now()
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsOptOuts{}).TableName()).Filter("company_id", companyId).Filter("mobile", mobile).All(&optOuts); err != nil {
+		err = errors.Wrap(err, "AddSmsOptOut")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if len(optOuts) > 0 {
+		optOuts[0].Source = int16(source)
+		optOuts[0].SmsInboundMessageId = inboundMessageId
+		optOuts[0].Status = int16(utils.STATUS_VALID)
+		optOuts[0].UpdatedAt = now
+		if _, err = o.Update(&optOuts[0], "source", "sms_inbound_message_id", "status", "updated_at"); err != nil {
+			err = errors.Wrap(err, "AddSmsOptOut")
+			retcode = utils.DB_UPDATE_ERROR
+			return
+		}
+		return
+	}
+	optOut := &SmsOptOuts{
+		CompanyId:           companyId,
+		Mobile:              mobile,
+		Source:              int16(source),
+		SmsInboundMessageId: inboundMessageId,
+		Status:              int16(utils.STATUS_VALID),
+		UpdatedAt:           now,
+		CreatedAt:           now,
+	}
+	if _, err = o.Insert(optOut); err != nil {
+		err = errors.Wrap(err, "AddSmsOptOut")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 删除公司的退订手机号，恢复接收该公司的营销短信
+func RemoveSmsOptOut(companyId int, mobile string) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter RemoveSmsOptOut.", companyId, mobile)
+	defer Logger.Info("[%v.%v] left RemoveSmsOptOut.", companyId, mobile)
+	if normalized, _, normalizeErr := normalizeSmsMobile(mobile); normalizeErr == nil {
+		mobile = normalized
+	}
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsOptOuts{}).TableName()).Filter("company_id", companyId).Filter("mobile", strings.TrimSpace(mobile)).Filter("status", utils.STATUS_VALID).Update(orm.Params{
+		"status":     SMS_STATUS_DELETED,
+		"updated_at": time.Now(),
+	}); err != nil {
+		err = errors.Wrap(err, "RemoveSmsOptOut")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 获取公司的退订手机号列表，mobile不为空时只查询该手机号
+func GetSmsOptOuts(companyId int, mobile string, offset int64, limit int64) (optOuts []SmsOptOuts, count int64, retcode int, err error) {
+	Logger.Info("[%v] enter GetSmsOptOuts.", companyId)
+	defer Logger.Info("[%v] left GetSmsOptOuts.", companyId)
+	optOuts = []SmsOptOuts{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsOptOuts{}).TableName()).Filter("company_id", companyId).Filter("status", utils.STATUS_VALID)
+	if mobile != "" {
+		qs = qs.Filter("mobile", mobile)
+	}
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetSmsOptOuts")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("-id").Limit(limit, offset).All(&optOuts); err != nil {
+		err = errors.Wrap(err, "GetSmsOptOuts")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+// 上行短信为退订回复时，退订原发送公司的营销短信；未关联到公司时全局退订
+func handleSmsInboundOptOut(message *SmsInboundMessages) {
+	if !IsSmsUnsubscribeReply(message.Content) {
+		return
+	}
+	if _, err := AddSmsOptOut(message.CompanyId, message.Mobile, SMS_OPT_OUT_SOURCE_REPLY, message.Id); err != nil {
+		Logger.Error(err.Error())
+	}
+	return
+}
+
+// 查询手机号中已退订公司营销短信(含全局退订)的手机号
+func GetSmsOptOutMobiles(companyId int, mobiles []string) (optOutMobiles map[string]bool, retcode int, err error) {
+	var (
+		optOuts []SmsOptOuts
+	)
+	optOutMobiles = map[string]bool{}
+	o := orm.NewOrm()
+	for start := 0; start < len(mobiles); start += SMS_OPT_OUT_QUERY_BATCH {
+		end := start + SMS_OPT_OUT_QUERY_BATCH
+		if end > len(mobiles) {
+			end = len(mobiles)
+		}
+		optOuts = []SmsOptOuts{}
+		if _, err = o.QueryTable((&SmsOptOuts{}).TableName()).Filter("company_id__in", 0, companyId).Filter("mobile__in", mobiles[start:end]).Filter("status", utils.STATUS_VALID).All(&optOuts, "Mobile"); err != nil {
+			err = errors.Wrap(err, "GetSmsOptOutMobiles")
+			retcode = utils.DB_READ_ERROR
+			return
+		}
+		for index := 0; index < len(optOuts); index++ {
+			optOutMobiles[optOuts[index].Mobile] = true
+		}
+	}
+	return
+}
+
+// 去掉营销短信请求中已退订的手机号(及对应的短信内容)，返回去掉的手机号；验证码短信不处理
+func StripSmsOptOuts(req *SmsRequest) (optOutMobiles []string, retcode int, err error) {
+	if req == nil || req.AccountType != SMS_CHUANGLAN_MARKETING_TYPE || len(req.Mobiles) <= 0 {
+		return
+	}
+	optOuts, retcode, err := GetSmsOptOutMobiles(req.CompanyId, req.Mobiles)
+	if err != nil {
+		return
+	}
+	optOutMobiles = stripSmsRequestMobiles(req, optOuts)
+	return
+}
@@ -0,0 +1,594 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信发件箱
+	>> 接口只写入一条待发送(pending)的短信并立即返回短信ID，由后台协程池异步发送
+	>> 协程取出短信时通过条件更新抢占(status+attempts作为乐观锁)，并把visible_at推后可见超时时间
+	>> 发送期间每隔可见超时时间的1/3推后一次visible_at，发送时间较长(如逐个手机号发送)时不会被其他协程再次取出
+	>> 服务商接收成功后先只把状态更新为已发送，再写入服务商、消息ID等结果字段，结果字段写入失败不会导致重复发送
+	>> 发送完成前进程崩溃，超过可见超时时间后短信重新可见，被其他协程再次发送(至少一次)
+	>> 定时发送的短信visible_at即为发送时间，到达发送时间前不会被取出，服务重启后仍然有效；
+	   未发送前可取消
+	>> 发送时间和重新发送时间不在发送时间窗口内时，推迟到下一个允许发送的时间，见sms_send_periods.go
+	>> 暂时性错误发送失败时，重新置为待发送并按指数退避推后visible_at，见sms_retry.go
+*/
+
+var (
+	// 发件箱短信状态：10: 待发送(含定时发送和等待重新发送)；20: 发送中；30: 已发送；40: 发送失败；50: 已取消；60: 已暂停
+	SMS_OUTBOX_PENDING  = 10
+	SMS_OUTBOX_SENDING  = 20
+	SMS_OUTBOX_SENT     = 30
+	SMS_OUTBOX_FAILED   = 40
+	SMS_OUTBOX_CANCELED = 50
+	SMS_OUTBOX_PAUSED   = 60
+
+	SMS_SEND_AT_LAYOUT = "2006-01-02 15:04:05" // 定时发送时间格式
+
+	SMS_OUTBOX_CLAIM_BATCH = 10 // 每次查询待发送短信条数
+
+	SMS_OUTBOX_SENT_UPDATE_ATTEMPTS = 5 // 服务商接收成功后，更新为已发送的最多尝试次数
+)
+
+type SmsOutboxMessages struct {
+	Id                   int       `orm:"column(sms_outbox_message_id);auto"`
+	CompanyId            int       `orm:"column(company_id);null"`
+	SmsCampaignId        int       `orm:"column(sms_campaign_id);null"`
+	AccountType          int16     `orm:"column(account_type);null"`
+	Request              string    `orm:"column(request);type(text);null"`
+	Status               int16     `orm:"column(status);null"`
+	Attempts             int       `orm:"column(attempts);null"`
+	VisibleAt            time.Time `orm:"column(visible_at);type(datetime);null"`
+	SendAt               time.Time `orm:"column(send_at);type(datetime);null"`
+	SmsServiceProviderId int       `orm:"column(sms_service_provider_id);null"`
+	MessageId            string    `orm:"column(message_id);size(100);null"`
+	Retcode              int       `orm:"column(retcode);null"`
+	ErrMsg               string    `orm:"column(err_msg);size(500);null"`
+	UpdatedAt            time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt            time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsOutboxMessages) TableName() string {
+	return "sms_outbox_messages"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsOutboxMessages))
+}
+
+func (t *SmsOutboxMessages) ReadSmsOutboxMessageNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter ReadSmsOutboxMessageNoLock.", t.Id)
+	defer Logger.Info("[%v] left ReadSmsOutboxMessageNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if err = (*o).Read(t); err != nil {
+		err = errors.Wrap(err, "ReadSmsOutboxMessageNoLock")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+func (t *SmsOutboxMessages) InsertSmsOutboxMessageNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter InsertSmsOutboxMessageNoLock.", t.CompanyId)
+	defer Logger.Info("[%v] left InsertSmsOutboxMessageNoLock.", t.CompanyId)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Insert(t); err != nil {
+		err = errors.Wrap(err, "InsertSmsOutboxMessageNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 发送结束后更新短信状态，只有仍持有该短信(发送中且attempts未变化)时才更新，
+// 避免覆盖其他协程的重新发送，以及把已暂停、已取消的短信重新置为待发送
+func (t *SmsOutboxMessages) UpdateSmsOutboxMessageNoLock(o *orm.Ormer, fields ...string) (retcode int, err error) {
+	Logger.Info("[%v] enter UpdateSmsOutboxMessageNoLock.", t.Id)
+	defer Logger.Info("[%v] left UpdateSmsOutboxMessageNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).QueryTable(t.TableName()).Filter("id", t.Id).Filter("status", SMS_OUTBOX_SENDING).Filter("attempts", t.Attempts).Update(t.getUpdateParams(fields)); err != nil {
+		err = errors.Wrap(err, "UpdateSmsOutboxMessageNoLock")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 已发送的短信写入发送结果(服务商、消息ID等)，状态已先由markSmsOutboxMessageSent更新为已发送
+func (t *SmsOutboxMessages) UpdateSentSmsOutboxMessageNoLock(o *orm.Ormer, fields ...string) (retcode int, err error) {
+	Logger.Info("[%v] enter UpdateSentSmsOutboxMessageNoLock.", t.Id)
+	defer Logger.Info("[%v] left UpdateSentSmsOutboxMessageNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).QueryTable(t.TableName()).Filter("id", t.Id).Filter("status", SMS_OUTBOX_SENT).Filter("attempts", t.Attempts).Update(t.getUpdateParams(fields)); err != nil {
+		err = errors.Wrap(err, "UpdateSentSmsOutboxMessageNoLock")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+func (t *SmsOutboxMessages) getUpdateParams(fields []string) (params orm.Params) {
+	params = orm.Params{}
+	for _, field := range fields {
+		switch field {
+		case "status":
+			params[field] = t.Status
+		case "visible_at":
+			params[field] = t.VisibleAt
+		case "sms_service_provider_id":
+			params[field] = t.SmsServiceProviderId
+		case "message_id":
+			params[field] = t.MessageId
+		case "retcode":
+			params[field] = t.Retcode
+		case "err_msg":
+			params[field] = t.ErrMsg
+		}
+	}
+	params["updated_at"] = t.UpdatedAt
+	return
+}
+
+// 解析定时发送时间，按db::time_loc时区；为空表示立即发送
+func ParseSmsSendAt(value string) (sendAt time.Time, retcode int, err error) {
+	var (
+		loc *time.Location
+	)
+	if value = strings.TrimSpace(value); value == "" {
+		return
+	}
+	if loc, err = time.LoadLocation(conf.DBTimeLoc); err != nil {
+		err = errors.Wrap(err, "ParseSmsSendAt")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if sendAt, err = time.ParseInLocation(SMS_SEND_AT_LAYOUT, value, loc); err != nil {
+		err = errors.Wrap(err, "ParseSmsSendAt")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	return
+}
+
+// 生成待发送的发件箱短信；sendAt为空或早于当前时间时立即发送(发送时间窗口内)
+func newSmsOutboxMessage(req *SmsRequest, sendAt time.Time) (message *SmsOutboxMessages, retcode int, err error) {
+	var (
+		body []byte
+	)
+	if req == nil || len(req.Mobiles) <= 0 {
+		err = errors.New("param `mobiles` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if body, err = json.Marshal(req); err != nil {
+		err = errors.Wrap(err, "newSmsOutboxMessage")
+		retcode = utils.JSON_PARSE_FAILED
+		return
+	}
+	now := time.Now()
+	if sendAt.Before(now) {
+		sendAt = now
+	}
+	// 不在发送时间窗口内，推迟到下一个允许发送的时间
+	sendAt = GetAllowedSmsSendTime(req.CompanyId, req.AccountType, sendAt)
+	message = &SmsOutboxMessages{
+		CompanyId:   req.CompanyId,
+		AccountType: int16(req.AccountType),
+		Request:     string(body),
+		Status:      int16(SMS_OUTBOX_PENDING),
+		VisibleAt:   sendAt,
+		SendAt:      sendAt,
+		UpdatedAt:   now,
+		CreatedAt:   now,
+	}
+	return
+}
+
+// 写入发件箱，返回发件箱短信ID
+func EnqueueSms(req *SmsRequest, sendAt time.Time) (outboxId int, retcode int, err error) {
+	Logger.Info("enter EnqueueSms.")
+	defer Logger.Info("left EnqueueSms.")
+	message, retcode, err := newSmsOutboxMessage(req, sendAt)
+	if err != nil {
+		err = errors.Wrap(err, "EnqueueSms")
+		return
+	}
+	o := orm.NewOrm()
+	if retcode, err = message.InsertSmsOutboxMessageNoLock(&o); err != nil {
+		err = errors.Wrap(err, "EnqueueSms")
+		return
+	}
+	if retcode, err = InsertQueuedSmsDeliveriesNoLock(&o, message, req.Mobiles); err != nil {
+		err = errors.Wrap(err, "EnqueueSms")
+		return
+	}
+	outboxId = message.Id
+	return
+}
+
+// 获取公司尚未到达发送时间的定时短信(不含群发活动)，按发送时间升序
+func GetScheduledSmsOutboxMessages(companyId int, offset int64, limit int64) (messages []SmsOutboxMessages, count int64, retcode int, err error) {
+	Logger.Info("[%v] enter GetScheduledSmsOutboxMessages.", companyId)
+	defer Logger.Info("[%v] left GetScheduledSmsOutboxMessages.", companyId)
+	messages = []SmsOutboxMessages{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("company_id", companyId).Filter("sms_campaign_id", 0).Filter("status", SMS_OUTBOX_PENDING).Filter("attempts", 0).Filter("send_at__gt", time.Now())
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetScheduledSmsOutboxMessages")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("send_at", "id").Limit(limit, offset).All(&messages); err != nil {
+		err = errors.Wrap(err, "GetScheduledSmsOutboxMessages")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+// 取消公司待发送的短信，已被取出发送或者已发送完成的短信不能取消；群发活动的短信通过活动取消
+func CancelSmsOutboxMessage(companyId int, outboxId int) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter CancelSmsOutboxMessage.", companyId, outboxId)
+	defer Logger.Info("[%v.%v] left CancelSmsOutboxMessage.", companyId, outboxId)
+	var (
+		num int64
+	)
+	o := orm.NewOrm()
+	num, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("id", outboxId).Filter("company_id", companyId).Filter("sms_campaign_id", 0).Filter("status", SMS_OUTBOX_PENDING).Update(orm.Params{
+		"status":     SMS_OUTBOX_CANCELED,
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "CancelSmsOutboxMessage")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	if num <= 0 {
+		err = errors.New("sms message not exist or not pending")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	return
+}
+
+// 抢占一条可发送的短信：待发送，或者发送中但已超过可见超时时间
+func claimSmsOutboxMessage() (message *SmsOutboxMessages, err error) {
+	var (
+		candidates []SmsOutboxMessages = []SmsOutboxMessages{}
+		num        int64
+	)
+	now := time.Now()
+	o := orm.NewOrm()
+	_, err = o.QueryTable((&SmsOutboxMessages{}).TableName()).Filter("status__in", SMS_OUTBOX_PENDING, SMS_OUTBOX_SENDING).Filter("visible_at__lte", now).OrderBy("visible_at").Limit(SMS_OUTBOX_CLAIM_BATCH).All(&candidates)
+	if err != nil {
+		err = errors.Wrap(err, "claimSmsOutboxMessage")
+		return
+	}
+	for index := 0; index < len(candidates); index++ {
+		candidate := &candidates[index]
+		// 发送超时且已达到最大发送次数，不再重新发送
+		if candidate.Status == int16(SMS_OUTBOX_SENDING) && candidate.Attempts >= conf.SmsRetryMaxAttempts {
+			candidate.Status = int16(SMS_OUTBOX_FAILED)
+			candidate.ErrMsg = "sms send timeout"
+			candidate.UpdatedAt = now
+			if _, err = candidate.UpdateSmsOutboxMessageNoLock(&o, "status", "err_msg"); err != nil {
+				err = errors.Wrap(err, "claimSmsOutboxMessage")
+				return
+			}
+			continue
+		}
+		num, err = o.QueryTable(candidate.TableName()).Filter("id", candidate.Id).Filter("status", candidate.Status).Filter("attempts", candidate.Attempts).Update(orm.Params{
+			"status":     SMS_OUTBOX_SENDING,
+			"attempts":   orm.ColValue(orm.ColAdd, 1),
+			"visible_at": now.Add(conf.SmsOutboxVisibilityTimeout),
+			"updated_at": now,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "claimSmsOutboxMessage")
+			return
+		}
+		// 已被其他协程抢占
+		if num <= 0 {
+			continue
+		}
+		candidate.Status = int16(SMS_OUTBOX_SENDING)
+		candidate.Attempts++
+		return candidate, nil
+	}
+	return
+}
+
+// 发送发件箱短信并更新状态
+func processSmsOutboxMessage(message *SmsOutboxMessages) {
+	Logger.Info("[%v] enter processSmsOutboxMessage.", message.Id)
+	defer Logger.Info("[%v] left processSmsOutboxMessage.", message.Id)
+	var (
+		req *SmsRequest = new(SmsRequest)
+	)
+	o := orm.NewOrm()
+	if err := json.Unmarshal([]byte(message.Request), req); err != nil {
+		message.Status = int16(SMS_OUTBOX_FAILED)
+		message.Retcode = utils.JSON_PARSE_FAILED
+		message.ErrMsg = err.Error()
+		message.UpdatedAt = time.Now()
+		if _, err = message.UpdateSmsOutboxMessageNoLock(&o, "status", "retcode", "err_msg"); err != nil {
+			Logger.Error(err.Error())
+		}
+		if _, err = MarkSmsDeliveriesFailed(message.Id, message.Retcode); err != nil {
+			Logger.Error(err.Error())
+		}
+		return
+	}
+	req.OutboxId = message.Id
+	req.ExtendCode = GetSmsExtendCode(message.Id)
+	// 群发活动已暂停或者已取消，不再发送该批短信
+	if message.SmsCampaignId > 0 {
+		if status := getSmsCampaignHoldStatus(message.SmsCampaignId); status > 0 {
+			holdSmsOutboxMessage(message, status)
+			return
+		}
+	}
+	// 写入发件箱后才加入黑名单或者退订的手机号，发送前去掉
+	if filtered := stripFilteredSmsMobiles(message, req); filtered && len(req.Mobiles) <= 0 {
+		return
+	}
+	// 发送期间定期推后visible_at，发送时间超过可见超时时间时不会被其他协程再次取出发送
+	id, attempts := message.Id, message.Attempts
+	stopHeartbeat := startSmsOutboxHeartbeat(conf.SmsOutboxVisibilityTimeout/3, func() {
+		extendSmsOutboxMessageVisibleAt(id, attempts)
+	})
+	result, retcode, err := RouteSms(req)
+	if err == nil {
+		// 服务商已接收，先更新为已发送，避免结果字段写入失败时被再次取出重复发送(重复计费)
+		if _, sentErr := markSmsOutboxMessageSent(message); sentErr != nil {
+			Logger.Error(sentErr.Error())
+		}
+	}
+	stopHeartbeat()
+	now := time.Now()
+	message.Status = int16(SMS_OUTBOX_SENT)
+	message.Retcode = retcode
+	message.ErrMsg = ""
+	attempt := &SmsSendAttempts{
+		SmsOutboxMessageId: message.Id,
+		Attempt:            message.Attempts,
+		Retcode:            retcode,
+		CreatedAt:          now,
+	}
+	if err != nil {
+		Logger.Error("[%v] %s", message.Id, err.Error())
+		message.Status = int16(SMS_OUTBOX_FAILED)
+		message.ErrMsg = err.Error()
+		attempt.ErrMsg = err.Error()
+		// 暂时性错误且未达到最大发送次数，退避后重新发送
+		if IsTransientSmsError(result, retcode) && message.Attempts < conf.SmsRetryMaxAttempts {
+			message.Status = int16(SMS_OUTBOX_PENDING)
+			message.VisibleAt = GetAllowedSmsSendTime(req.CompanyId, req.AccountType, now.Add(GetSmsRetryBackoff(message.Attempts)))
+			attempt.Retryable = true
+			attempt.NextRetryAt = message.VisibleAt
+			// 发送期间群发活动已暂停或者已取消，不再重新发送
+			if message.SmsCampaignId > 0 {
+				if status := getSmsCampaignHoldStatus(message.SmsCampaignId); status > 0 {
+					message.Status = int16(status)
+					attempt.NextRetryAt = time.Time{}
+				}
+			}
+		}
+	}
+	if result != nil {
+		message.SmsServiceProviderId = result.SmsServiceProviderId
+		message.MessageId = result.MessageId
+		attempt.SmsServiceProviderId = result.SmsServiceProviderId
+	}
+	if _, insertErr := attempt.InsertSmsSendAttemptNoLock(&o); insertErr != nil {
+		Logger.Error(insertErr.Error())
+	}
+	message.UpdatedAt = now
+	if err == nil {
+		if _, updateErr := message.UpdateSentSmsOutboxMessageNoLock(&o, "sms_service_provider_id", "message_id", "retcode", "err_msg"); updateErr != nil {
+			Logger.Error(updateErr.Error())
+		}
+	} else {
+		fields := []string{"status", "sms_service_provider_id", "message_id", "retcode", "err_msg"}
+		if message.Status == int16(SMS_OUTBOX_PENDING) {
+			fields = append(fields, "visible_at")
+		}
+		if _, updateErr := message.UpdateSmsOutboxMessageNoLock(&o, fields...); updateErr != nil {
+			Logger.Error(updateErr.Error())
+		}
+	}
+	if err == nil {
+		// 扣除该公司所发送的短信和平台短信数量
+		DeductSmsRemaining(req, result)
+		// 部分手机号发送失败
+		for _, mobile := range result.GetFailedMobiles(req.Mobiles) {
+			if _, deliveryErr := MarkSmsDeliveriesFailed(message.Id, result.FailedMobiles[mobile], mobile); deliveryErr != nil {
+				Logger.Error(deliveryErr.Error())
+			}
+		}
+	} else if message.Status == int16(SMS_OUTBOX_FAILED) {
+		if _, deliveryErr := MarkSmsDeliveriesFailed(message.Id, retcode); deliveryErr != nil {
+			Logger.Error(deliveryErr.Error())
+		}
+	}
+	// 群发活动的分批短信，更新活动发送进度
+	switch {
+	case message.SmsCampaignId <= 0:
+	case message.Status == int16(SMS_OUTBOX_SENT) || message.Status == int16(SMS_OUTBOX_FAILED):
+		UpdateSmsCampaignProgress(message)
+	case message.Status == int16(SMS_OUTBOX_CANCELED):
+		CancelSmsCampaignRecipients(message)
+	}
+	return
+}
+
+// 群发活动已暂停或者已取消时，发送中的分批短信置为已暂停(恢复活动后重新发送)或者已取消
+func holdSmsOutboxMessage(message *SmsOutboxMessages, status int) {
+	o := orm.NewOrm()
+	message.Status = int16(status)
+	message.UpdatedAt = time.Now()
+	if _, err := message.UpdateSmsOutboxMessageNoLock(&o, "status"); err != nil {
+		Logger.Error(err.Error())
+		return
+	}
+	if status == SMS_OUTBOX_CANCELED {
+		CancelSmsCampaignRecipients(message)
+	}
+	return
+}
+
+// 服务商接收成功后把发件箱短信更新为已发送，更新失败时重试，只更新状态
+func markSmsOutboxMessageSent(message *SmsOutboxMessages) (retcode int, err error) {
+	o := orm.NewOrm()
+	message.Status = int16(SMS_OUTBOX_SENT)
+	for attempt := 1; attempt <= SMS_OUTBOX_SENT_UPDATE_ATTEMPTS; attempt++ {
+		message.UpdatedAt = time.Now()
+		if retcode, err = message.UpdateSmsOutboxMessageNoLock(&o, "status"); err == nil {
+			return
+		}
+		Logger.Error("[%v.%v] %s", message.Id, attempt, err.Error())
+		if attempt < SMS_OUTBOX_SENT_UPDATE_ATTEMPTS {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	err = errors.Wrap(err, "markSmsOutboxMessageSent")
+	return
+}
+
+// 推后发送中短信的visible_at，只有仍持有该短信(发送中且attempts未变化)时才更新
+func extendSmsOutboxMessageVisibleAt(id int, attempts int) {
+	o := orm.NewOrm()
+	now := time.Now()
+	message := &SmsOutboxMessages{
+		Id:        id,
+		Attempts:  attempts,
+		VisibleAt: now.Add(conf.SmsOutboxVisibilityTimeout),
+		UpdatedAt: now,
+	}
+	if _, err := message.UpdateSmsOutboxMessageNoLock(&o, "visible_at"); err != nil {
+		Logger.Error(err.Error())
+	}
+	return
+}
+
+// 每隔interval调用一次extend，直到调用返回的stop；stop返回后不会再调用extend
+func startSmsOutboxHeartbeat(interval time.Duration, extend func()) (stop func()) {
+	var (
+		done chan struct{} = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	if interval <= 0 {
+		return func() {}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				extend()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// 发送前去掉无效的、黑名单中和已退订的手机号，这些接收人记为发送失败；所有手机号均被去掉时，发件箱短信发送失败
+func stripFilteredSmsMobiles(message *SmsOutboxMessages, req *SmsRequest) (filtered bool) {
+	filteredMobiles, _, err := FilterSmsRequest(req)
+	if err != nil {
+		// 查询失败时不影响发送
+		Logger.Error(err.Error())
+	}
+	if filteredMobiles.Count() <= 0 {
+		return
+	}
+	filtered = true
+	for retcode, mobiles := range map[int][]string{
+		SMS_MOBILE_INVALID:     filteredMobiles.InvalidMobileList(),
+		SMS_MOBILE_BLACKLISTED: filteredMobiles.BlacklistMobiles,
+		SMS_MOBILE_OPTED_OUT:   filteredMobiles.OptOutMobiles,
+	} {
+		if len(mobiles) <= 0 {
+			continue
+		}
+		if _, err = MarkSmsDeliveriesFailed(message.Id, retcode, mobiles...); err != nil {
+			Logger.Error(err.Error())
+		}
+		if message.SmsCampaignId > 0 {
+			FailSmsCampaignRecipients(message, mobiles, retcode)
+		}
+	}
+	if len(req.Mobiles) <= 0 {
+		o := orm.NewOrm()
+		message.Status = int16(SMS_OUTBOX_FAILED)
+		message.Retcode = filteredMobiles.Retcode()
+		message.ErrMsg = "all mobiles invalid, blacklisted or opted out"
+		message.UpdatedAt = time.Now()
+		if _, err = message.UpdateSmsOutboxMessageNoLock(&o, "status", "retcode", "err_msg"); err != nil {
+			Logger.Error(err.Error())
+		}
+		if message.SmsCampaignId > 0 {
+			UpdateSmsCampaignProgress(message)
+		}
+	}
+	return
+}
+
+func runSmsOutboxWorker(workerId int) {
+	Logger.Info("[%v] sms outbox worker started.", workerId)
+	for {
+		message, err := claimSmsOutboxMessage()
+		if err != nil {
+			Logger.Error(err.Error())
+		}
+		if message == nil {
+			time.Sleep(conf.SmsOutboxPollInterval)
+			continue
+		}
+		processSmsOutboxMessage(message)
+	}
+}
+
+// 启动发件箱后台发送协程池
+func StartSmsOutboxWorkers(workerCount int) {
+	for index := 0; index < workerCount; index++ {
+		go runSmsOutboxWorker(index)
+	}
+	return
+}
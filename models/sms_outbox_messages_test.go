@@ -0,0 +1,54 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSmsOutboxHeartbeat(t *testing.T) {
+	var (
+		mutex     sync.Mutex
+		extends   int
+		visibleAt time.Time
+	)
+	timeout := 100 * time.Millisecond
+	now := time.Now()
+	visibleAt = now.Add(timeout)
+	stop := startSmsOutboxHeartbeat(timeout/3, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		extends++
+		visibleAt = time.Now().Add(timeout)
+	})
+	// 发送时间为可见超时时间的5倍(如：创蓝逐个手机号发送)，发送期间短信始终不可被再次取出
+	for deadline := now.Add(5 * timeout); time.Now().Before(deadline); time.Sleep(timeout / 10) {
+		mutex.Lock()
+		claimable := !visibleAt.After(time.Now())
+		mutex.Unlock()
+		if claimable {
+			t.Fatalf("visible_at %v passed while sending", visibleAt)
+		}
+	}
+	stop()
+	mutex.Lock()
+	stopped := extends
+	mutex.Unlock()
+	if stopped < 10 {
+		t.Errorf("extends = %d while sending, expected at least 10", stopped)
+	}
+	time.Sleep(timeout)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if extends != stopped {
+		t.Errorf("extends = %d after stop, expected %d", extends, stopped)
+	}
+}
+
+func TestSmsOutboxHeartbeatDisabled(t *testing.T) {
+	stop := startSmsOutboxHeartbeat(0, func() {
+		t.Errorf("extend called with interval 0")
+	})
+	time.Sleep(10 * time.Millisecond)
+	stop()
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"net/url"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信服务提供商回调
+	>> 每种服务商在init中注册自己的回调处理方法(状态报告推送、上行短信推送)，与工厂方法一样按code或者type对应
+	>> 统一回调地址：状态报告/v1/sms/providers/{code}/callback，上行短信/v1/sms/providers/{code}/inbound，
+	   {code}为sms_service_providers表的服务商编码
+	>> 回调处理方法返回给服务商的响应内容，由各服务商按自己的接口约定生成
+	>> 调用回调处理方法前，先按服务商的回调认证配置校验来源IP、签名和时间戳(见sms_callback_auth.go)
+*/
+
+const (
+	SMS_CALLBACK_RECEIPT = "receipt" // 状态报告推送
+	SMS_CALLBACK_INBOUND = "inbound" // 上行短信推送
+)
+
+// 服务商回调处理方法，params为回调请求参数，body为回调请求体
+type SmsCallbackHandler func(provider ISMS, params url.Values, body []byte) (response []byte, retcode int, err error)
+
+var (
+	smsCallbackHandlersByCode = map[string]map[string]SmsCallbackHandler{}
+	smsCallbackHandlersByType = map[int16]map[string]SmsCallbackHandler{}
+)
+
+// 注册服务商回调处理方法, kind: SMS_CALLBACK_RECEIPT | SMS_CALLBACK_INBOUND
+func RegisterSmsCallbackHandler(providerType int, code string, kind string, handler SmsCallbackHandler) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if handler == nil {
+		panic("sms callback handler is nil: " + code)
+	}
+	if code != "" {
+		if _, exist := smsCallbackHandlersByCode[code]; !exist {
+			smsCallbackHandlersByCode[code] = map[string]SmsCallbackHandler{}
+		}
+		smsCallbackHandlersByCode[code][kind] = handler
+	}
+	if providerType > 0 {
+		if _, exist := smsCallbackHandlersByType[int16(providerType)]; !exist {
+			smsCallbackHandlersByType[int16(providerType)] = map[string]SmsCallbackHandler{}
+		}
+		smsCallbackHandlersByType[int16(providerType)][kind] = handler
+	}
+}
+
+// 处理服务商回调：通过服务商编码找到已启用的服务商及其回调处理方法，clientIp为回调来源IP
+func HandleSmsCallback(code string, kind string, clientIp string, params url.Values, body []byte) (response []byte, retcode int, err error) {
+	Logger.Info("[%v.%v] enter HandleSmsCallback.", code, kind)
+	defer Logger.Info("[%v.%v] left HandleSmsCallback.", code, kind)
+	var (
+		handler SmsCallbackHandler
+		exist   bool
+	)
+	provider := GetSmsProviderByCode(code)
+	if provider == nil {
+		err = errors.New("sms service provider `" + code + "` not exist or unabled")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if retcode, err = VerifySmsCallback(code, clientIp, params, body); err != nil {
+		err = errors.Wrap(err, "HandleSmsCallback")
+		return
+	}
+	registryMutex.RLock()
+	if handler, exist = smsCallbackHandlersByCode[code][kind]; !exist {
+		handler, exist = smsCallbackHandlersByType[smsProviderTypesByCode[code]][kind]
+	}
+	registryMutex.RUnlock()
+	if !exist {
+		err = errors.New("sms service provider `" + code + "` not support " + kind + " callback")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	return handler(provider, params, body)
+}
+
+// 解析并保存服务商推送的状态报告，供各服务商的状态报告回调处理方法调用
+func SaveSmsReceiptCallback(provider ISMS, params url.Values, body []byte) (retcode int, err error) {
+	receipts, retcode, err := provider.ParseReceipts(params, body)
+	if err != nil {
+		err = errors.Wrap(err, "SaveSmsReceiptCallback")
+		return
+	}
+	if retcode, err = SaveSmsReceipts(provider.GetSmsServiceProviderId(), receipts); err != nil {
+		err = errors.Wrap(err, "SaveSmsReceiptCallback")
+		return
+	}
+	return
+}
+
+// 解析并保存服务商推送的上行短信，供各服务商的上行短信回调处理方法调用
+func SaveSmsInboundCallback(provider ISMS, params url.Values, body []byte) (retcode int, err error) {
+	inbounds, retcode, err := provider.ParseInbounds(params, body)
+	if err != nil {
+		err = errors.Wrap(err, "SaveSmsInboundCallback")
+		return
+	}
+	if _, retcode, err = SaveSmsInbounds(provider.GetSmsServiceProviderId(), inbounds); err != nil {
+		err = errors.Wrap(err, "SaveSmsInboundCallback")
+		return
+	}
+	return
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+	短信服务商健康状态与熔断
+	>> 每个服务商记录最近SMS_HEALTH_WINDOW_SIZE次发送结果，统计错误率、平均耗时和连续失败次数
+	>> 熔断关闭(closed)：正常发送；连续失败达到SMS_CIRCUIT_CONSECUTIVE_FAILURES次，
+	   或者窗口内发送次数不少于SMS_CIRCUIT_MIN_CALLS且错误率达到SMS_CIRCUIT_ERROR_RATE，熔断打开
+	>> 熔断打开(open)：不再向该服务商发送，超过SMS_CIRCUIT_OPEN_DURATION后进入半开状态
+	>> 熔断半开(half_open)：只放行一次探测发送，成功则关闭熔断，失败则重新打开
+	>> 只有服务商侧故障(IsRetryableError)计为失败，短信自身问题导致的失败不影响服务商健康状态
+*/
+
+const (
+	SMS_CIRCUIT_CLOSED    = "closed"
+	SMS_CIRCUIT_OPEN      = "open"
+	SMS_CIRCUIT_HALF_OPEN = "half_open"
+
+	// 错误码
+	SMS_PROVIDER_CIRCUIT_OPEN = 12031 // 所有短信服务商均已熔断
+)
+
+var (
+	SMS_HEALTH_WINDOW_SIZE           = 50               // 统计窗口：最近发送次数
+	SMS_CIRCUIT_CONSECUTIVE_FAILURES = 5                // 连续失败次数达到该值，熔断打开
+	SMS_CIRCUIT_MIN_CALLS            = 20               // 按错误率熔断时，窗口内最少发送次数
+	SMS_CIRCUIT_ERROR_RATE           = 0.5              // 窗口内错误率达到该值，熔断打开
+	SMS_CIRCUIT_OPEN_DURATION        = 30 * time.Second // 熔断打开持续时间，之后进入半开状态探测
+
+	smsProviderHealths      = map[int]*smsProviderHealth{}
+	smsProviderHealthsMutex sync.Mutex
+)
+
+type smsCallResult struct {
+	failed  bool
+	latency time.Duration
+}
+
+type smsProviderHealth struct {
+	state               string
+	results             []smsCallResult // 环形窗口
+	next                int
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool // 半开状态下是否已放行探测请求
+	lastError           string
+	lastErrorAt         time.Time
+}
+
+// 服务商健康状态快照
+type SmsProviderHealthInfo struct {
+	SmsServiceProviderId int       `json:"sms_service_provider_id"`
+	State                string    `json:"state"`
+	Calls                int       `json:"calls"`
+	ErrorRate            float64   `json:"error_rate"`
+	AvgLatencyMs         int64     `json:"avg_latency_ms"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	LastError            string    `json:"last_error"`
+	LastErrorAt          time.Time `json:"last_error_at"`
+	OpenedAt             time.Time `json:"opened_at"`
+}
+
+func getSmsProviderHealth(providerId int) *smsProviderHealth {
+	health, exist := smsProviderHealths[providerId]
+	if !exist {
+		health = &smsProviderHealth{
+			state: SMS_CIRCUIT_CLOSED,
+		}
+		smsProviderHealths[providerId] = health
+	}
+	return health
+}
+
+func (t *smsProviderHealth) stat() (calls int, failures int, latency time.Duration) {
+	for index := 0; index < len(t.results); index++ {
+		if t.results[index].failed {
+			failures++
+		}
+		latency += t.results[index].latency
+	}
+	calls = len(t.results)
+	return
+}
+
+func (t *smsProviderHealth) open(now time.Time) {
+	t.state = SMS_CIRCUIT_OPEN
+	t.openedAt = now
+	t.probing = false
+}
+
+// 熔断器是否允许向该服务商发送
+func AllowSmsProvider(providerId int) bool {
+	smsProviderHealthsMutex.Lock()
+	defer smsProviderHealthsMutex.Unlock()
+	health := getSmsProviderHealth(providerId)
+	switch health.state {
+	case SMS_CIRCUIT_OPEN:
+		if time.Since(health.openedAt) < SMS_CIRCUIT_OPEN_DURATION {
+			return false
+		}
+		health.state = SMS_CIRCUIT_HALF_OPEN
+		health.probing = true
+		return true
+	case SMS_CIRCUIT_HALF_OPEN:
+		if health.probing {
+			return false
+		}
+		health.probing = true
+		return true
+	}
+	return true
+}
+
+// 记录一次服务商发送结果，并更新熔断状态
+func RecordSmsProviderResult(providerId int, latency time.Duration, failedErr error) {
+	smsProviderHealthsMutex.Lock()
+	defer smsProviderHealthsMutex.Unlock()
+	now := time.Now()
+	health := getSmsProviderHealth(providerId)
+	result := smsCallResult{
+		failed:  failedErr != nil,
+		latency: latency,
+	}
+	if len(health.results) < SMS_HEALTH_WINDOW_SIZE {
+		health.results = append(health.results, result)
+	} else {
+		health.results[health.next] = result
+	}
+	health.next = (health.next + 1) % SMS_HEALTH_WINDOW_SIZE
+	if failedErr == nil {
+		health.consecutiveFailures = 0
+		if health.state == SMS_CIRCUIT_HALF_OPEN {
+			health.state = SMS_CIRCUIT_CLOSED
+			health.probing = false
+			health.results = nil
+			health.next = 0
+		}
+		return
+	}
+	health.consecutiveFailures++
+	health.lastError = failedErr.Error()
+	health.lastErrorAt = now
+	if health.state == SMS_CIRCUIT_HALF_OPEN {
+		health.open(now)
+		return
+	}
+	calls, failures, _ := health.stat()
+	if health.consecutiveFailures >= SMS_CIRCUIT_CONSECUTIVE_FAILURES ||
+		(calls >= SMS_CIRCUIT_MIN_CALLS && float64(failures)/float64(calls) >= SMS_CIRCUIT_ERROR_RATE) {
+		health.open(now)
+	}
+	return
+}
+
+// 获取服务商健康状态快照
+func GetSmsProviderHealthInfo(providerId int) (info *SmsProviderHealthInfo) {
+	smsProviderHealthsMutex.Lock()
+	defer smsProviderHealthsMutex.Unlock()
+	health := getSmsProviderHealth(providerId)
+	calls, failures, latency := health.stat()
+	info = &SmsProviderHealthInfo{
+		SmsServiceProviderId: providerId,
+		State:                health.state,
+		Calls:                calls,
+		ConsecutiveFailures:  health.consecutiveFailures,
+		LastError:            health.lastError,
+		LastErrorAt:          health.lastErrorAt,
+		OpenedAt:             health.openedAt,
+	}
+	if calls > 0 {
+		info.ErrorRate = float64(failures) / float64(calls)
+		info.AvgLatencyMs = int64(latency/time.Millisecond) / int64(calls)
+	}
+	return
+}
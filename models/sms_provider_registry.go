@@ -0,0 +1,157 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信服务提供商注册表
+	>> 每种服务商在init中注册自己的工厂方法，按code或者type与sms_service_providers表记录对应
+	>> 注册表加载sms_service_providers表中所有已启用(is_valid=20)且有效(status=10)的记录，为每条记录创建服务商实例
+	>> 启用或停用服务商只需修改表记录，注册表按SMS_PROVIDER_RELOAD_INTERVAL周期重新加载
+*/
+
+// 短信服务提供商工厂方法，根据sms_service_providers表记录创建服务商实例
+type SmsProviderFactory func(provider *SmsServiceProviders) (instance ISMS, retcode int, err error)
+
+var (
+	SMS_PROVIDER_RELOAD_INTERVAL = time.Minute // 服务商注册表重新加载周期
+
+	smsProviderFactoriesByCode = map[string]SmsProviderFactory{}
+	smsProviderFactoriesByType = map[int16]SmsProviderFactory{}
+
+	smsProvidersById       map[int]ISMS
+	smsProvidersByCode     map[string]ISMS
+	smsProviderTypesByCode map[string]int16            // 服务商编码对应的服务商类型，用于查找回调处理方法
+	smsProviderAuthsByCode map[string]*smsCallbackAuth // 服务商编码对应的回调认证配置
+	smsProviderIds         []int                       // 已加载的服务商ID列表，升序
+	smsProvidersLoadAt     time.Time
+	registryMutex          sync.RWMutex
+)
+
+// 注册短信服务提供商工厂方法
+func RegisterSmsProviderFactory(providerType int, code string, factory SmsProviderFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if factory == nil {
+		panic("sms provider factory is nil: " + code)
+	}
+	if code != "" {
+		smsProviderFactoriesByCode[code] = factory
+	}
+	if providerType > 0 {
+		smsProviderFactoriesByType[int16(providerType)] = factory
+	}
+}
+
+// 从sms_service_providers表重新加载所有已启用的短信服务提供商
+func ReloadSmsProviders() (retcode int, err error) {
+	Logger.Info("enter ReloadSmsProviders.")
+	defer Logger.Info("left ReloadSmsProviders.")
+	var (
+		smsServiceProviders []SmsServiceProviders = []SmsServiceProviders{}
+		factory             SmsProviderFactory
+		exist               bool
+	)
+	o := orm.NewOrm()
+	_, err = o.QueryTable((&SmsServiceProviders{}).TableName()).Filter("status", utils.STATUS_VALID).Filter("is_valid", SMS_SERVICE_VALID).All(&smsServiceProviders)
+	if err != nil {
+		err = errors.Wrap(err, "ReloadSmsProviders")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	providersById := map[int]ISMS{}
+	providersByCode := map[string]ISMS{}
+	providerTypesByCode := map[string]int16{}
+	providerAuthsByCode := map[string]*smsCallbackAuth{}
+	providerIds := []int{}
+	for index := 0; index < len(smsServiceProviders); index++ {
+		provider := &smsServiceProviders[index]
+		registryMutex.RLock()
+		if factory, exist = smsProviderFactoriesByCode[strings.TrimSpace(provider.Code)]; !exist {
+			factory, exist = smsProviderFactoriesByType[provider.Type]
+		}
+		registryMutex.RUnlock()
+		if !exist {
+			Logger.Warn("[%v.%v] sms provider factory not registered.", provider.Id, provider.Code)
+			continue
+		}
+		instance, _, factoryErr := factory(provider)
+		if factoryErr != nil || instance == nil {
+			if factoryErr != nil {
+				Logger.Error(factoryErr.Error())
+			}
+			continue
+		}
+		providersById[provider.Id] = instance
+		if _, exist = providersByCode[provider.Code]; !exist {
+			providersByCode[provider.Code] = instance
+			providerTypesByCode[provider.Code] = provider.Type
+			providerAuthsByCode[provider.Code] = newSmsCallbackAuth(provider)
+		}
+		providerIds = append(providerIds, provider.Id)
+	}
+	sort.Ints(providerIds)
+	registryMutex.Lock()
+	smsProvidersById = providersById
+	smsProvidersByCode = providersByCode
+	smsProviderTypesByCode = providerTypesByCode
+	smsProviderAuthsByCode = providerAuthsByCode
+	smsProviderIds = providerIds
+	smsProvidersLoadAt = time.Now()
+	registryMutex.Unlock()
+	return
+}
+
+// 注册表首次使用或者超过重新加载周期时，重新加载服务商
+func loadSmsProvidersIfExpired() {
+	registryMutex.RLock()
+	expired := smsProvidersById == nil || time.Since(smsProvidersLoadAt) > SMS_PROVIDER_RELOAD_INTERVAL
+	registryMutex.RUnlock()
+	if expired {
+		if _, err := ReloadSmsProviders(); err != nil {
+			Logger.Error(err.Error())
+		}
+	}
+}
+
+// 通过sms_service_provider_id获取短信服务提供商
+func GetSmsProviderById(id int) (provider ISMS) {
+	loadSmsProvidersIfExpired()
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	if instance, exist := smsProvidersById[id]; exist {
+		return instance
+	}
+	return nil
+}
+
+// 通过服务商编码获取短信服务提供商, 如：253_CHUANGLAN_SMS_SERVICE
+func GetSmsProviderByCode(code string) (provider ISMS) {
+	loadSmsProvidersIfExpired()
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	if instance, exist := smsProvidersByCode[code]; exist {
+		return instance
+	}
+	return nil
+}
+
+// 获取所有已启用的短信服务提供商，按sms_service_provider_id升序
+func GetSmsProviders() (providers []ISMS) {
+	loadSmsProvidersIfExpired()
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for index := 0; index < len(smsProviderIds); index++ {
+		providers = append(providers, smsProvidersById[smsProviderIds[index]])
+	}
+	return
+}
@@ -37,6 +37,35 @@ func (t *SmsReceiptFailedRecords) InsertSmsReceiptFailedRecordNoLock(o *orm.Orme
 	return
 }
 
+// 保存服务商推送的短信状态报告：更新接收人送达状态，发送失败的回执同时记录到失败回执表
+func SaveSmsReceipts(providerId int, receipts []SmsReceipt) (retcode int, err error) {
+	Logger.Info("[%v] enter SaveSmsReceipts.", providerId)
+	defer Logger.Info("[%v] left SaveSmsReceipts.", providerId)
+	o := orm.NewOrm()
+	for index := 0; index < len(receipts); index++ {
+		if retcode, err = updateSmsDeliveryByReceipt(&o, providerId, &receipts[index]); err != nil {
+			return
+		}
+		if receipts[index].ReceiptStatus <= 0 {
+			continue
+		}
+		// 黑名单号码、审核驳回的手机号加入黑名单
+		addSmsBlacklistByReceipt(&receipts[index])
+		// 验证码短信未送达时回退到语音验证码
+		fallbackSmsVoiceByReceipt(&receipts[index])
+		record := &SmsReceiptFailedRecords{
+			MessageId:     receipts[index].MessageId,
+			Mobile:        receipts[index].Mobile,
+			ReceiptStatus: receipts[index].ReceiptStatus,
+			ReceiptAt:     receipts[index].ReceiptAt,
+		}
+		if retcode, err = record.InsertSmsReceiptFailedRecordNoLock(&o); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func init() {
 	orm.RegisterModel(new(SmsReceiptFailedRecords))
 }
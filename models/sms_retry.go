@@ -0,0 +1,56 @@
+package models
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/1046102779/sms/conf"
+)
+
+/*
+	短信重新发送
+	>> 所有服务商都发送失败后，按最后发送的服务商判断错误码是否为暂时性错误(IsTransientError)，
+	   或者所有服务商均已熔断，则按指数退避重新放回发件箱，直到达到最大发送次数
+	>> 第n次失败后的退避时间为 min(初始退避时间*2^(n-1), 最大退避时间)，并在[退避时间/2, 退避时间]之间随机，
+	   避免大量短信同时重新发送
+	>> 每次发送结果记录到sms_send_attempts
+*/
+
+var (
+	smsRetryRandom      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	smsRetryRandomMutex sync.Mutex
+)
+
+// 发送失败后是否可以稍后重新发送
+func IsTransientSmsError(result *SmsResult, retcode int) bool {
+	if retcode == SMS_PROVIDER_CIRCUIT_OPEN {
+		return true
+	}
+	if result == nil {
+		return false
+	}
+	provider := GetSmsProviderById(result.SmsServiceProviderId)
+	if provider == nil {
+		return false
+	}
+	return provider.IsTransientError(retcode)
+}
+
+// 第attempts次发送失败后的退避时间
+func GetSmsRetryBackoff(attempts int) (backoff time.Duration) {
+	backoff = conf.SmsRetryBaseInterval
+	for index := 1; index < attempts && backoff < conf.SmsRetryMaxInterval; index++ {
+		backoff *= 2
+	}
+	if backoff > conf.SmsRetryMaxInterval {
+		backoff = conf.SmsRetryMaxInterval
+	}
+	if backoff <= 0 {
+		return
+	}
+	smsRetryRandomMutex.Lock()
+	jitter := time.Duration(smsRetryRandom.Int63n(int64(backoff/2) + 1))
+	smsRetryRandomMutex.Unlock()
+	return backoff/2 + jitter
+}
@@ -0,0 +1,253 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信发送路由
+	>> 优先按路由规则(sms_routing_rules)选择服务商，其次按配置sms::provider_priority的服务商优先级，
+	   未配置的已启用服务商排在最后
+	>> 批量发送时按每个手机号匹配的路由规则分组，每组分别选择服务商发送
+	>> 服务商返回可重试错误(IsRetryableError)时，切换到下一个服务商重新发送同一条短信
+	>> 部分手机号发送失败时(见SmsResult.FailedMobiles)，只有失败的手机号切换服务商，已发送成功的手机号不再发送
+	>> 短信自身问题导致的失败(敏感词、号码错误等)不再切换服务商
+	>> 熔断中的服务商直接跳过，发送结果计入服务商健康状态
+	>> 最终发送结果及实际发送的服务商记录到sms_send_records，发送成功的接收人记录到sms_deliveries
+*/
+
+// 按优先级获取已启用的短信服务提供商列表
+func GetPrioritizedSmsProviders() (providers []ISMS) {
+	var (
+		added map[int]bool = map[int]bool{}
+	)
+	for _, code := range conf.SmsProviderPriority {
+		if provider := GetSmsProviderByCode(code); provider != nil && !added[provider.GetSmsServiceProviderId()] {
+			providers = append(providers, provider)
+			added[provider.GetSmsServiceProviderId()] = true
+		}
+	}
+	for _, provider := range GetSmsProviders() {
+		if !added[provider.GetSmsServiceProviderId()] {
+			providers = append(providers, provider)
+			added[provider.GetSmsServiceProviderId()] = true
+		}
+	}
+	return
+}
+
+// 替换短信内容开头的签名为服务商签名，如：【创蓝签名】内容 => 【云片签名】内容
+func resignSmsContent(content string, signName string) string {
+	if strings.HasPrefix(content, "【") {
+		if end := strings.Index(content, "】"); end > 0 {
+			return fmt.Sprintf("【%s】%s", signName, content[end+len("】"):])
+		}
+	}
+	return content
+}
+
+// 生成发往指定服务商的短信请求：采用服务商自己的模板和签名
+func renderSmsRequest(provider ISMS, req *SmsRequest) (providerReq *SmsRequest, retcode int, err error) {
+	var (
+		template *SmsTemplates
+	)
+	providerReq = new(SmsRequest)
+	*providerReq = *req
+	if req.TemplateName != "" {
+		template, retcode, err = GetSmsTemplate(provider.GetSmsServiceProviderId(), req.TemplateName)
+		if err != nil {
+			err = errors.Wrap(err, "renderSmsRequest")
+			return
+		}
+		if template == nil {
+			err = errors.New(fmt.Sprintf("sms template `%s` not exist", req.TemplateName))
+			retcode = utils.SOURCE_DATA_ILLEGAL
+			return
+		}
+		providerReq.SmsTemplateId = template.Id
+		if providerReq.Content, retcode, err = RenderSmsTemplate(template.TemplateContent, provider.GetSignName(), req.TemplateParams, req.TemplateArgs); err != nil {
+			err = errors.Wrap(err, "renderSmsRequest")
+			return
+		}
+		return
+	}
+	providerReq.Content = resignSmsContent(req.Content, provider.GetSignName())
+	if len(req.Contents) > 0 {
+		providerReq.Contents = make([]string, len(req.Contents))
+		for index := 0; index < len(req.Contents); index++ {
+			providerReq.Contents[index] = resignSmsContent(req.Contents[index], provider.GetSignName())
+		}
+	}
+	return
+}
+
+// 按路由规则对手机号分组发送，每组按服务商优先级发送，并增加短信发送记录
+// 部分手机号发送失败时返回成功，失败的手机号见result.FailedMobiles；所有手机号均发送失败时返回错误
+func RouteSms(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	Logger.Info("enter RouteSms.")
+	defer Logger.Info("left RouteSms.")
+	if req == nil || req.Mobiles == nil || len(req.Mobiles) <= 0 {
+		err = errors.New("param `mobiles` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	groups := groupSmsRequestByRoutingRules(req)
+	if len(groups) <= 1 {
+		return routeSmsGroup(req)
+	}
+	result = &SmsResult{
+		MessageIds:     map[string]string{},
+		FailedMobiles:  map[string]int{},
+		ProviderCounts: map[int]int{},
+	}
+	for _, group := range groups {
+		groupResult, code, groupErr := routeSmsGroup(group)
+		if groupErr != nil {
+			Logger.Error(groupErr.Error())
+			retcode, err = code, groupErr
+			groupResult = &SmsResult{
+				FailedMobiles: map[string]int{},
+			}
+			for _, mobile := range group.Mobiles {
+				groupResult.FailedMobiles[mobile] = code
+			}
+		} else if result.SmsServiceProviderId <= 0 {
+			result.SmsServiceProviderId = groupResult.SmsServiceProviderId
+		}
+		result.merge(groupResult, req.Mobiles, group.Mobiles)
+	}
+	if len(result.FailedMobiles) < len(req.Mobiles) {
+		return result, 0, nil
+	}
+	return
+}
+
+// 按服务商优先级发送同一组路由规则的短信，可重试错误时切换服务商，并增加短信发送记录
+// 部分手机号发送失败时，只有失败的手机号切换服务商，已发送成功的手机号不再重复发送
+func routeSmsGroup(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	var (
+		providerReq    *SmsRequest
+		providerResult *SmsResult
+		pending        *SmsRequest = req // 待发送的手机号
+	)
+	providers := GetRoutedSmsProviders(req)
+	if len(providers) <= 0 {
+		err = errors.New("sms service is unabled.")
+		retcode = utils.SMS_SERVICE_253_CHUANGLAN_UNABLED
+		return
+	}
+	for index, provider := range providers {
+		renderReq, code, renderErr := renderSmsRequest(provider, pending)
+		if renderErr != nil {
+			// 该服务商缺少对应模板，切换下一个服务商
+			Logger.Error("[%v] %s", provider.GetSmsServiceProviderId(), renderErr.Error())
+			if providerReq == nil {
+				retcode, err = code, renderErr
+			}
+			continue
+		}
+		// 服务商熔断中，切换下一个服务商
+		if !AllowSmsProvider(provider.GetSmsServiceProviderId()) {
+			Logger.Warn("[%v] sms provider circuit open, skipped.", provider.GetSmsServiceProviderId())
+			if providerReq == nil {
+				err = errors.New("all sms service providers circuit open")
+				retcode = SMS_PROVIDER_CIRCUIT_OPEN
+			}
+			continue
+		}
+		providerReq = renderReq
+		startAt := time.Now()
+		providerResult, retcode, err = dispatchSms(provider, providerReq)
+		if err != nil && provider.IsRetryableError(retcode) {
+			RecordSmsProviderResult(provider.GetSmsServiceProviderId(), time.Since(startAt), err)
+		} else {
+			RecordSmsProviderResult(provider.GetSmsServiceProviderId(), time.Since(startAt), nil)
+		}
+		if err != nil {
+			Logger.Error("[%v] sms send failed: %s", provider.GetSmsServiceProviderId(), err.Error())
+			if !provider.IsRetryableError(retcode) || index == len(providers)-1 {
+				break
+			}
+			continue
+		}
+		recordSmsSent(pending, providerReq, providerResult)
+		if result == nil {
+			result = providerResult
+		} else {
+			result.merge(providerResult, req.Mobiles, pending.Mobiles)
+		}
+		// 只有可重试错误的手机号切换下一个服务商
+		retryMobiles := []string{}
+		for _, mobile := range providerResult.GetFailedMobiles(pending.Mobiles) {
+			if provider.IsRetryableError(providerResult.FailedMobiles[mobile]) {
+				retryMobiles = append(retryMobiles, mobile)
+			}
+		}
+		if len(retryMobiles) <= 0 || index == len(providers)-1 {
+			break
+		}
+		pending = subSmsRequest(pending, retryMobiles)
+	}
+	if providerReq == nil {
+		return
+	}
+	if err != nil {
+		// 最后一次发送失败，增加失败的短信发送记录
+		if _, insertErr := insertSmsSendRecord(providerReq, providerResult, retcode); insertErr != nil {
+			Logger.Error(insertErr.Error())
+		}
+		if result == nil {
+			result = providerResult
+			return
+		}
+		// 部分手机号已发送成功，其余手机号记为发送失败
+		for _, mobile := range pending.Mobiles {
+			result.FailedMobiles[mobile] = retcode
+		}
+	}
+	return result, 0, nil
+}
+
+// 服务商接收成功，记录发送成功的接收人送达状态和短信发送记录
+func recordSmsSent(req *SmsRequest, providerReq *SmsRequest, result *SmsResult) {
+	if _, deliveryErr := recordSmsDeliveriesSubmitted(req, result); deliveryErr != nil {
+		Logger.Error(deliveryErr.Error())
+	}
+	if _, insertErr := insertSmsSendRecord(providerReq, result, 0); insertErr != nil {
+		Logger.Error(insertErr.Error())
+	}
+	return
+}
+
+// 只包含指定手机号的短信请求，批量发送不同内容时保留对应的短信内容
+func subSmsRequest(req *SmsRequest, mobiles []string) (subReq *SmsRequest) {
+	var (
+		selected map[string]bool = map[string]bool{}
+	)
+	for _, mobile := range mobiles {
+		selected[mobile] = true
+	}
+	subReq = new(SmsRequest)
+	*subReq = *req
+	subReq.Mobiles = []string{}
+	if len(req.Contents) > 0 {
+		subReq.Contents = []string{}
+	}
+	for index, mobile := range req.Mobiles {
+		if !selected[mobile] {
+			continue
+		}
+		subReq.Mobiles = append(subReq.Mobiles, mobile)
+		if len(req.Contents) > index {
+			subReq.Contents = append(subReq.Contents, req.Contents[index])
+		}
+	}
+	return
+}
@@ -0,0 +1,351 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信路由规则
+	>> 按短信类型(验证码/营销)、目的号码前缀选择服务商，号码前缀匹配时统一为国家码开头，如：86138、852
+	>> 多条规则同时匹配时，取号码前缀最长的规则集合；其中单价最低的规则按权重随机选出首选服务商，
+	   其余规则按单价升序、权重降序作为失败切换的备选服务商
+	>> 规则修改后立即重新加载，无需重新部署
+*/
+
+const (
+	// 错误码
+	SMS_ROUTING_RULE_FORBIDDEN = 12046 // 非平台管理员公司不能查询和修改路由规则
+)
+
+var (
+	SMS_ROUTING_ALL_TYPE = 0 // 路由规则适用于所有短信类型
+
+	SMS_ROUTING_RULE_RELOAD_INTERVAL = time.Minute // 路由规则重新加载周期
+
+	smsRoutingRules       []SmsRoutingRules
+	smsRoutingRulesLoadAt time.Time
+	smsRoutingRulesMutex  sync.RWMutex
+	smsRoutingRulesRandom = rand.New(rand.NewSource(time.Now().UnixNano()))
+	smsRoutingRandomMutex sync.Mutex
+	smsRoutingRulesLoaded bool
+)
+
+type SmsRoutingRules struct {
+	Id                   int       `orm:"column(sms_routing_rule_id);auto"`
+	AccountType          int16     `orm:"column(account_type);null"`
+	MobilePrefix         string    `orm:"column(mobile_prefix);size(20);null"`
+	SmsServiceProviderId int       `orm:"column(sms_service_provider_id);null"`
+	Weight               int       `orm:"column(weight);null"`
+	UnitCost             int       `orm:"column(unit_cost);null"`
+	Status               int16     `orm:"column(status);null"`
+	UpdatedAt            time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt            time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsRoutingRules) TableName() string {
+	return "sms_routing_rules"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsRoutingRules))
+}
+
+func (t *SmsRoutingRules) ReadSmsRoutingRuleNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter ReadSmsRoutingRuleNoLock.", t.Id)
+	defer Logger.Info("[%v] left ReadSmsRoutingRuleNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if err = (*o).Read(t); err != nil {
+		err = errors.Wrap(err, "ReadSmsRoutingRuleNoLock")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+func (t *SmsRoutingRules) InsertSmsRoutingRuleNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter InsertSmsRoutingRuleNoLock.", t.AccountType, t.MobilePrefix)
+	defer Logger.Info("[%v.%v] left InsertSmsRoutingRuleNoLock.", t.AccountType, t.MobilePrefix)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Insert(t); err != nil {
+		err = errors.Wrap(err, "InsertSmsRoutingRuleNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	expireSmsRoutingRules()
+	return
+}
+
+func (t *SmsRoutingRules) UpdateSmsRoutingRuleNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter UpdateSmsRoutingRuleNoLock.", t.Id)
+	defer Logger.Info("[%v] left UpdateSmsRoutingRuleNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Update(t); err != nil {
+		err = errors.Wrap(err, "UpdateSmsRoutingRuleNoLock")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	expireSmsRoutingRules()
+	return
+}
+
+// 路由规则修改后，下次路由时重新加载
+func expireSmsRoutingRules() {
+	smsRoutingRulesMutex.Lock()
+	smsRoutingRulesLoaded = false
+	smsRoutingRulesMutex.Unlock()
+}
+
+// 加载所有有效的路由规则
+func loadSmsRoutingRulesIfExpired() {
+	smsRoutingRulesMutex.RLock()
+	expired := !smsRoutingRulesLoaded || time.Since(smsRoutingRulesLoadAt) > SMS_ROUTING_RULE_RELOAD_INTERVAL
+	smsRoutingRulesMutex.RUnlock()
+	if !expired {
+		return
+	}
+	var (
+		rules []SmsRoutingRules = []SmsRoutingRules{}
+	)
+	o := orm.NewOrm()
+	if _, err := o.QueryTable((&SmsRoutingRules{}).TableName()).Filter("status", utils.STATUS_VALID).All(&rules); err != nil {
+		Logger.Error(errors.Wrap(err, "loadSmsRoutingRulesIfExpired").Error())
+		return
+	}
+	smsRoutingRulesMutex.Lock()
+	smsRoutingRules = rules
+	smsRoutingRulesLoadAt = time.Now()
+	smsRoutingRulesLoaded = true
+	smsRoutingRulesMutex.Unlock()
+}
+
+// 号码统一为国家码开头，用于号码前缀匹配：+86 138xxxx => 86138xxxx, 138xxxx => 86138xxxx
+func getRoutingMobile(mobile string) string {
+	mobile = strings.Replace(strings.TrimSpace(mobile), " ", "", -1)
+	mobile = strings.TrimPrefix(strings.TrimPrefix(mobile, "+"), "00")
+	if len(mobile) == 11 && strings.HasPrefix(mobile, "1") {
+		mobile = "86" + mobile
+	}
+	return mobile
+}
+
+// 匹配短信类型和号码前缀的路由规则，只保留号码前缀最长的规则
+func matchSmsRoutingRules(accountType int, mobile string) (rules []SmsRoutingRules) {
+	var (
+		prefixLen int = -1
+	)
+	loadSmsRoutingRulesIfExpired()
+	mobile = getRoutingMobile(mobile)
+	smsRoutingRulesMutex.RLock()
+	defer smsRoutingRulesMutex.RUnlock()
+	for index := 0; index < len(smsRoutingRules); index++ {
+		rule := smsRoutingRules[index]
+		if int(rule.AccountType) != SMS_ROUTING_ALL_TYPE && int(rule.AccountType) != accountType {
+			continue
+		}
+		if !strings.HasPrefix(mobile, rule.MobilePrefix) {
+			continue
+		}
+		if len(rule.MobilePrefix) > prefixLen {
+			prefixLen = len(rule.MobilePrefix)
+			rules = []SmsRoutingRules{}
+		}
+		if len(rule.MobilePrefix) == prefixLen {
+			rules = append(rules, rule)
+		}
+	}
+	return
+}
+
+// 路由规则排序：单价最低的规则中按权重随机选出首选，其余按单价升序、权重降序
+func sortSmsRoutingRules(rules []SmsRoutingRules) []SmsRoutingRules {
+	var (
+		totalWeight, cheapest int
+		candidates            []int
+	)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].UnitCost != rules[j].UnitCost {
+			return rules[i].UnitCost < rules[j].UnitCost
+		}
+		return rules[i].Weight > rules[j].Weight
+	})
+	if len(rules) <= 1 {
+		return rules
+	}
+	cheapest = rules[0].UnitCost
+	for index := 0; index < len(rules) && rules[index].UnitCost == cheapest; index++ {
+		if rules[index].Weight > 0 {
+			candidates = append(candidates, index)
+			totalWeight += rules[index].Weight
+		}
+	}
+	if totalWeight <= 0 {
+		return rules
+	}
+	smsRoutingRandomMutex.Lock()
+	hit := smsRoutingRulesRandom.Intn(totalWeight)
+	smsRoutingRandomMutex.Unlock()
+	for _, index := range candidates {
+		if hit < rules[index].Weight {
+			// 选中的规则移到首位，其余规则保持原有顺序
+			chosen := rules[index]
+			copy(rules[1:index+1], rules[:index])
+			rules[0] = chosen
+			break
+		}
+		hit -= rules[index].Weight
+	}
+	return rules
+}
+
+// 按匹配的路由规则对手机号分组，匹配相同规则的手机号为一组，只有一组时返回原请求
+func groupSmsRequestByRoutingRules(req *SmsRequest) (groups []*SmsRequest) {
+	var (
+		keys    []string
+		mobiles map[string][]string = map[string][]string{}
+	)
+	for _, mobile := range req.Mobiles {
+		ids := []string{}
+		for _, rule := range matchSmsRoutingRules(req.AccountType, mobile) {
+			ids = append(ids, fmt.Sprintf("%d", rule.Id))
+		}
+		sort.Strings(ids)
+		key := strings.Join(ids, ",")
+		if _, exist := mobiles[key]; !exist {
+			keys = append(keys, key)
+		}
+		mobiles[key] = append(mobiles[key], mobile)
+	}
+	if len(keys) <= 1 {
+		return []*SmsRequest{req}
+	}
+	for _, key := range keys {
+		groups = append(groups, subSmsRequest(req, mobiles[key]))
+	}
+	return
+}
+
+// 按路由规则获取服务商列表，未被规则选中的已启用服务商按配置优先级排在最后
+// 按第一个手机号匹配路由规则，批量发送前先按groupSmsRequestByRoutingRules分组
+func GetRoutedSmsProviders(req *SmsRequest) (providers []ISMS) {
+	var (
+		added map[int]bool = map[int]bool{}
+	)
+	if req != nil && len(req.Mobiles) > 0 {
+		rules := sortSmsRoutingRules(matchSmsRoutingRules(req.AccountType, req.Mobiles[0]))
+		for index := 0; index < len(rules); index++ {
+			provider := GetSmsProviderById(rules[index].SmsServiceProviderId)
+			if provider != nil && !added[provider.GetSmsServiceProviderId()] {
+				providers = append(providers, provider)
+				added[provider.GetSmsServiceProviderId()] = true
+			}
+		}
+	}
+	for _, provider := range GetPrioritizedSmsProviders() {
+		if !added[provider.GetSmsServiceProviderId()] {
+			providers = append(providers, provider)
+			added[provider.GetSmsServiceProviderId()] = true
+		}
+	}
+	return
+}
+
+// GetAllSmsRoutingRules retrieves all SmsRoutingRules matches certain condition. Returns empty list if
+// no records exist
+func GetAllSmsRoutingRules(query map[string]string, fields []string, sortby []string, order []string,
+	offset int64, limit int64) (ml []interface{}, err error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable(new(SmsRoutingRules))
+	// query k=v
+	for k, v := range query {
+		// rewrite dot-notation to Object__Attribute
+		k = strings.Replace(k, ".", "__", -1)
+		if strings.Contains(k, "isnull") {
+			qs = qs.Filter(k, (v == "true" || v == "1"))
+		} else {
+			qs = qs.Filter(k, v)
+		}
+	}
+	// order by:
+	var sortFields []string
+	if len(sortby) != 0 {
+		if len(sortby) == len(order) {
+			// 1) for each sort field, there is an associated order
+			for i, v := range sortby {
+				orderby := ""
+				if order[i] == "desc" {
+					orderby = "-" + v
+				} else if order[i] == "asc" {
+					orderby = v
+				} else {
+					return nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+			qs = qs.OrderBy(sortFields...)
+		} else if len(sortby) != len(order) && len(order) == 1 {
+			// 2) there is exactly one order, all the sorted fields will be sorted by this order
+			for _, v := range sortby {
+				orderby := ""
+				if order[0] == "desc" {
+					orderby = "-" + v
+				} else if order[0] == "asc" {
+					orderby = v
+				} else {
+					return nil, errors.New("Error: Invalid order. Must be either [asc|desc]")
+				}
+				sortFields = append(sortFields, orderby)
+			}
+		} else if len(sortby) != len(order) && len(order) != 1 {
+			return nil, errors.New("Error: 'sortby', 'order' sizes mismatch or 'order' size is not 1")
+		}
+	} else {
+		if len(order) != 0 {
+			return nil, errors.New("Error: unused 'order' fields")
+		}
+	}
+
+	var l []SmsRoutingRules
+	qs = qs.OrderBy(sortFields...)
+	if _, err = qs.Limit(limit, offset).All(&l, fields...); err == nil {
+		if len(fields) == 0 {
+			for _, v := range l {
+				ml = append(ml, v)
+			}
+		} else {
+			// trim unused fields
+			for _, v := range l {
+				m := make(map[string]interface{})
+				val := reflect.ValueOf(v)
+				for _, fname := range fields {
+					m[fname] = val.FieldByName(fname).Interface()
+				}
+				ml = append(ml, m)
+			}
+		}
+		return ml, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	utils "github.com/1046102779/common"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信计费条数计算
+	>> 各服务商的计费规则保存在sms_service_providers表：单条最大长度、长短信每条长度、编码方式、签名是否计入长度、单价
+	>> 编码方式：
+		ucs2: 国内服务商按字计费，中英文、标点均为1个字(emoji等非BMP字符为2个字)，默认70字一条，超出后按67字一条
+		auto: 内容均为GSM-7字符时按7位编码计算，160字一条，超出后按153字一条(扩展字符占2位)；否则按ucs2计算
+	>> 签名计入长度时按【签名】+内容计算，长短信拆分后每条均附带拆分头，所以超出单条长度后每条可用长度减少
+*/
+
+const (
+	// 编码方式
+	SMS_ENCODING_GSM7 = "gsm7"
+	SMS_ENCODING_UCS2 = "ucs2"
+	SMS_ENCODING_AUTO = "auto"
+
+	// 默认计费规则
+	SMS_UCS2_SINGLE_LENGTH = 70
+	SMS_UCS2_MULTI_LENGTH  = 67
+	SMS_GSM7_SINGLE_LENGTH = 160
+	SMS_GSM7_MULTI_LENGTH  = 153
+
+	SMS_ESTIMATE_MAX_MOBILE_COUNT = 10000000 // 预估短信时手机号个数上限
+)
+
+var (
+	// 签名是否计入短信长度：10: 不计入；20: 计入，默认计入
+	SMS_SIGN_NOT_COUNTED = 10
+	SMS_SIGN_COUNTED     = 20
+
+	// GSM-7基本字符集
+	smsGsm7BasicChars = map[rune]bool{}
+	// GSM-7扩展字符集，每个字符占2位
+	smsGsm7ExtendedChars = map[rune]bool{}
+)
+
+func init() {
+	for _, char := range "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà" {
+		smsGsm7BasicChars[char] = true
+	}
+	for _, char := range "\f^{}\\[~]|€" {
+		smsGsm7ExtendedChars[char] = true
+	}
+}
+
+// 服务商短信计费规则
+type SmsSegmentRule struct {
+	Encoding     string  // 编码方式：ucs2或者auto
+	SingleLength int     // ucs2编码单条短信最大长度
+	MultiLength  int     // ucs2编码长短信每条长度
+	SignCounted  bool    // 签名是否计入短信长度
+	UnitPrice    float64 // 每条短信单价，单位：元
+}
+
+// 短信内容的计费结果
+type SmsSegments struct {
+	Encoding string `json:"encoding"` // 实际采用的编码方式：gsm7或者ucs2
+	Length   int    `json:"length"`   // 计费长度
+	Segments int    `json:"segments"` // 计费条数
+}
+
+// 根据sms_service_providers表记录生成计费规则，未配置的项采用默认值
+func newSmsSegmentRule(provider *SmsServiceProviders) (rule *SmsSegmentRule) {
+	rule = &SmsSegmentRule{
+		Encoding:     SMS_ENCODING_UCS2,
+		SingleLength: SMS_UCS2_SINGLE_LENGTH,
+		MultiLength:  SMS_UCS2_MULTI_LENGTH,
+		SignCounted:  true,
+	}
+	if provider == nil {
+		return
+	}
+	if strings.TrimSpace(provider.SegmentEncoding) == SMS_ENCODING_AUTO {
+		rule.Encoding = SMS_ENCODING_AUTO
+	}
+	if provider.SingleSmsMaxLength > 0 {
+		rule.SingleLength = provider.SingleSmsMaxLength
+	}
+	if provider.MultiSmsPartLength > 0 {
+		rule.MultiLength = provider.MultiSmsPartLength
+	}
+	if rule.MultiLength > rule.SingleLength {
+		rule.MultiLength = rule.SingleLength
+	}
+	rule.SignCounted = int(provider.SignCounted) != SMS_SIGN_NOT_COUNTED
+	rule.UnitPrice = provider.UnitPrice
+	return
+}
+
+// GSM-7编码长度，含非GSM-7字符时返回false
+func getSmsGsm7Length(content string) (length int, ok bool) {
+	for _, char := range content {
+		switch {
+		case smsGsm7BasicChars[char]:
+			length += 1
+		case smsGsm7ExtendedChars[char]:
+			length += 2
+		default:
+			return 0, false
+		}
+	}
+	return length, true
+}
+
+// 按条数计算：不超过单条长度为1条，否则按长短信每条长度拆分
+func countSmsSegments(length int, singleLength int, multiLength int) int {
+	if length <= 0 {
+		return 0
+	}
+	if length <= singleLength || multiLength <= 0 {
+		return 1
+	}
+	return (length + multiLength - 1) / multiLength
+}
+
+// 计算短信内容(含签名)的计费条数
+func (t *SmsSegmentRule) Count(content string) (segments SmsSegments) {
+	if !t.SignCounted && strings.HasPrefix(content, "【") {
+		if end := strings.Index(content, "】"); end > 0 {
+			content = content[end+len("】"):]
+		}
+	}
+	if t.Encoding == SMS_ENCODING_AUTO {
+		if length, ok := getSmsGsm7Length(content); ok {
+			segments.Encoding = SMS_ENCODING_GSM7
+			segments.Length = length
+			segments.Segments = countSmsSegments(length, SMS_GSM7_SINGLE_LENGTH, SMS_GSM7_MULTI_LENGTH)
+			return
+		}
+	}
+	segments.Encoding = SMS_ENCODING_UCS2
+	segments.Length = len(utf16.Encode([]rune(content)))
+	segments.Segments = countSmsSegments(segments.Length, t.SingleLength, t.MultiLength)
+	return
+}
+
+// 短信费用，单位：元
+func (t *SmsSegmentRule) Cost(count int) float64 {
+	return float64(count) * t.UnitPrice
+}
+
+// 服务商发送短信的预估结果
+type SmsEstimate struct {
+	SmsServiceProviderId int     `json:"sms_service_provider_id"`
+	SmsSegments                  // 单条短信内容的计费结果，批量发送不同内容时为最大值
+	MobileCount          int     `json:"mobile_count"` // 接收短信的手机号个数
+	Count                int     `json:"count"`        // 短信使用条数
+	Cost                 float64 `json:"cost"`         // 短信费用，单位：元
+}
+
+// 按服务商优先级预估短信在每个服务商的计费条数和费用；缺少对应模板的服务商不返回
+// mobileCount为接收短信的手机号个数，小于等于0时按req.Mobiles个数计算；批量发送不同内容时按req.Contents计算
+func EstimateSms(req *SmsRequest, mobileCount int) (estimates []SmsEstimate, retcode int, err error) {
+	estimates = []SmsEstimate{}
+	if mobileCount <= 0 {
+		mobileCount = len(req.Mobiles)
+	}
+	if mobileCount > SMS_ESTIMATE_MAX_MOBILE_COUNT {
+		err = errors.Errorf("param `mobile_count` beyond max %d", SMS_ESTIMATE_MAX_MOBILE_COUNT)
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	for _, provider := range GetRoutedSmsProviders(req) {
+		providerReq, code, renderErr := renderSmsRequest(provider, req)
+		if renderErr != nil {
+			if len(estimates) <= 0 {
+				retcode, err = code, renderErr
+			}
+			continue
+		}
+		estimate := SmsEstimate{
+			SmsServiceProviderId: provider.GetSmsServiceProviderId(),
+			MobileCount:          mobileCount,
+		}
+		rule := provider.GetSegmentRule()
+		if len(providerReq.Contents) > 0 {
+			for _, content := range providerReq.Contents {
+				segments := rule.Count(content)
+				estimate.Count += segments.Segments
+				if segments.Segments > estimate.Segments {
+					estimate.SmsSegments = segments
+				}
+			}
+		} else {
+			estimate.SmsSegments = rule.Count(providerReq.Content)
+			estimate.Count = estimate.Segments * estimate.MobileCount
+		}
+		estimate.Cost = rule.Cost(estimate.Count)
+		estimates = append(estimates, estimate)
+	}
+	if len(estimates) > 0 {
+		retcode, err = 0, nil
+	}
+	return
+}
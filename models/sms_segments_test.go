@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSmsSegmentRuleCount(t *testing.T) {
+	ucs2 := newSmsSegmentRule(nil)
+	auto := newSmsSegmentRule(&SmsServiceProviders{
+		SegmentEncoding: SMS_ENCODING_AUTO,
+	})
+	unsigned := newSmsSegmentRule(&SmsServiceProviders{
+		SignCounted: int16(SMS_SIGN_NOT_COUNTED),
+	})
+	cases := []struct {
+		name     string
+		rule     *SmsSegmentRule
+		content  string
+		expected SmsSegments
+	}{
+		{"空内容", ucs2, "", SmsSegments{SMS_ENCODING_UCS2, 0, 0}},
+		{"单条", ucs2, "【创蓝】您的验证码是1234", SmsSegments{SMS_ENCODING_UCS2, 14, 1}},
+		{"单条最大长度", ucs2, strings.Repeat("字", 70), SmsSegments{SMS_ENCODING_UCS2, 70, 1}},
+		{"长短信", ucs2, strings.Repeat("字", 71), SmsSegments{SMS_ENCODING_UCS2, 71, 2}},
+		{"长短信整数条", ucs2, strings.Repeat("字", 134), SmsSegments{SMS_ENCODING_UCS2, 134, 2}},
+		{"长短信进位", ucs2, strings.Repeat("字", 135), SmsSegments{SMS_ENCODING_UCS2, 135, 3}},
+		{"emoji占2个字", ucs2, "😀", SmsSegments{SMS_ENCODING_UCS2, 2, 1}},
+		{"ucs2不按GSM-7计算", ucs2, strings.Repeat("a", 100), SmsSegments{SMS_ENCODING_UCS2, 100, 2}},
+		{"GSM-7单条", auto, strings.Repeat("a", 160), SmsSegments{SMS_ENCODING_GSM7, 160, 1}},
+		{"GSM-7长短信", auto, strings.Repeat("a", 161), SmsSegments{SMS_ENCODING_GSM7, 161, 2}},
+		{"GSM-7扩展字符占2位", auto, strings.Repeat("a", 158) + "€", SmsSegments{SMS_ENCODING_GSM7, 160, 1}},
+		{"含中文按ucs2计算", auto, strings.Repeat("a", 69) + "字", SmsSegments{SMS_ENCODING_UCS2, 70, 1}},
+		{"签名不计入长度", unsigned, "【创蓝】" + strings.Repeat("字", 70), SmsSegments{SMS_ENCODING_UCS2, 70, 1}},
+		{"签名计入长度", ucs2, "【创蓝】" + strings.Repeat("字", 70), SmsSegments{SMS_ENCODING_UCS2, 74, 2}},
+	}
+	for _, c := range cases {
+		if segments := c.rule.Count(c.content); segments != c.expected {
+			t.Errorf("%s: Count(%q) = %+v, expected %+v", c.name, c.content, segments, c.expected)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+// 发件箱短信每次发送的结果记录
+type SmsSendAttempts struct {
+	Id                   int       `orm:"column(sms_send_attempt_id);auto"`
+	SmsOutboxMessageId   int       `orm:"column(sms_outbox_message_id);null"`
+	Attempt              int       `orm:"column(attempt);null"`
+	SmsServiceProviderId int       `orm:"column(sms_service_provider_id);null"`
+	Retcode              int       `orm:"column(retcode);null"`
+	ErrMsg               string    `orm:"column(err_msg);size(500);null"`
+	Retryable            bool      `orm:"column(retryable);null"`
+	NextRetryAt          time.Time `orm:"column(next_retry_at);type(datetime);null"`
+	CreatedAt            time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsSendAttempts) TableName() string {
+	return "sms_send_attempts"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsSendAttempts))
+}
+
+func (t *SmsSendAttempts) InsertSmsSendAttemptNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter InsertSmsSendAttemptNoLock.", t.SmsOutboxMessageId, t.Attempt)
+	defer Logger.Info("[%v.%v] left InsertSmsSendAttemptNoLock.", t.SmsOutboxMessageId, t.Attempt)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Insert(t); err != nil {
+		err = errors.Wrap(err, "InsertSmsSendAttemptNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+// 获取发件箱短信的发送历史，按发送次数升序
+func GetSmsSendAttempts(outboxId int) (attempts []SmsSendAttempts, retcode int, err error) {
+	Logger.Info("[%v] enter GetSmsSendAttempts.", outboxId)
+	defer Logger.Info("[%v] left GetSmsSendAttempts.", outboxId)
+	attempts = []SmsSendAttempts{}
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsSendAttempts{}).TableName()).Filter("sms_outbox_message_id", outboxId).OrderBy("attempt", "id").All(&attempts); err != nil {
+		err = errors.Wrap(err, "GetSmsSendAttempts")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信发送时间窗口(免打扰)
+	>> 按公司和短信类型配置每天允许发送的时间段，如：08:00-21:00，时区为空时采用db::time_loc
+	>> 公司未配置时采用平台配置(company_id=0)，平台也未配置时营销短信采用sms::marketing_send_window
+	>> 不在发送时间窗口内的短信推迟到下一个允许发送的时间，不直接发送也不拒绝
+	>> 验证码短信不受发送时间窗口限制
+	>> 开始时间大于结束时间表示跨天，如：22:00-06:00
+*/
+
+var (
+	SMS_SEND_WINDOW_PLATFORM = 0       // 平台默认发送时间窗口的company_id
+	SMS_SEND_WINDOW_LAYOUT   = "15:04" // 发送时间窗口时间格式
+)
+
+type SmsSendPeriods struct {
+	Id          int       `orm:"column(sms_send_period_id);auto"`
+	CompanyId   int       `orm:"column(company_id);null"`
+	AccountType int16     `orm:"column(account_type);null"`
+	StartTime   string    `orm:"column(start_time);size(5);null"`
+	EndTime     string    `orm:"column(end_time);size(5);null"`
+	TimeLoc     string    `orm:"column(time_loc);size(50);null"`
+	Status      int16     `orm:"column(status);null"`
+	UpdatedAt   time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt   time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsSendPeriods) TableName() string {
+	return "sms_send_periods"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsSendPeriods))
+}
+
+func (t *SmsSendPeriods) ReadSmsSendPeriodNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter ReadSmsSendPeriodNoLock.", t.Id)
+	defer Logger.Info("[%v] left ReadSmsSendPeriodNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if err = (*o).Read(t); err != nil {
+		err = errors.Wrap(err, "ReadSmsSendPeriodNoLock")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+func (t *SmsSendPeriods) InsertSmsSendPeriodNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter InsertSmsSendPeriodNoLock.", t.CompanyId, t.AccountType)
+	defer Logger.Info("[%v.%v] left InsertSmsSendPeriodNoLock.", t.CompanyId, t.AccountType)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Insert(t); err != nil {
+		err = errors.Wrap(err, "InsertSmsSendPeriodNoLock")
+		retcode = utils.DB_INSERT_ERROR
+		return
+	}
+	return
+}
+
+func (t *SmsSendPeriods) UpdateSmsSendPeriodNoLock(o *orm.Ormer) (retcode int, err error) {
+	Logger.Info("[%v] enter UpdateSmsSendPeriodNoLock.", t.Id)
+	defer Logger.Info("[%v] left UpdateSmsSendPeriodNoLock.", t.Id)
+	if o == nil {
+		err = errors.New("param `orm.Ormer` ptr empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if _, err = (*o).Update(t); err != nil {
+		err = errors.Wrap(err, "UpdateSmsSendPeriodNoLock")
+		retcode = utils.DB_UPDATE_ERROR
+		return
+	}
+	return
+}
+
+// 校验发送时间窗口的开始、结束时间和时区
+func (t *SmsSendPeriods) Check() (err error) {
+	t.StartTime = strings.TrimSpace(t.StartTime)
+	t.EndTime = strings.TrimSpace(t.EndTime)
+	t.TimeLoc = strings.TrimSpace(t.TimeLoc)
+	if _, err = time.Parse(SMS_SEND_WINDOW_LAYOUT, t.StartTime); err != nil {
+		return errors.New("param `start_time` is illegal!")
+	}
+	if _, err = time.Parse(SMS_SEND_WINDOW_LAYOUT, t.EndTime); err != nil {
+		return errors.New("param `end_time` is illegal!")
+	}
+	if t.StartTime == t.EndTime {
+		return errors.New("param `start_time` equal to `end_time`")
+	}
+	if _, err = t.location(); err != nil {
+		return errors.New("param `time_loc` is illegal!")
+	}
+	return
+}
+
+func (t *SmsSendPeriods) location() (*time.Location, error) {
+	if t.TimeLoc == "" {
+		return time.LoadLocation(conf.DBTimeLoc)
+	}
+	return time.LoadLocation(t.TimeLoc)
+}
+
+// 一天中的分钟数, value格式：15:04
+func getSmsWindowMinutes(value string) int {
+	clock, _ := time.Parse(SMS_SEND_WINDOW_LAYOUT, value)
+	return clock.Hour()*60 + clock.Minute()
+}
+
+// 获取at之后(含at)最早允许发送的时间
+func (t *SmsSendPeriods) NextSendTime(at time.Time) time.Time {
+	loc, err := t.location()
+	if err != nil {
+		Logger.Error(errors.Wrap(err, "NextSendTime").Error())
+		return at
+	}
+	local := at.In(loc)
+	minutes := local.Hour()*60 + local.Minute()
+	start, end := getSmsWindowMinutes(t.StartTime), getSmsWindowMinutes(t.EndTime)
+	if start < end && minutes >= start && minutes < end {
+		return at
+	}
+	if start > end && (minutes >= start || minutes < end) {
+		return at
+	}
+	next := time.Date(local.Year(), local.Month(), local.Day(), start/60, start%60, 0, 0, loc)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// 获取公司短信类型的发送时间窗口：公司配置 > 平台配置 > 营销短信默认配置，都没有返回nil
+func GetSmsSendPeriod(companyId int, accountType int) (window *SmsSendPeriods, retcode int, err error) {
+	var (
+		windows []SmsSendPeriods = []SmsSendPeriods{}
+	)
+	o := orm.NewOrm()
+	_, err = o.QueryTable((&SmsSendPeriods{}).TableName()).Filter("company_id__in", companyId, SMS_SEND_WINDOW_PLATFORM).Filter("account_type", accountType).Filter("status", utils.STATUS_VALID).All(&windows)
+	if err != nil {
+		err = errors.Wrap(err, "GetSmsSendPeriod")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	for index := 0; index < len(windows); index++ {
+		if windows[index].CompanyId == companyId {
+			return &windows[index], 0, nil
+		}
+		window = &windows[index]
+	}
+	if window == nil && accountType == SMS_CHUANGLAN_MARKETING_TYPE && conf.SmsMarketingSendWindow != "" {
+		fields := strings.Split(conf.SmsMarketingSendWindow, "-")
+		if len(fields) != 2 {
+			err = errors.New(fmt.Sprintf("app parameter `sms::marketing_send_window` illegal: %s", conf.SmsMarketingSendWindow))
+			retcode = utils.SOURCE_DATA_ILLEGAL
+			return
+		}
+		window = &SmsSendPeriods{
+			CompanyId:   SMS_SEND_WINDOW_PLATFORM,
+			AccountType: int16(accountType),
+			StartTime:   fields[0],
+			EndTime:     fields[1],
+		}
+		if err = window.Check(); err != nil {
+			err = errors.Wrap(err, "GetSmsSendPeriod")
+			retcode = utils.SOURCE_DATA_ILLEGAL
+			window = nil
+			return
+		}
+	}
+	return
+}
+
+// 按发送时间窗口调整发送时间，验证码短信不受限制；读取配置失败时不推迟
+func GetAllowedSmsSendTime(companyId int, accountType int, at time.Time) time.Time {
+	if accountType == SMS_CHUANGLAN_VERIFICATION_TYPE {
+		return at
+	}
+	window, _, err := GetSmsSendPeriod(companyId, accountType)
+	if err != nil {
+		Logger.Error(err.Error())
+		return at
+	}
+	if window == nil {
+		return at
+	}
+	return window.NextSendTime(at)
+}
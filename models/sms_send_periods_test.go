@@ -0,0 +1,46 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSmsSendPeriodsNextSendTime(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2017, 6, day, hour, minute, 0, 0, loc)
+	}
+	cases := []struct {
+		name      string
+		startTime string
+		endTime   string
+		at        time.Time
+		expected  time.Time
+	}{
+		{"窗口内", "08:00", "21:00", at(1, 12, 0), at(1, 12, 0)},
+		{"开始时间", "08:00", "21:00", at(1, 8, 0), at(1, 8, 0)},
+		{"开始之前", "08:00", "21:00", at(1, 7, 59), at(1, 8, 0)},
+		{"结束时间", "08:00", "21:00", at(1, 21, 0), at(2, 8, 0)},
+		{"结束之后", "08:00", "21:00", at(1, 23, 30), at(2, 8, 0)},
+		{"跨天窗口当天", "22:00", "06:00", at(1, 23, 0), at(1, 23, 0)},
+		{"跨天窗口次日", "22:00", "06:00", at(2, 3, 0), at(2, 3, 0)},
+		{"跨天窗口结束时间", "22:00", "06:00", at(2, 6, 0), at(2, 22, 0)},
+		{"跨天窗口之外", "22:00", "06:00", at(1, 12, 0), at(1, 22, 0)},
+		{"跨天窗口开始时间", "22:00", "06:00", at(1, 22, 0), at(1, 22, 0)},
+		{"跨月", "08:00", "21:00", at(30, 22, 0), time.Date(2017, 7, 1, 8, 0, 0, 0, loc)},
+		{"其他时区", "08:00", "21:00", at(1, 12, 0).UTC(), at(1, 12, 0)},
+	}
+	for _, c := range cases {
+		period := &SmsSendPeriods{
+			StartTime: c.startTime,
+			EndTime:   c.endTime,
+			TimeLoc:   "Asia/Shanghai",
+		}
+		if next := period.NextSendTime(c.at); !next.Equal(c.expected) {
+			t.Errorf("%s: NextSendTime(%v) %s-%s = %v, expected %v", c.name, c.at, c.startTime, c.endTime, next, c.expected)
+		}
+	}
+}
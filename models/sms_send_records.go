@@ -12,16 +12,17 @@ import (
 )
 
 type SmsSendRecords struct {
-	Id              int       `orm:"column(sms_send_record_id);auto"`
-	SmsTemplateId   int       `orm:"column(sms_template_id);null"`
-	CompanyId       int       `orm:"column(company_id);null"`
-	Content         string    `orm:"column(content);size(1000);null"`
-	ReceiverMobiles string    `orm:"column(receiver_mobiles);size(2000);null"`
-	SendStatus      string    `orm:"column(send_status);size(20);null"`
-	Count           int       `orm:"column(count);null"`
-	CountPerContent int16     `orm:"column(count_per_content);null"`
-	MessageId       string    `orm:"column(message_id);size(100);null"`
-	SendAt          time.Time `orm:"column(send_at);type(datetime);null"`
+	Id                   int       `orm:"column(sms_send_record_id);auto"`
+	SmsTemplateId        int       `orm:"column(sms_template_id);null"`
+	SmsServiceProviderId int       `orm:"column(sms_service_provider_id);null"`
+	CompanyId            int       `orm:"column(company_id);null"`
+	Content              string    `orm:"column(content);type(text);null"`
+	ReceiverMobiles      string    `orm:"column(receiver_mobiles);type(text);null"`
+	SendStatus           string    `orm:"column(send_status);size(20);null"`
+	Count                int       `orm:"column(count);null"`
+	CountPerContent      int16     `orm:"column(count_per_content);null"`
+	MessageId            string    `orm:"column(message_id);size(100);null"`
+	SendAt               time.Time `orm:"column(send_at);type(datetime);null"`
 }
 
 func (t *SmsSendRecords) TableName() string {
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/1046102779/common"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/astaxie/beego/orm"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信敏感词过滤
+	>> 敏感词保存在sms_sensitive_words表，加载到内存构建多模式匹配自动机(见sms_word_matcher.go)，
+	   按SMS_SENSITIVE_WORD_RELOAD_INTERVAL周期重新加载
+	>> 提交服务商前检查短信内容和模板参数：
+		conf.SmsSensitiveWordAction = reject: 拒绝发送，返回SMS_MASS_SEND_BLACKWORDS和包含的敏感词
+		conf.SmsSensitiveWordAction = mask: 敏感词替换为*后发送
+	>> conf.SmsSensitiveWordRemoteCheck开启时，本地未匹配到敏感词的内容再调用云片网get_black_word检查，
+	   云片网返回的屏蔽词写入sms_sensitive_words表(来源：云片网)，之后本地即可匹配
+*/
+
+const (
+	// 错误码
+	SMS_MASS_SEND_BLACKWORDS = 12030 // 屏蔽词过滤
+
+	// 敏感词处理方式
+	SMS_SENSITIVE_WORD_ACTION_REJECT = "reject"
+	SMS_SENSITIVE_WORD_ACTION_MASK   = "mask"
+)
+
+var (
+	// 敏感词来源：10: 手动维护；20: 云片网屏蔽词
+	SMS_SENSITIVE_WORD_SOURCE_MANUAL  = 10
+	SMS_SENSITIVE_WORD_SOURCE_YUNPIAN = 20
+
+	SMS_SENSITIVE_WORD_RELOAD_INTERVAL = time.Minute // 敏感词重新加载周期
+
+	smsWordMatcherInstance *smsWordMatcher
+	smsWordMatcherLoadAt   time.Time
+	smsWordMatcherMutex    sync.RWMutex
+)
+
+type SmsSensitiveWords struct {
+	Id        int       `orm:"column(sms_sensitive_word_id);auto"`
+	Word      string    `orm:"column(word);size(100);null"`
+	Source    int16     `orm:"column(source);null"`
+	Status    int16     `orm:"column(status);null"`
+	UpdatedAt time.Time `orm:"column(updated_at);type(datetime);null"`
+	CreatedAt time.Time `orm:"column(created_at);type(datetime);null"`
+}
+
+func (t *SmsSensitiveWords) TableName() string {
+	return "sms_sensitive_words"
+}
+
+func init() {
+	orm.RegisterModel(new(SmsSensitiveWords))
+}
+
+// 短信内容包含敏感词
+type SmsSensitiveWordsError struct {
+	Words []string
+}
+
+func (t *SmsSensitiveWordsError) Error() string {
+	return "sms content contains sensitive words: " + strings.Join(t.Words, ",")
+}
+
+// 从sms_sensitive_words表重新加载敏感词
+func ReloadSmsSensitiveWords() (retcode int, err error) {
+	Logger.Info("enter ReloadSmsSensitiveWords.")
+	defer Logger.Info("left ReloadSmsSensitiveWords.")
+	var (
+		sensitiveWords []SmsSensitiveWords = []SmsSensitiveWords{}
+	)
+	o := orm.NewOrm()
+	if _, err = o.QueryTable((&SmsSensitiveWords{}).TableName()).Filter("status", utils.STATUS_VALID).All(&sensitiveWords, "Word"); err != nil {
+		err = errors.Wrap(err, "ReloadSmsSensitiveWords")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	words := make([]string, 0, len(sensitiveWords))
+	for index := 0; index < len(sensitiveWords); index++ {
+		words = append(words, strings.TrimSpace(sensitiveWords[index].Word))
+	}
+	matcher := newSmsWordMatcher(words)
+	smsWordMatcherMutex.Lock()
+	smsWordMatcherInstance = matcher
+	smsWordMatcherLoadAt = time.Now()
+	smsWordMatcherMutex.Unlock()
+	return
+}
+
+// 首次使用或者超过重新加载周期时，重新加载敏感词
+func getSmsWordMatcher() (matcher *smsWordMatcher) {
+	smsWordMatcherMutex.RLock()
+	matcher = smsWordMatcherInstance
+	expired := matcher == nil || time.Since(smsWordMatcherLoadAt) > SMS_SENSITIVE_WORD_RELOAD_INTERVAL
+	smsWordMatcherMutex.RUnlock()
+	if expired {
+		if _, err := ReloadSmsSensitiveWords(); err != nil {
+			Logger.Error(err.Error())
+		}
+		smsWordMatcherMutex.RLock()
+		matcher = smsWordMatcherInstance
+		smsWordMatcherMutex.RUnlock()
+	}
+	if matcher == nil {
+		matcher = newSmsWordMatcher(nil)
+	}
+	return
+}
+
+// 添加敏感词，已存在的词只恢复为有效
+func AddSmsSensitiveWords(words []string, source int) (retcode int, err error) {
+	Logger.Info("[%v] enter AddSmsSensitiveWords.", source)
+	defer Logger.Info("[%v] left AddSmsSensitiveWords.", source)
+	now := time.Now()
+	o := orm.NewOrm()
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word == "" {
+			continue
+		}
+		qs := o.QueryTable((&SmsSensitiveWords{}).TableName()).Filter("word", word)
+		if qs.Exist() {
+			if _, err = qs.Update(orm.Params{
+				"status":     utils.STATUS_VALID,
+				"updated_at": now,
+			}); err != nil {
+				err = errors.Wrap(err, "AddSmsSensitiveWords")
+				retcode = utils.DB_UPDATE_ERROR
+				return
+			}
+			continue
+		}
+		if _, err = o.Insert(&SmsSensitiveWords{
+			Word:      word,
+			Source:    int16(source),
+			Status:    int16(utils.STATUS_VALID),
+			UpdatedAt: now,
+			CreatedAt: now,
+		}); err != nil {
+			err = errors.Wrap(err, "AddSmsSensitiveWords")
+			retcode = utils.DB_INSERT_ERROR
+			return
+		}
+	}
+	return ReloadSmsSensitiveWords()
+}
+
+// 获取敏感词列表
+func GetSmsSensitiveWords(offset int64, limit int64) (sensitiveWords []SmsSensitiveWords, count int64, retcode int, err error) {
+	Logger.Info("enter GetSmsSensitiveWords.")
+	defer Logger.Info("left GetSmsSensitiveWords.")
+	sensitiveWords = []SmsSensitiveWords{}
+	o := orm.NewOrm()
+	qs := o.QueryTable((&SmsSensitiveWords{}).TableName()).Filter("status", utils.STATUS_VALID)
+	if count, err = qs.Count(); err != nil {
+		err = errors.Wrap(err, "GetSmsSensitiveWords")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	if _, err = qs.OrderBy("id").Limit(limit, offset).All(&sensitiveWords); err != nil {
+		err = errors.Wrap(err, "GetSmsSensitiveWords")
+		retcode = utils.DB_READ_ERROR
+		return
+	}
+	return
+}
+
+// 内容包含的敏感词，去重，按出现顺序
+func CheckSmsSensitiveWords(contents ...string) (words []string) {
+	matcher := getSmsWordMatcher()
+	exists := map[string]bool{}
+	for _, content := range contents {
+		for _, match := range matcher.match(content) {
+			if !exists[match.Word] {
+				exists[match.Word] = true
+				words = append(words, match.Word)
+			}
+		}
+	}
+	return
+}
+
+// 敏感词替换为*
+func MaskSmsSensitiveWords(content string) (masked string) {
+	matches := getSmsWordMatcher().match(content)
+	if len(matches) <= 0 {
+		return content
+	}
+	runes := []rune(content)
+	for _, match := range matches {
+		for index := match.Start; index < match.End; index++ {
+			runes[index] = '*'
+		}
+	}
+	return string(runes)
+}
+
+// 调用云片网检查内容中的屏蔽词，并写入敏感词表
+func checkSmsSensitiveWordsRemote(contents []string) (words []string) {
+	instance := GetYunpianInstance()
+	if instance == nil {
+		return
+	}
+	blackWords, _, err := instance.CheckBlackWord(strings.Join(contents, "\n"))
+	if err != nil {
+		// 云片网不可用时只采用本地敏感词
+		Logger.Error(err.Error())
+		return
+	}
+	if len(blackWords) <= 0 {
+		return
+	}
+	if _, err = AddSmsSensitiveWords(blackWords, SMS_SENSITIVE_WORD_SOURCE_YUNPIAN); err != nil {
+		Logger.Error(err.Error())
+	}
+	return CheckSmsSensitiveWords(contents...)
+}
+
+// 提交服务商前检查短信内容和模板参数中的敏感词：拒绝发送，或者替换为*后发送
+func FilterSmsSensitiveWords(req *SmsRequest) (words []string, retcode int, err error) {
+	if req == nil {
+		return
+	}
+	contents := []string{}
+	if req.Content != "" {
+		contents = append(contents, req.Content)
+	}
+	contents = append(contents, req.Contents...)
+	for _, arg := range req.TemplateArgs {
+		if value, ok := arg.(string); ok {
+			contents = append(contents, value)
+		}
+	}
+	for _, value := range req.TemplateParams {
+		contents = append(contents, value)
+	}
+	if len(contents) <= 0 {
+		return
+	}
+	if words = CheckSmsSensitiveWords(contents...); len(words) <= 0 && conf.SmsSensitiveWordRemoteCheck {
+		words = checkSmsSensitiveWordsRemote(contents)
+	}
+	if len(words) <= 0 {
+		return
+	}
+	if conf.SmsSensitiveWordAction != SMS_SENSITIVE_WORD_ACTION_MASK {
+		err = &SmsSensitiveWordsError{
+			Words: words,
+		}
+		retcode = SMS_MASS_SEND_BLACKWORDS
+		return
+	}
+	req.Content = MaskSmsSensitiveWords(req.Content)
+	for index := 0; index < len(req.Contents); index++ {
+		req.Contents[index] = MaskSmsSensitiveWords(req.Contents[index])
+	}
+	for index, arg := range req.TemplateArgs {
+		if value, ok := arg.(string); ok {
+			req.TemplateArgs[index] = MaskSmsSensitiveWords(value)
+		}
+	}
+	for name, value := range req.TemplateParams {
+		req.TemplateParams[name] = MaskSmsSensitiveWords(value)
+	}
+	return
+}
@@ -16,7 +16,14 @@ var (
 
 	// 10:创蓝253短信提供商；20: 云片网短信提供商
 	SMS_SERVICE_PROVIDER_TYPE_253_CHUANGLAN = 10
-	SMS_SERVICE_PROVIDER_TYPE_YUNPIAN       = 10
+	SMS_SERVICE_PROVIDER_TYPE_YUNPIAN       = 20
+
+	// 记录状态：-20:逻辑删除；10: 有效
+	SMS_STATUS_DELETED = -20
+
+	// 短信服务提供商编码
+	SMS_SERVICE_PROVIDER_CODE_253_CHUANGLAN = "253_CHUANGLAN_SMS_SERVICE"
+	SMS_SERVICE_PROVIDER_CODE_YUNPIAN       = "YUNPIAN_SMS_SERVICE"
 )
 
 type SmsServiceProviders struct {
@@ -26,6 +33,14 @@ type SmsServiceProviders struct {
 	Code               string    `orm:"column(code);size(50);null"`
 	SignName           string    `orm:"column(sign_name);size(50);null"`
 	SingleSmsMaxLength int       `orm:"column(single_sms_max_length);null"`
+	MultiSmsPartLength int       `orm:"column(multi_sms_part_length);null"`
+	SegmentEncoding    string    `orm:"column(segment_encoding);size(10);null"`
+	SignCounted        int16     `orm:"column(sign_counted);null"`
+	UnitPrice          float64   `orm:"column(unit_price);digits(10);decimals(4);null"`
+	CallbackSignType   string    `orm:"column(callback_sign_type);size(20);null"`
+	CallbackSecret     string    `orm:"column(callback_secret);size(128);null"`
+	CallbackAllowIps   string    `orm:"column(callback_allow_ips);size(1000);null"`
+	CallbackMaxSkew    int       `orm:"column(callback_max_skew);null"`
 	IsValid            int16     `orm:"column(is_valid);null"`
 	Status             int16     `orm:"column(status);null"`
 	UpdatedAt          time.Time `orm:"column(updated_at);type(datetime);null"`
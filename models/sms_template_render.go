@@ -0,0 +1,206 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	utils "github.com/1046102779/common"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信模板渲染
+	>> 模板内容采用命名变量：#name#(与云片网模板一致)或者{name}，变量名由字母、数字和下划线组成，不能以数字开头
+	>> 变量sign为保留变量，由实际发送的服务商签名替换；模板内容以【签名】开头时，签名同样替换为服务商签名
+	>> 按参数表(TemplateParams)渲染：模板中的所有变量必须提供，不能提供模板中不存在的变量
+	>> 按参数列表(TemplateArgs)渲染：按变量在模板中首次出现的顺序依次替换，参数个数必须与变量个数一致
+	>> 兼容旧模板：模板中没有命名变量时按fmt格式(%s)渲染，第一个参数为签名，参数个数必须与格式符个数一致，
+	   不再出现%!s(MISSING)
+*/
+
+const (
+	// 错误码
+	SMS_TEMPLATE_PARAMS_MISSING    = 12044 // 缺少模板变量
+	SMS_TEMPLATE_PARAMS_UNEXPECTED = 12045 // 模板变量个数不一致或者模板中不存在该变量
+
+	SMS_TEMPLATE_SIGN_VARIABLE = "sign" // 签名变量
+)
+
+var (
+	smsTemplateVariableRegexp = regexp.MustCompile(`#([A-Za-z_][A-Za-z0-9_]*)#|\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	smsTemplateVerbRegexp     = regexp.MustCompile(`%[-+# 0-9.]*[A-Za-z%]`)
+)
+
+// 模板变量不完整
+type SmsTemplateParamsError struct {
+	Missing    []string // 未提供的模板变量
+	Unexpected []string // 模板中不存在的变量
+}
+
+func (t *SmsTemplateParamsError) Error() string {
+	messages := []string{}
+	if len(t.Missing) > 0 {
+		messages = append(messages, "sms template params missing: "+strings.Join(t.Missing, ","))
+	}
+	if len(t.Unexpected) > 0 {
+		messages = append(messages, "sms template params unexpected: "+strings.Join(t.Unexpected, ","))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// 模板变量名，按首次出现的顺序排列，不含签名变量
+func GetSmsTemplateVariables(content string) (variables []string) {
+	var (
+		added map[string]bool = map[string]bool{}
+	)
+	variables = []string{}
+	for _, match := range smsTemplateVariableRegexp.FindAllStringSubmatch(content, -1) {
+		name := match[1] + match[2]
+		if name == SMS_TEMPLATE_SIGN_VARIABLE || added[name] {
+			continue
+		}
+		variables = append(variables, name)
+		added[name] = true
+	}
+	return
+}
+
+// 旧模板fmt格式符个数，不含%%
+func countSmsTemplateVerbs(content string) (count int) {
+	for _, verb := range smsTemplateVerbRegexp.FindAllString(content, -1) {
+		if verb != "%%" {
+			count++
+		}
+	}
+	return
+}
+
+// 按参数表替换模板变量，签名变量替换为服务商签名
+func renderSmsTemplateParams(content string, signName string, params map[string]string) (rendered string, retcode int, err error) {
+	var (
+		variables map[string]bool         = map[string]bool{}
+		paramsErr *SmsTemplateParamsError = &SmsTemplateParamsError{}
+	)
+	for _, name := range GetSmsTemplateVariables(content) {
+		variables[name] = true
+		if _, exist := params[name]; !exist {
+			paramsErr.Missing = append(paramsErr.Missing, name)
+		}
+	}
+	for name := range params {
+		if !variables[name] {
+			paramsErr.Unexpected = append(paramsErr.Unexpected, name)
+		}
+	}
+	sort.Strings(paramsErr.Unexpected)
+	if len(paramsErr.Missing) > 0 {
+		return "", SMS_TEMPLATE_PARAMS_MISSING, paramsErr
+	}
+	if len(paramsErr.Unexpected) > 0 {
+		return "", SMS_TEMPLATE_PARAMS_UNEXPECTED, paramsErr
+	}
+	// 一次替换完成，参数值中的#name#、{name}不会被再次替换
+	rendered = smsTemplateVariableRegexp.ReplaceAllStringFunc(content, func(placeholder string) string {
+		match := smsTemplateVariableRegexp.FindStringSubmatch(placeholder)
+		if name := match[1] + match[2]; name != SMS_TEMPLATE_SIGN_VARIABLE {
+			return params[name]
+		}
+		return signName
+	})
+	return resignSmsContent(rendered, signName), 0, nil
+}
+
+// 按模板渲染短信内容：有命名变量时按参数表或者参数列表渲染，否则按旧模板fmt格式渲染
+func RenderSmsTemplate(content string, signName string, params map[string]string, args []interface{}) (rendered string, retcode int, err error) {
+	if smsTemplateVariableRegexp.MatchString(content) {
+		variables := GetSmsTemplateVariables(content)
+		if params == nil {
+			// 参数列表按变量首次出现的顺序对应
+			if len(args) != len(variables) {
+				err = errors.Errorf("sms template needs %d args, %d given", len(variables), len(args))
+				retcode = SMS_TEMPLATE_PARAMS_UNEXPECTED
+				if len(args) < len(variables) {
+					err = &SmsTemplateParamsError{Missing: variables[len(args):]}
+					retcode = SMS_TEMPLATE_PARAMS_MISSING
+				}
+				return
+			}
+			params = map[string]string{}
+			for index, name := range variables {
+				params[name] = fmt.Sprint(args[index])
+			}
+		}
+		return renderSmsTemplateParams(content, signName, params)
+	}
+	if len(params) > 0 {
+		names := []string{}
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		err = &SmsTemplateParamsError{Unexpected: names}
+		retcode = SMS_TEMPLATE_PARAMS_UNEXPECTED
+		return
+	}
+	verbs := countSmsTemplateVerbs(content)
+	if verbs == 0 && len(args) == 0 {
+		// 没有变量的固定内容
+		return resignSmsContent(content, signName), 0, nil
+	}
+	// 旧模板：第一个格式符为签名
+	if verbs != len(args)+1 {
+		err = errors.Errorf("sms template needs %d args, %d given", verbs-1, len(args))
+		retcode = SMS_TEMPLATE_PARAMS_UNEXPECTED
+		if verbs > len(args)+1 {
+			retcode = SMS_TEMPLATE_PARAMS_MISSING
+		}
+		return
+	}
+	return fmt.Sprintf(content, append([]interface{}{signName}, args...)...), 0, nil
+}
+
+// 解析name=value格式的模板命名参数，用于只能传递字符串列表的rpcx请求；值中可以包含=
+func ParseSmsTemplateParams(items []string) (params map[string]string, retcode int, err error) {
+	params = map[string]string{}
+	for _, item := range items {
+		index := strings.Index(item, "=")
+		if index <= 0 || strings.TrimSpace(item[:index]) == "" {
+			err = errors.Errorf("sms template param `%s` not name=value", item)
+			retcode = utils.SOURCE_DATA_ILLEGAL
+			return
+		}
+		name := strings.TrimSpace(item[:index])
+		if _, exist := params[name]; exist {
+			err = errors.Errorf("sms template param `%s` duplicated", name)
+			retcode = utils.SOURCE_DATA_ILLEGAL
+			return
+		}
+		params[name] = item[index+1:]
+	}
+	return
+}
+
+// 校验模板和参数：至少有一个服务商可以按同名模板渲染短信内容，否则返回第一个服务商的渲染错误
+func ValidateSmsTemplateRequest(req *SmsRequest) (retcode int, err error) {
+	if req == nil || req.TemplateName == "" {
+		return
+	}
+	providers := GetRoutedSmsProviders(req)
+	if len(providers) <= 0 {
+		err = errors.New("sms service is unabled.")
+		retcode = utils.SMS_SERVICE_253_CHUANGLAN_UNABLED
+		return
+	}
+	for index, provider := range providers {
+		_, code, renderErr := renderSmsRequest(provider, req)
+		if renderErr == nil {
+			return 0, nil
+		}
+		if index == 0 {
+			retcode, err = code, renderErr
+		}
+	}
+	return
+}
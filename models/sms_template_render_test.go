@@ -0,0 +1,71 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	utils "github.com/1046102779/common"
+)
+
+func TestRenderSmsTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		params   map[string]string
+		args     []interface{}
+		expected string
+		retcode  int
+	}{
+		{"参数表", "【#sign#】您的验证码是#code#，{minutes}分钟有效", map[string]string{"code": "1234", "minutes": "5"}, nil, "【创蓝】您的验证码是1234，5分钟有效", 0},
+		{"参数列表", "【#sign#】您的验证码是#code#，{minutes}分钟有效", nil, []interface{}{1234, 5}, "【创蓝】您的验证码是1234，5分钟有效", 0},
+		{"重复变量", "【#sign#】#code#，再说一遍#code#", nil, []interface{}{"1234"}, "【创蓝】1234，再说一遍1234", 0},
+		{"参数值不再替换", "【#sign#】验证码#code#", map[string]string{"code": "#sign#"}, nil, "【创蓝】验证码#sign#", 0},
+		{"缺少参数", "【#sign#】验证码#code#", map[string]string{"minutes": "5"}, nil, "", SMS_TEMPLATE_PARAMS_MISSING},
+		{"多余参数", "【#sign#】验证码#code#", map[string]string{"code": "1234", "minutes": "5"}, nil, "", SMS_TEMPLATE_PARAMS_UNEXPECTED},
+		{"参数列表不足", "【#sign#】验证码#code#，{minutes}分钟有效", nil, []interface{}{1234}, "", SMS_TEMPLATE_PARAMS_MISSING},
+		{"参数列表过多", "【#sign#】验证码#code#", nil, []interface{}{1234, 5}, "", SMS_TEMPLATE_PARAMS_UNEXPECTED},
+		{"旧模板", "【%s】验证码%s", nil, []interface{}{"9"}, "【创蓝】验证码9", 0},
+		{"旧模板百分号", "【%s】验证码%s，100%%", nil, []interface{}{"9"}, "【创蓝】验证码9，100%", 0},
+		{"旧模板缺少参数", "【%s】验证码%s", nil, nil, "", SMS_TEMPLATE_PARAMS_MISSING},
+		{"旧模板多余参数", "【%s】验证码%s", nil, []interface{}{"9", "5"}, "", SMS_TEMPLATE_PARAMS_UNEXPECTED},
+		{"旧模板参数表", "【%s】验证码%s", map[string]string{"code": "9"}, nil, "", SMS_TEMPLATE_PARAMS_UNEXPECTED},
+		{"固定内容", "【云片】欢迎使用", nil, nil, "【创蓝】欢迎使用", 0},
+	}
+	for _, c := range cases {
+		rendered, retcode, err := RenderSmsTemplate(c.content, "创蓝", c.params, c.args)
+		if retcode != c.retcode || (retcode == 0) != (err == nil) {
+			t.Errorf("%s: RenderSmsTemplate(%q) retcode = %d, err = %v, expected retcode %d", c.name, c.content, retcode, err, c.retcode)
+			continue
+		}
+		if rendered != c.expected {
+			t.Errorf("%s: RenderSmsTemplate(%q) = %q, expected %q", c.name, c.content, rendered, c.expected)
+		}
+	}
+}
+
+func TestParseSmsTemplateParams(t *testing.T) {
+	cases := []struct {
+		name     string
+		items    []string
+		expected map[string]string
+		retcode  int
+	}{
+		{"命名参数", []string{"code=1234", "minutes=5"}, map[string]string{"code": "1234", "minutes": "5"}, 0},
+		{"值包含等号", []string{"url=a=b", "empty="}, map[string]string{"url": "a=b", "empty": ""}, 0},
+		{"去掉名称空格", []string{" code =1234"}, map[string]string{"code": "1234"}, 0},
+		{"没有参数", nil, map[string]string{}, 0},
+		{"缺少等号", []string{"code"}, nil, utils.SOURCE_DATA_ILLEGAL},
+		{"缺少名称", []string{"=1234"}, nil, utils.SOURCE_DATA_ILLEGAL},
+		{"重复参数", []string{"code=1", "code=2"}, nil, utils.SOURCE_DATA_ILLEGAL},
+	}
+	for _, c := range cases {
+		params, retcode, err := ParseSmsTemplateParams(c.items)
+		if retcode != c.retcode || (retcode == 0) != (err == nil) {
+			t.Errorf("%s: ParseSmsTemplateParams(%q) retcode = %d, err = %v, expected retcode %d", c.name, c.items, retcode, err, c.retcode)
+			continue
+		}
+		if retcode == 0 && !reflect.DeepEqual(params, c.expected) {
+			t.Errorf("%s: ParseSmsTemplateParams(%q) = %v, expected %v", c.name, c.items, params, c.expected)
+		}
+	}
+}
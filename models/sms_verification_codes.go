@@ -0,0 +1,216 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"strings"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信验证码
+	>> 验证码按用途区分：登录、注册、重置密码、绑定手机号，同一手机号不同用途的验证码互不影响
+	>> 验证码保存在redis：SMS:<mobile>:<PURPOSE>，有效期conf.SmsVerificationCodeTTL；重新获取会覆盖旧验证码
+	>> 校验次数保存在redis：SMS:<mobile>:<PURPOSE>:ATTEMPTS，每次校验先计数，超过conf.SmsVerificationMaxAttempts后验证码失效
+	>> 校验采用常量时间比较，校验成功后立即删除验证码，只能使用一次
+	>> HTTP接口和rpcx接口CodeMatch均通过本文件生成和校验验证码
+*/
+
+const (
+	// 错误码
+	SMS_VERIFICATION_CODE_EXPIRED = 12036 // 短信验证码不存在、已过期或者已使用
+	SMS_VERIFICATION_CODE_LOCKED  = 12037 // 短信验证码校验次数过多，已失效
+
+	// 验证码用途
+	SMS_VERIFICATION_PURPOSE_LOGIN          = "login"
+	SMS_VERIFICATION_PURPOSE_REGISTER       = "register"
+	SMS_VERIFICATION_PURPOSE_RESET_PASSWORD = "reset_password"
+	SMS_VERIFICATION_PURPOSE_BIND_MOBILE    = "bind_mobile"
+
+	// 验证码字符集
+	SMS_VERIFICATION_CHARSET_NUMERIC      = "numeric"
+	SMS_VERIFICATION_CHARSET_ALPHANUMERIC = "alphanumeric"
+)
+
+var (
+	smsVerificationPurposes = map[string]bool{
+		SMS_VERIFICATION_PURPOSE_LOGIN:          true,
+		SMS_VERIFICATION_PURPOSE_REGISTER:       true,
+		SMS_VERIFICATION_PURPOSE_RESET_PASSWORD: true,
+		SMS_VERIFICATION_PURPOSE_BIND_MOBILE:    true,
+	}
+	// 数字和大写字母，去掉容易混淆的0、1、I、O
+	smsVerificationAlphanumericChars = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// 验证码用途，为空时默认为登录
+func getSmsVerificationPurpose(purpose string) (normalized string, retcode int, err error) {
+	if normalized = strings.ToLower(strings.TrimSpace(purpose)); normalized == "" {
+		normalized = SMS_VERIFICATION_PURPOSE_LOGIN
+	}
+	if !smsVerificationPurposes[normalized] {
+		err = errors.Errorf("param `purpose` %s illegal", purpose)
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	return
+}
+
+// 验证码的redis key，如：SMS:13800138000:LOGIN
+func getSmsVerificationKey(mobile string, purpose string) string {
+	return fmt.Sprintf("SMS:%s:%s", mobile, strings.ToUpper(purpose))
+}
+
+func getSmsVerificationCharset() string {
+	switch conf.SmsVerificationCodeCharset {
+	case "", SMS_VERIFICATION_CHARSET_NUMERIC:
+		return "0123456789"
+	case SMS_VERIFICATION_CHARSET_ALPHANUMERIC:
+		return smsVerificationAlphanumericChars
+	}
+	return conf.SmsVerificationCodeCharset
+}
+
+// 生成随机验证码
+func newSmsVerificationCode() (code string, err error) {
+	charset := []rune(getSmsVerificationCharset())
+	length := conf.SmsVerificationCodeLength
+	if length <= 0 {
+		length = 6
+	}
+	runes := make([]rune, length)
+	max := big.NewInt(int64(len(charset)))
+	for index := 0; index < length; index++ {
+		n, randErr := rand.Int(rand.Reader, max)
+		if randErr != nil {
+			err = errors.Wrap(randErr, "newSmsVerificationCode")
+			return
+		}
+		runes[index] = charset[n.Int64()]
+	}
+	return string(runes), nil
+}
+
+// 生成手机号指定用途的验证码并保存到redis，返回规范化后的手机号和验证码
+func GenerateSmsVerificationCode(mobile string, purpose string) (normalizedMobile string, code string, retcode int, err error) {
+	Logger.Info("[%v.%v] enter GenerateSmsVerificationCode.", mobile, purpose)
+	defer Logger.Info("[%v.%v] left GenerateSmsVerificationCode.", mobile, purpose)
+	if normalizedMobile, retcode, err = normalizeSmsMobile(mobile); err != nil {
+		err = errors.Wrap(err, "GenerateSmsVerificationCode")
+		return
+	}
+	if purpose, retcode, err = getSmsVerificationPurpose(purpose); err != nil {
+		err = errors.Wrap(err, "GenerateSmsVerificationCode")
+		return
+	}
+	if code, err = newSmsVerificationCode(); err != nil {
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	key := getSmsVerificationKey(normalizedMobile, purpose)
+	if _, err = RedisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(key, code, conf.SmsVerificationCodeTTL)
+		// 新验证码重新计算校验次数
+		pipe.Del(key + ":ATTEMPTS")
+		return nil
+	}); err != nil {
+		err = errors.Wrap(err, "GenerateSmsVerificationCode")
+		retcode = utils.REDIS_SET_FAILED
+		return
+	}
+	return
+}
+
+// 删除手机号指定用途的验证码，如验证码短信发送失败时
+func RemoveSmsVerificationCode(mobile string, purpose string) (retcode int, err error) {
+	if normalized, _, normalizeErr := normalizeSmsMobile(mobile); normalizeErr == nil {
+		mobile = normalized
+	}
+	if purpose, retcode, err = getSmsVerificationPurpose(purpose); err != nil {
+		return
+	}
+	key := getSmsVerificationKey(mobile, purpose)
+	if err = RedisClient.Del(key, key+":ATTEMPTS").Err(); err != nil {
+		err = errors.Wrap(err, "RemoveSmsVerificationCode")
+		retcode = utils.REDIS_SET_FAILED
+		return
+	}
+	return
+}
+
+// 校验手机号指定用途的验证码，校验成功后验证码失效
+func VerifySmsVerificationCode(mobile string, purpose string, code string) (retcode int, err error) {
+	Logger.Info("[%v.%v] enter VerifySmsVerificationCode.", mobile, purpose)
+	defer Logger.Info("[%v.%v] left VerifySmsVerificationCode.", mobile, purpose)
+	if code = strings.TrimSpace(code); code == "" {
+		err = errors.New("param `code` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if conf.SmsVerificationCodeCharset == SMS_VERIFICATION_CHARSET_ALPHANUMERIC {
+		code = strings.ToUpper(code)
+	}
+	if mobile, retcode, err = normalizeSmsMobile(mobile); err != nil {
+		err = errors.Wrap(err, "VerifySmsVerificationCode")
+		return
+	}
+	if purpose, retcode, err = getSmsVerificationPurpose(purpose); err != nil {
+		err = errors.Wrap(err, "VerifySmsVerificationCode")
+		return
+	}
+	key := getSmsVerificationKey(mobile, purpose)
+	attemptsKey := key + ":ATTEMPTS"
+	// 先计数再比较，超过最多校验次数后删除验证码
+	attempts, err := RedisClient.Incr(attemptsKey).Result()
+	if err != nil {
+		err = errors.Wrap(err, "VerifySmsVerificationCode")
+		retcode = utils.REDIS_SET_FAILED
+		return
+	}
+	if attempts == 1 {
+		RedisClient.Expire(attemptsKey, conf.SmsVerificationCodeTTL)
+	}
+	if conf.SmsVerificationMaxAttempts > 0 && attempts > int64(conf.SmsVerificationMaxAttempts) {
+		RedisClient.Del(key)
+		err = errors.New("verification code attempts exceeded")
+		retcode = SMS_VERIFICATION_CODE_LOCKED
+		return
+	}
+	storedCode, err := RedisClient.Get(key).Result()
+	if err == redis.Nil {
+		err = errors.New("verification code expired")
+		retcode = SMS_VERIFICATION_CODE_EXPIRED
+		return
+	} else if err != nil {
+		err = errors.Wrap(err, "VerifySmsVerificationCode")
+		retcode = utils.REDIS_GET_FAILED
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(storedCode), []byte(code)) != 1 {
+		err = errors.New("verification code unmatched")
+		retcode = utils.VERIFICATION_NOT_MATCH
+		return
+	}
+	// 只能使用一次：并发校验时只有删除成功的请求通过
+	deleted, err := RedisClient.Del(key).Result()
+	if err != nil {
+		err = errors.Wrap(err, "VerifySmsVerificationCode")
+		retcode = utils.REDIS_SET_FAILED
+		return
+	}
+	if deleted <= 0 {
+		err = errors.New("verification code expired")
+		retcode = SMS_VERIFICATION_CODE_EXPIRED
+		return
+	}
+	RedisClient.Del(attemptsKey)
+	return
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/1046102779/common/httpRequest"
+	. "github.com/1046102779/common/utils"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+/*
+	短信验证码获取频率限制
+	>> 按客户端IP、设备指纹、手机号分层限制，每层可配置多个滑动窗口(如：1分钟1次，1天10次)，见conf.SmsVerification*Limits
+	>> 滑动窗口保存在redis有序集合：SMS:LIMIT:<维度>:<值>:<窗口秒数>，成员为请求ID，分值为请求时间(纳秒)
+	>> 每次请求先写入再计数，超过限制时删除本次写入并拒绝，并发请求不会超过限制
+	>> 所有请求在conf.SmsVerificationCaptchaLimit内超过阈值时(流量突增)，之后conf.SmsVerificationCaptchaPeriod内
+	   需要携带图形验证码token，由conf.SmsVerificationCaptchaApi校验
+	>> 各层限制返回不同的错误码，以及可以再次请求的等待时间
+*/
+
+const (
+	// 错误码
+	SMS_VERIFICATION_MOBILE_LIMITED   = 12038 // 手机号获取验证码过于频繁
+	SMS_VERIFICATION_IP_LIMITED       = 12039 // 客户端IP获取验证码过于频繁
+	SMS_VERIFICATION_DEVICE_LIMITED   = 12040 // 设备获取验证码过于频繁
+	SMS_VERIFICATION_CAPTCHA_REQUIRED = 12041 // 需要图形验证码
+	SMS_VERIFICATION_CAPTCHA_INVALID  = 12042 // 图形验证码校验失败
+
+	SMS_VERIFICATION_CAPTCHA_KEY = "SMS:LIMIT:CAPTCHA" // 开启图形验证的标记
+)
+
+// 获取验证码的客户端信息
+type SmsVerificationClient struct {
+	Mobile       string // 手机号
+	Ip           string // 客户端IP
+	DeviceId     string // 设备指纹，客户端未提供时为空，不限制
+	CaptchaToken string // 图形验证码token，开启图形验证后必填
+}
+
+// 超过频率限制
+type SmsRateLimitError struct {
+	Dimension  string        // 限制维度：mobile, ip, device
+	RetryAfter time.Duration // 可以再次请求的等待时间
+}
+
+func (t *SmsRateLimitError) Error() string {
+	return fmt.Sprintf("too many verification code requests by %s, retry after %d seconds", t.Dimension, int(t.RetryAfter.Seconds()+0.5))
+}
+
+// 在滑动窗口内记录一次请求，超过限制时撤销本次记录，并返回可以再次请求的等待时间
+func hitSmsRateLimit(key string, limit conf.RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error) {
+	member := fmt.Sprintf("%d-%s", now.UnixNano(), GetRandomString(6))
+	var card *redis.IntCmd
+	if _, err = RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(key, "-inf", fmt.Sprintf("%d", now.Add(-limit.Window).UnixNano()))
+		pipe.ZAdd(key, redis.Z{Score: float64(now.UnixNano()), Member: member})
+		card = pipe.ZCard(key)
+		pipe.Expire(key, limit.Window)
+		return nil
+	}); err != nil {
+		err = errors.Wrap(err, "hitSmsRateLimit")
+		return
+	}
+	if card.Val() <= int64(limit.Limit) {
+		return true, 0, nil
+	}
+	RedisClient.ZRem(key, member)
+	// 窗口内最早的请求过期后即可再次请求
+	if oldest, rangeErr := RedisClient.ZRangeWithScores(key, 0, 0).Result(); rangeErr == nil && len(oldest) > 0 {
+		retryAfter = time.Unix(0, int64(oldest[0].Score)).Add(limit.Window).Sub(now)
+	}
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	return
+}
+
+// 按维度检查所有滑动窗口，任一窗口超过限制即拒绝
+func checkSmsRateLimits(dimension string, value string, limits []conf.RateLimit, now time.Time) (err error) {
+	if value = strings.TrimSpace(value); value == "" {
+		return
+	}
+	for _, limit := range limits {
+		key := fmt.Sprintf("SMS:LIMIT:%s:%s:%d", strings.ToUpper(dimension), value, int(limit.Window.Seconds()))
+		allowed, retryAfter, hitErr := hitSmsRateLimit(key, limit, now)
+		if hitErr != nil {
+			return hitErr
+		}
+		if !allowed {
+			return &SmsRateLimitError{
+				Dimension:  dimension,
+				RetryAfter: retryAfter,
+			}
+		}
+	}
+	return
+}
+
+// 调用图形验证码校验接口
+func verifySmsCaptchaToken(token string, ip string) (ok bool, err error) {
+	if conf.SmsVerificationCaptchaApi == "" {
+		err = errors.New("captcha api not configured")
+		return
+	}
+	body, _ := json.Marshal(map[string]string{
+		"token": token,
+		"ip":    ip,
+	})
+	bodyData, err := httpRequest.HttpPostBody(conf.SmsVerificationCaptchaApi, body)
+	if err != nil {
+		err = errors.Wrap(err, "verifySmsCaptchaToken")
+		return
+	}
+	resp := struct {
+		Success bool `json:"success"`
+	}{}
+	if err = json.Unmarshal(bodyData, &resp); err != nil {
+		err = errors.Wrap(err, "verifySmsCaptchaToken")
+		return
+	}
+	return resp.Success, nil
+}
+
+// 统计所有请求，流量突增时开启图形验证；已开启时校验图形验证码
+func checkSmsCaptcha(client *SmsVerificationClient, now time.Time) (retcode int, err error) {
+	limit := conf.SmsVerificationCaptchaLimit
+	if limit.Limit <= 0 {
+		return
+	}
+	allowed, _, hitErr := hitSmsRateLimit("SMS:LIMIT:GLOBAL", limit, now)
+	if hitErr != nil {
+		// redis不可用时不开启图形验证
+		Logger.Error(hitErr.Error())
+		return
+	}
+	if !allowed {
+		if setErr := RedisClient.Set(SMS_VERIFICATION_CAPTCHA_KEY, now.Unix(), conf.SmsVerificationCaptchaPeriod).Err(); setErr != nil {
+			Logger.Error(setErr.Error())
+		}
+	}
+	if exist, existErr := RedisClient.Exists(SMS_VERIFICATION_CAPTCHA_KEY).Result(); existErr != nil || exist <= 0 {
+		return 0, nil
+	}
+	if strings.TrimSpace(client.CaptchaToken) == "" {
+		err = errors.New("captcha token required")
+		retcode = SMS_VERIFICATION_CAPTCHA_REQUIRED
+		return
+	}
+	ok, err := verifySmsCaptchaToken(client.CaptchaToken, client.Ip)
+	if err != nil {
+		err = errors.Wrap(err, "checkSmsCaptcha")
+		retcode = SMS_VERIFICATION_CAPTCHA_INVALID
+		return
+	}
+	if !ok {
+		err = errors.New("captcha token invalid")
+		retcode = SMS_VERIFICATION_CAPTCHA_INVALID
+		return
+	}
+	return
+}
+
+// 获取验证码前检查图形验证码和频率限制：依次为客户端IP、设备指纹、手机号
+func CheckSmsVerificationLimits(client *SmsVerificationClient) (retcode int, err error) {
+	Logger.Info("[%v.%v.%v] enter CheckSmsVerificationLimits.", client.Mobile, client.Ip, client.DeviceId)
+	defer Logger.Info("[%v.%v.%v] left CheckSmsVerificationLimits.", client.Mobile, client.Ip, client.DeviceId)
+	now := time.Now()
+	if retcode, err = checkSmsCaptcha(client, now); err != nil {
+		return
+	}
+	mobile := client.Mobile
+	if normalized, _, normalizeErr := normalizeSmsMobile(mobile); normalizeErr == nil {
+		mobile = normalized
+	}
+	for _, check := range []struct {
+		dimension string
+		value     string
+		limits    []conf.RateLimit
+		retcode   int
+	}{
+		{"ip", client.Ip, conf.SmsVerificationIpLimits, SMS_VERIFICATION_IP_LIMITED},
+		{"device", client.DeviceId, conf.SmsVerificationDeviceLimits, SMS_VERIFICATION_DEVICE_LIMITED},
+		{"mobile", mobile, conf.SmsVerificationMobileLimits, SMS_VERIFICATION_MOBILE_LIMITED},
+	} {
+		if err = checkSmsRateLimits(check.dimension, check.value, check.limits, now); err != nil {
+			if _, ok := err.(*SmsRateLimitError); ok {
+				retcode = check.retcode
+				return
+			}
+			// redis不可用时不限制，避免影响正常用户获取验证码
+			Logger.Error(err.Error())
+			err = nil
+		}
+	}
+	return
+}
+
+// 超过频率限制时可以再次请求的等待时间(秒)，其他错误返回0
+func GetSmsRetryAfter(err error) int {
+	if limitErr, ok := errors.Cause(err).(*SmsRateLimitError); ok {
+		return int(limitErr.RetryAfter.Seconds() + 0.5)
+	}
+	return 0
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/1046102779/common"
+	. "github.com/1046102779/common/utils"
+	"github.com/1046102779/sms/conf"
+	. "github.com/1046102779/sms/logger"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+/*
+	语音验证码
+	>> 支持语音验证码的服务商实现IVoice接口，按服务商发送优先级选择，未开通语音验证码的服务商跳过
+	>> 获取验证码时可指定语音通道(channel=voice)，直接拨打语音电话播报验证码
+	>> 自动回退(conf.SmsVoiceFallback)：验证码短信发送成功后记录短信消息ID，
+	   状态报告为EXPIRED(11)、UNDELIV(12)且验证码仍有效时，改为拨打语音电话播报同一验证码，每条短信最多回退一次
+*/
+
+// 语音验证码服务商接口
+type IVoice interface {
+	// 内部短信服务商ID，对应sms_service_providers表主键
+	GetSmsServiceProviderId() int
+	// 是否已开通语音验证码
+	IsVoiceEnabled() bool
+	// 拨打语音电话播报验证码，返回第三方呼叫ID
+	SendVoiceCode(mobile string, code string) (callId string, retcode int, err error)
+}
+
+const (
+	// 错误码
+	SMS_VOICE_UNAVAILABLE = 12043 // 没有可用的语音验证码服务商
+
+	// 验证码通道
+	SMS_VERIFICATION_CHANNEL_SMS   = "sms"
+	SMS_VERIFICATION_CHANNEL_VOICE = "voice"
+)
+
+// 按服务商发送优先级获取已开通语音验证码的服务商
+func GetVoiceProviders() (providers []IVoice) {
+	for _, provider := range GetPrioritizedSmsProviders() {
+		if voice, ok := provider.(IVoice); ok && voice.IsVoiceEnabled() {
+			providers = append(providers, voice)
+		}
+	}
+	return
+}
+
+// 拨打语音电话播报验证码，服务商失败时切换下一个服务商
+func SendVoiceVerificationCode(mobile string, code string) (callId string, retcode int, err error) {
+	Logger.Info("[%v] enter SendVoiceVerificationCode.", mobile)
+	defer Logger.Info("[%v] left SendVoiceVerificationCode.", mobile)
+	providers := GetVoiceProviders()
+	if len(providers) <= 0 {
+		err = errors.New("voice verification unavailable")
+		retcode = SMS_VOICE_UNAVAILABLE
+		return
+	}
+	for _, provider := range providers {
+		if !AllowSmsProvider(provider.GetSmsServiceProviderId()) {
+			Logger.Warn("[%v] sms provider circuit open, skipped.", provider.GetSmsServiceProviderId())
+			continue
+		}
+		startAt := time.Now()
+		callId, retcode, err = provider.SendVoiceCode(mobile, code)
+		RecordSmsProviderResult(provider.GetSmsServiceProviderId(), time.Since(startAt), err)
+		if err == nil {
+			return
+		}
+		Logger.Error("[%v] voice verification code send failed: %s", provider.GetSmsServiceProviderId(), err.Error())
+	}
+	if err == nil {
+		err = errors.New("all sms service providers circuit open")
+		retcode = SMS_PROVIDER_CIRCUIT_OPEN
+	}
+	return
+}
+
+// 自动回退记录的redis key
+func getSmsVoiceFallbackKey(messageId string, mobile string) string {
+	return fmt.Sprintf("SMS:VOICE_FALLBACK:%s:%s", messageId, mobile)
+}
+
+// 记录验证码短信，状态报告发送失败时回退到语音验证码
+func RegisterSmsVoiceFallback(result *SmsResult, mobile string, purpose string) {
+	if !conf.SmsVoiceFallback || result == nil {
+		return
+	}
+	messageId := result.GetMessageId(mobile)
+	if messageId == "" {
+		return
+	}
+	if err := RedisClient.Set(getSmsVoiceFallbackKey(messageId, mobile), purpose, conf.SmsVerificationCodeTTL).Err(); err != nil {
+		Logger.Error(err.Error())
+	}
+	return
+}
+
+// 验证码短信状态报告为EXPIRED、UNDELIV时，拨打语音电话播报同一验证码
+func fallbackSmsVoiceByReceipt(receipt *SmsReceipt) {
+	if !conf.SmsVoiceFallback || (receipt.ReceiptStatus != 11 && receipt.ReceiptStatus != 12) {
+		return
+	}
+	mobile := strings.TrimSpace(receipt.Mobile)
+	if normalized, _, normalizeErr := normalizeSmsMobile(mobile); normalizeErr == nil {
+		mobile = normalized
+	}
+	key := getSmsVoiceFallbackKey(receipt.MessageId, mobile)
+	purpose, err := RedisClient.Get(key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			Logger.Error(err.Error())
+		}
+		return
+	}
+	// 每条短信最多回退一次，重复推送的状态报告不再拨打
+	if deleted, delErr := RedisClient.Del(key).Result(); delErr != nil || deleted <= 0 {
+		return
+	}
+	if purpose, _, err = getSmsVerificationPurpose(purpose); err != nil {
+		Logger.Error(err.Error())
+		return
+	}
+	code, err := RedisClient.Get(getSmsVerificationKey(mobile, purpose)).Result()
+	if err != nil {
+		// 验证码已过期或者已使用
+		if err != redis.Nil {
+			Logger.Error(err.Error())
+		}
+		return
+	}
+	go func() {
+		if _, _, voiceErr := SendVoiceVerificationCode(mobile, code); voiceErr != nil {
+			Logger.Error(voiceErr.Error())
+		}
+	}()
+	return
+}
+
+// 验证码通道，为空时默认为短信
+func GetSmsVerificationChannel(channel string) (normalized string, retcode int, err error) {
+	switch normalized = strings.ToLower(strings.TrimSpace(channel)); normalized {
+	case "":
+		normalized = SMS_VERIFICATION_CHANNEL_SMS
+	case SMS_VERIFICATION_CHANNEL_SMS, SMS_VERIFICATION_CHANNEL_VOICE:
+	default:
+		err = errors.Errorf("param `channel` %s illegal", channel)
+		retcode = utils.SOURCE_DATA_ILLEGAL
+	}
+	return
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"unicode"
+)
+
+/*
+	多模式匹配(Aho–Corasick自动机)
+	>> 按字符(rune)构建字典树，广度优先构建失败指针，一次扫描找出内容中所有词的位置
+	>> 不区分大小写：词和内容均按字符转换为小写后匹配，匹配位置与原内容字符位置一致
+*/
+
+type smsWordNode struct {
+	children map[rune]*smsWordNode
+	fail     *smsWordNode
+	words    []string // 以该节点结尾的词(含失败指针上的词)
+}
+
+type smsWordMatcher struct {
+	root *smsWordNode
+}
+
+// 匹配到的词，Start和End为内容的字符(rune)下标，[Start, End)
+type smsWordMatch struct {
+	Word  string
+	Start int
+	End   int
+}
+
+func newSmsWordNode() *smsWordNode {
+	return &smsWordNode{
+		children: map[rune]*smsWordNode{},
+	}
+}
+
+func toLowerRunes(content string) []rune {
+	runes := []rune(content)
+	for index := 0; index < len(runes); index++ {
+		runes[index] = unicode.ToLower(runes[index])
+	}
+	return runes
+}
+
+func newSmsWordMatcher(words []string) (matcher *smsWordMatcher) {
+	matcher = &smsWordMatcher{
+		root: newSmsWordNode(),
+	}
+	for _, word := range words {
+		runes := toLowerRunes(word)
+		if len(runes) <= 0 {
+			continue
+		}
+		node := matcher.root
+		for _, char := range runes {
+			child, exist := node.children[char]
+			if !exist {
+				child = newSmsWordNode()
+				node.children[char] = child
+			}
+			node = child
+		}
+		node.words = append(node.words, string(runes))
+	}
+	// 广度优先构建失败指针
+	queue := []*smsWordNode{}
+	for _, child := range matcher.root.children {
+		child.fail = matcher.root
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for char, child := range node.children {
+			fail := node.fail
+			for fail != nil {
+				if next, exist := fail.children[char]; exist {
+					child.fail = next
+					break
+				}
+				fail = fail.fail
+			}
+			if child.fail == nil {
+				child.fail = matcher.root
+			}
+			child.words = append(child.words, child.fail.words...)
+			queue = append(queue, child)
+		}
+	}
+	return
+}
+
+// 找出内容中所有匹配的词及其位置
+func (t *smsWordMatcher) match(content string) (matches []smsWordMatch) {
+	node := t.root
+	for index, char := range toLowerRunes(content) {
+		for node != t.root {
+			if _, exist := node.children[char]; exist {
+				break
+			}
+			node = node.fail
+		}
+		if next, exist := node.children[char]; exist {
+			node = next
+		}
+		for _, word := range node.words {
+			length := len([]rune(word))
+			matches = append(matches, smsWordMatch{
+				Word:  word,
+				Start: index + 1 - length,
+				End:   index + 1,
+			})
+		}
+	}
+	return
+}
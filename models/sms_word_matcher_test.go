@@ -0,0 +1,28 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSmsWordMatcher(t *testing.T) {
+	cases := []struct {
+		name     string
+		words    []string
+		content  string
+		expected []smsWordMatch
+	}{
+		{"无匹配", []string{"发票"}, "您的验证码是1234", nil},
+		{"空词表", nil, "发票", nil},
+		{"忽略空词", []string{"", "a"}, "aa", []smsWordMatch{{"a", 0, 1}, {"a", 1, 2}}},
+		{"失败指针", []string{"he", "she", "his", "hers"}, "ushers", []smsWordMatch{{"she", 1, 4}, {"he", 2, 4}, {"hers", 2, 6}}},
+		{"不区分大小写", []string{"ABC"}, "xAbCx", []smsWordMatch{{"abc", 1, 4}}},
+		{"中文字符位置", []string{"代开发票", "发票"}, "可代开发票", []smsWordMatch{{"代开发票", 1, 5}, {"发票", 3, 5}}},
+		{"重叠匹配", []string{"aa"}, "aaa", []smsWordMatch{{"aa", 0, 2}, {"aa", 1, 3}}},
+	}
+	for _, c := range cases {
+		if matches := newSmsWordMatcher(c.words).match(c.content); !reflect.DeepEqual(matches, c.expected) {
+			t.Errorf("%s: match(%q) = %v, expected %v", c.name, c.content, matches, c.expected)
+		}
+	}
+}
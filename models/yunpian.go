@@ -14,7 +14,6 @@ import (
 	pb "github.com/1046102779/igrpc"
 	"github.com/1046102779/sms/conf"
 	. "github.com/1046102779/sms/logger"
-	"github.com/astaxie/beego/orm"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 )
@@ -41,12 +40,20 @@ import (
 	说明：请求方法全部为POST请求
 */
 
+var (
+	SMS_YUNPIAN_MAX_MOBILES = 1000 // 批量发送手机号个数上限
+)
+
 type YunpianInfo struct {
-	SingleApiKey       string // 普通发送apikey值
-	GroupApiKey        string // 群发短信apikey值
-	HttpApi            string // 短信服务调用Http api地址
-	ReceiverHttpApi    string // 短信服务系统接收地址
-	SingleSmsMaxLength int    // 云片网单条短信最大长度，超过此长度，则分条发送
+	SingleApiKey         string // 普通发送apikey值
+	GroupApiKey          string // 群发短信apikey值
+	HttpApi              string // 短信服务调用Http api地址
+	ReceiverHttpApi      string // 短信服务系统接收地址
+	SingleSmsMaxLength   int    // 云片网单条短信最大长度，超过此长度，则分条发送
+	SignName             string // 短信服务应用签名
+	SmsServiceProviderId int    // 内部短信服务商ID
+
+	SegmentRule *SmsSegmentRule // 短信计费规则，见sms_segments.go
 }
 
 type YunpianSingleSendInfo struct {
@@ -54,6 +61,7 @@ type YunpianSingleSendInfo struct {
 	Mobile      string `json:"mobile"`
 	Text        string `json:"text"`
 	CallbackUrl string `json:"callback_url"`
+	Extend      string `json:"extend,omitempty"` // 扩展号，上行短信按扩展号关联原发送
 }
 
 type YunpianSingleSendRespInfo struct {
@@ -82,46 +90,61 @@ type BatchSmsSendRespInfo struct {
 	Datas      []SendSmsRespInfo `json:"data"`
 }
 
-var (
-	yunpianService *YunpianInfo
-)
+func init() {
+	RegisterSmsProviderFactory(SMS_SERVICE_PROVIDER_TYPE_YUNPIAN, SMS_SERVICE_PROVIDER_CODE_YUNPIAN, newYunpianProvider)
+	RegisterSmsCallbackHandler(SMS_SERVICE_PROVIDER_TYPE_YUNPIAN, SMS_SERVICE_PROVIDER_CODE_YUNPIAN, SMS_CALLBACK_RECEIPT, handleYunpianReceiptCallback)
+	RegisterSmsCallbackHandler(SMS_SERVICE_PROVIDER_TYPE_YUNPIAN, SMS_SERVICE_PROVIDER_CODE_YUNPIAN, SMS_CALLBACK_INBOUND, handleYunpianInboundCallback)
+}
 
-func GetYunpianInstance() (instance *YunpianInfo) {
-	Logger.Info("enter GetYunpianInstance.")
-	defer Logger.Info("left GetYunpianInstance.")
-	mutex.Lock()
-	defer mutex.Unlock()
-	if yunpianService == nil {
-		// 调用rpcx服务，获取系统配置的云片网appkey列表
-		systemConfInfo := &pb.YunpianConfInfo{}
-		conf.AccountClient.Call(fmt.Sprintf("%s.%s", "accounts", "GetYunpianAccountInfo"), systemConfInfo, systemConfInfo)
-		// 获取云片网服务提供商，单条短信最大长度
-		singleSmsMaxLength := 0
-		var (
-			smsServiceProviders []SmsServiceProviders = []SmsServiceProviders{}
-		)
-		o := orm.NewOrm()
-		num, err := o.QueryTable((&SmsServiceProviders{}).TableName()).Filter("type", 20).Filter("status", utils.STATUS_VALID).Filter("is_valid", 20).All(&smsServiceProviders)
-		if err != nil {
-			Logger.Error(err.Error())
-			return
-		}
-		if num > 0 {
-			singleSmsMaxLength = smsServiceProviders[0].SingleSmsMaxLength
-		}
-		yunpianService = &YunpianInfo{
-			SingleApiKey:       systemConfInfo.SingleApiKey,
-			GroupApiKey:        systemConfInfo.GroupApiKey,
-			HttpApi:            systemConfInfo.HttpApi,
-			ReceiverHttpApi:    systemConfInfo.ReceiverHttpApi,
-			SingleSmsMaxLength: singleSmsMaxLength,
-		}
+// 云片网状态报告推送(POST请求)，响应SUCCESS表示接收成功，否则云片网会重新推送
+func handleYunpianReceiptCallback(provider ISMS, params url.Values, body []byte) (response []byte, retcode int, err error) {
+	if retcode, err = SaveSmsReceiptCallback(provider, params, body); err != nil {
+		return
 	}
-	return yunpianService
+	response = []byte("SUCCESS")
+	return
+}
+
+// 云片网上行短信推送(POST请求)，响应SUCCESS表示接收成功，否则云片网会重新推送
+func handleYunpianInboundCallback(provider ISMS, params url.Values, body []byte) (response []byte, retcode int, err error) {
+	if retcode, err = SaveSmsInboundCallback(provider, params, body); err != nil {
+		return
+	}
+	response = []byte("SUCCESS")
+	return
 }
 
-// 1.1 单条发送 https://sms.yunpian.com/v2/sms/single_send.json
-func (t *YunpianInfo) SendSingleSms(content string, mobile string) (count int, fee int, msgid string, retcode int, err error) {
+// 云片网服务商工厂方法：调用rpcx服务，获取系统配置的云片网appkey列表
+func newYunpianProvider(provider *SmsServiceProviders) (instance ISMS, retcode int, err error) {
+	Logger.Info("[%v] enter newYunpianProvider.", provider.Id)
+	defer Logger.Info("[%v] left newYunpianProvider.", provider.Id)
+	systemConfInfo := &pb.YunpianConfInfo{}
+	if err = conf.AccountClient.Call(fmt.Sprintf("%s.%s", "accounts", "GetYunpianAccountInfo"), systemConfInfo, systemConfInfo); err != nil {
+		err = errors.Wrap(err, "newYunpianProvider")
+		retcode = utils.HTTP_CALL_FAILD_EXTERNAL
+		return
+	}
+	instance = &YunpianInfo{
+		SingleApiKey:         systemConfInfo.SingleApiKey,
+		GroupApiKey:          systemConfInfo.GroupApiKey,
+		HttpApi:              systemConfInfo.HttpApi,
+		ReceiverHttpApi:      systemConfInfo.ReceiverHttpApi,
+		SingleSmsMaxLength:   provider.SingleSmsMaxLength,
+		SegmentRule:          newSmsSegmentRule(provider),
+		SignName:             provider.SignName,
+		SmsServiceProviderId: provider.Id,
+	}
+	return
+}
+
+// 从服务商注册表获取云片网服务，尚未启用返回nil
+func GetYunpianInstance() (instance *YunpianInfo) {
+	instance, _ = GetSmsProviderByCode(SMS_SERVICE_PROVIDER_CODE_YUNPIAN).(*YunpianInfo)
+	return
+}
+
+// 1.1 单条发送 https://sms.yunpian.com/v2/sms/single_send.json, extend为扩展号，为空不发送
+func (t *YunpianInfo) SendSingleSms(content string, mobile string, extend string) (count int, fee int, msgid string, retcode int, err error) {
 	Logger.Info("[%v] enter SendSingleSms.", mobile)
 	defer Logger.Info("[%v] enter SendSingleSms.", mobile)
 	var (
@@ -140,6 +163,7 @@ func (t *YunpianInfo) SendSingleSms(content string, mobile string) (count int, f
 		Mobile:      mobile,
 		Text:        content,
 		CallbackUrl: t.ReceiverHttpApi,
+		Extend:      extend,
 	}
 	body, _ = json.Marshal(*singleSendInfo)
 	if bodyData, err = httpRequest.HttpPostBody(httpStr, body); err != nil {
@@ -164,8 +188,8 @@ func (t *YunpianInfo) SendSingleSms(content string, mobile string) (count int, f
 	return
 }
 
-// 1.2 批量发送相同内容 https://sms.yunpian.com/v2/sms/batch_send.json
-func (t *YunpianInfo) SendBatchSms(content string, mobiles []string) (count int, totalFee int, retcode int, err error) {
+// 1.2 批量发送相同内容 https://sms.yunpian.com/v2/sms/batch_send.json, extend为扩展号，为空不发送
+func (t *YunpianInfo) SendBatchSms(content string, mobiles []string, extend string) (count int, totalFee int, msgids map[string]string, failed map[string]int, retcode int, err error) {
 	Logger.Info("enter SendBatchSms.")
 	defer Logger.Info("left SendBatchSms.")
 	var (
@@ -183,6 +207,7 @@ func (t *YunpianInfo) SendBatchSms(content string, mobiles []string) (count int,
 		Mobile:      strings.Join(mobiles, ","),
 		Text:        content,
 		CallbackUrl: t.ReceiverHttpApi,
+		Extend:      extend,
 	}
 	body, _ = json.Marshal(*batchSmsInfo)
 	if bodyData, err = httpRequest.HttpPostBody(httpStr, body); err != nil {
@@ -195,12 +220,20 @@ func (t *YunpianInfo) SendBatchSms(content string, mobiles []string) (count int,
 		retcode = utils.JSON_PARSE_FAILED
 		return
 	}
+	msgids = map[string]string{}
+	failed = map[string]int{}
+	// 每个手机号单独返回发送结果，部分手机号失败时其他手机号已发送成功，只有全部失败时返回错误
 	for index := 0; batchSmsRespInfo.Datas != nil && index < len(batchSmsRespInfo.Datas); index++ {
 		if batchSmsRespInfo.Datas[index].Code != 0 {
-			err = t.getErrorMessage(batchSmsRespInfo.Datas[index].Code)
-			retcode = batchSmsRespInfo.Datas[index].Code
-			return
+			failed[batchSmsRespInfo.Datas[index].Mobile] = batchSmsRespInfo.Datas[index].Code
+			if len(msgids) <= 0 {
+				err = t.getErrorMessage(batchSmsRespInfo.Datas[index].Code)
+				retcode = batchSmsRespInfo.Datas[index].Code
+			}
+			continue
 		}
+		msgids[batchSmsRespInfo.Datas[index].Mobile] = fmt.Sprintf("%d", batchSmsRespInfo.Datas[index].Sid)
+		retcode, err = 0, nil
 	}
 	count = batchSmsRespInfo.TotalCount
 	totalFeeTemp, _ := strconv.ParseFloat(batchSmsRespInfo.TotalFee, 64)
@@ -208,7 +241,7 @@ func (t *YunpianInfo) SendBatchSms(content string, mobiles []string) (count int,
 	return
 }
 
-func (t *YunpianInfo) SendMultiSms(contents []string, mobiles []string) (count int, totalFee int, retcode int, err error) {
+func (t *YunpianInfo) SendMultiSms(contents []string, mobiles []string, extend string) (count int, totalFee int, msgids map[string]string, failed map[string]int, retcode int, err error) {
 	Logger.Info("enter SendMultiSms.")
 	defer Logger.Info("left SendMultiSms.")
 	var (
@@ -234,7 +267,7 @@ func (t *YunpianInfo) SendMultiSms(contents []string, mobiles []string) (count i
 			content = fmt.Sprintf("%s,%s", content, url.QueryEscape(contents[index]))
 		}
 	}
-	if len(mobiles) > 1000 {
+	if len(mobiles) > SMS_YUNPIAN_MAX_MOBILES {
 		err = errors.New("param the length `mobiles` beyond max 1000")
 		retcode = utils.SOURCE_DATA_ILLEGAL
 		return
@@ -244,6 +277,7 @@ func (t *YunpianInfo) SendMultiSms(contents []string, mobiles []string) (count i
 		Mobile:      strings.Join(mobiles, ","),
 		Text:        content,
 		CallbackUrl: t.ReceiverHttpApi,
+		Extend:      extend,
 	}
 	body, _ = json.Marshal(*multiSmsInfo)
 	httpStr := fmt.Sprintf("https://sms.yunpian.com/v2/sms/multi_send.json")
@@ -257,12 +291,20 @@ func (t *YunpianInfo) SendMultiSms(contents []string, mobiles []string) (count i
 		retcode = utils.JSON_PARSE_FAILED
 		return
 	}
+	msgids = map[string]string{}
+	failed = map[string]int{}
+	// 每个手机号单独返回发送结果，部分手机号失败时其他手机号已发送成功，只有全部失败时返回错误
 	for index := 0; multiSmsRespInfo.Datas != nil && index < len(multiSmsRespInfo.Datas); index++ {
 		if multiSmsRespInfo.Datas[index].Code != 0 {
-			err = t.getErrorMessage(multiSmsRespInfo.Datas[index].Code)
-			retcode = multiSmsRespInfo.Datas[index].Code
-			return
+			failed[multiSmsRespInfo.Datas[index].Mobile] = multiSmsRespInfo.Datas[index].Code
+			if len(msgids) <= 0 {
+				err = t.getErrorMessage(multiSmsRespInfo.Datas[index].Code)
+				retcode = multiSmsRespInfo.Datas[index].Code
+			}
+			continue
 		}
+		msgids[multiSmsRespInfo.Datas[index].Mobile] = fmt.Sprintf("%d", multiSmsRespInfo.Datas[index].Sid)
+		retcode, err = 0, nil
 	}
 	count = multiSmsRespInfo.TotalCount
 	totalFeeTemp, _ := strconv.ParseFloat(multiSmsRespInfo.TotalFee, 64)
@@ -291,43 +333,136 @@ func (t *YunpianInfo) SendMultiSms(contents []string, mobiles []string) (count i
 
 // 云片回执推送报告结构体
 type YunpianReceiptInfo struct {
-	Sid             int64     `json:"sid"`
-	UserReceiveTime time.Time `json:"user_receive_time"`
-	ErrMsg          string    `json:"error_msg"`
-	Mobile          string    `json:"mobile"`
-	ReportStatus    string    `json:"report_status"`
+	Sid             int64  `json:"sid"`
+	UserReceiveTime string `json:"user_receive_time"`
+	ErrMsg          string `json:"error_msg"`
+	Mobile          string `json:"mobile"`
+	ReportStatus    string `json:"report_status"`
+}
+
+// 云片网状态报告推送：表单字段sms_status为JSON格式的状态报告列表
+func (t *YunpianInfo) ParseReceipts(params url.Values, body []byte) (receipts []SmsReceipt, retcode int, err error) {
+	var (
+		receiptInfos []YunpianReceiptInfo = []YunpianReceiptInfo{}
+		receiptAt    string
+	)
+	smsStatus := params.Get("sms_status")
+	if smsStatus == "" {
+		// 请求未按表单解析时，从请求体中解析表单
+		if values, parseErr := url.ParseQuery(string(body)); parseErr == nil {
+			smsStatus = values.Get("sms_status")
+		}
+	}
+	if strings.TrimSpace(smsStatus) == "" {
+		err = errors.New("param `sms_status` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if err = jsoniter.Unmarshal([]byte(smsStatus), &receiptInfos); err != nil {
+		err = errors.Wrap(err, "ParseReceipts")
+		retcode = utils.JSON_PARSE_FAILED
+		return
+	}
+	for index := 0; index < len(receiptInfos); index++ {
+		// 状态报告时间统一为创蓝253格式：yyyyMMddHHmm
+		receiptAt = receiptInfos[index].UserReceiveTime
+		if receiveTime, parseErr := time.Parse("2006-01-02 15:04:05", receiptAt); parseErr == nil {
+			receiptAt = receiveTime.Format("200601021504")
+		}
+		receipts = append(receipts, SmsReceipt{
+			MessageId:     fmt.Sprintf("%d", receiptInfos[index].Sid),
+			Mobile:        receiptInfos[index].Mobile,
+			ReceiptStatus: t.getReportErrorMessage(receiptInfos[index].ReportStatus, receiptInfos[index].ErrMsg),
+			ReceiptAt:     receiptAt,
+		})
+	}
+	return
 }
 
-type YunpianReceipt struct {
-	SmsStatus []YunpianReceiptInfo `json:"sms_status"`
+/*
+	云片网上行短信推送：表单字段sms_reply为JSON格式的单条回复
+	sms_reply:{
+		"id": "2ec2b7f8a2ff4fd8b2d1b5ea0a2a8c3a", //回复短信id
+		"mobile": "15205201314", //回复手机号
+		"reply_time": "2014-03-17 22:55:21", //回复时间
+		"text": "回复内容",
+		"extend": "01", //用户自定义扩展码
+		"base_extend": "8888", //系统分配的扩展码
+		"_sign": "" //签名字段
+	}
+*/
+
+// 云片上行短信推送结构体
+type YunpianReplyInfo struct {
+	Id         string `json:"id"`
+	Mobile     string `json:"mobile"`
+	ReplyTime  string `json:"reply_time"`
+	Text       string `json:"text"`
+	Extend     string `json:"extend"`
+	BaseExtend string `json:"base_extend"`
 }
 
-func (t *YunpianInfo) ReceivedNotification(yunpianReceipt *YunpianReceipt) (retcode int, err error) {
-	Logger.Info("enter ReceivedNotification.")
-	defer Logger.Info("left ReceivedNotification.")
+// 云片网上行短信推送：表单字段sms_reply为JSON格式的回复内容
+func (t *YunpianInfo) ParseInbounds(params url.Values, body []byte) (inbounds []SmsInbound, retcode int, err error) {
 	var (
-		smsReceiptFailedRecord *SmsReceiptFailedRecords
+		replyInfo  *YunpianReplyInfo = new(YunpianReplyInfo)
+		receivedAt string
 	)
-	if yunpianReceipt == nil {
-		Logger.Error("urlencode need to parse.")
-		return
-	}
-	o := orm.NewOrm()
-	for index := 0; index < len(yunpianReceipt.SmsStatus); index++ {
-		if yunpianReceipt.SmsStatus[index].ReportStatus == "SUCCESS" {
-			smsReceiptFailedRecord = &SmsReceiptFailedRecords{
-				MessageId:     fmt.Sprintf("%d", yunpianReceipt.SmsStatus[index].Sid),
-				Mobile:        yunpianReceipt.SmsStatus[index].Mobile,
-				ReceiptStatus: 12, // FAIL： 在创蓝253的错误码短消息是不可达的
-				ReceiptAt:     yunpianReceipt.SmsStatus[index].UserReceiveTime.Format("20060102150405"),
-			}
-			retcode, err = smsReceiptFailedRecord.InsertSmsReceiptFailedRecordNoLock(&o)
-			if err != nil {
-				err = errors.Wrap(err, "ReceivedNotification")
-				return
-			}
+	smsReply := params.Get("sms_reply")
+	if smsReply == "" {
+		// 请求未按表单解析时，从请求体中解析表单
+		if values, parseErr := url.ParseQuery(string(body)); parseErr == nil {
+			smsReply = values.Get("sms_reply")
 		}
 	}
+	if strings.TrimSpace(smsReply) == "" {
+		err = errors.New("param `sms_reply` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	if err = jsoniter.Unmarshal([]byte(smsReply), replyInfo); err != nil {
+		err = errors.Wrap(err, "ParseInbounds")
+		retcode = utils.JSON_PARSE_FAILED
+		return
+	}
+	// 回复时间统一为创蓝253格式：yyyyMMddHHmm
+	receivedAt = replyInfo.ReplyTime
+	if replyTime, parseErr := time.Parse("2006-01-02 15:04:05", receivedAt); parseErr == nil {
+		receivedAt = replyTime.Format("200601021504")
+	}
+	inbounds = append(inbounds, SmsInbound{
+		MessageId:  replyInfo.Id,
+		Mobile:     replyInfo.Mobile,
+		Content:    replyInfo.Text,
+		ExtendCode: replyInfo.BaseExtend + replyInfo.Extend,
+		ReceivedAt: receivedAt,
+	})
+	return
+}
+
+// 云片网状态报告转换为创蓝253状态码，见ChuanglanInfo.getReportErrorMessage
+// report_status为SUCCESS时送达成功；FAIL时error_msg为运营商返回的代码，如：UNDELIV、DB:0103
+func (t *YunpianInfo) getReportErrorMessage(reportStatus string, errMsg string) (status int16) {
+	if reportStatus == "SUCCESS" {
+		return 0
+	}
+	errMsg = strings.ToUpper(strings.TrimSpace(errMsg))
+	switch {
+	case strings.Contains(errMsg, "EXPIRED"): // 短消息超过有效期
+		status = 11
+	case strings.Contains(errMsg, "UNDELIV"): // 短消息是不可达的
+		status = 12
+	case strings.Contains(errMsg, "UNKNOWN"): // 未知短消息状态
+		status = 13
+	case strings.Contains(errMsg, "REJECTD"): // 短消息被短信中心拒绝
+		status = 14
+	case strings.Contains(errMsg, "BLACK"): // 目的号码是黑名单号码
+		status = 15
+	case strings.Contains(errMsg, "REJECT"):
+		status = 17
+	default: // 网关内部状态
+		status = 18
+	}
 	return
 }
 
@@ -737,7 +872,16 @@ func (t *YunpianInfo) GetRecords(searchMobile string, startTime time.Time, endTi
 	return
 }
 
-// 查屏蔽词
+// 查屏蔽词, 返回内容中包含的屏蔽词
+/*
+	{
+		"code": 0,
+		"msg": "OK",
+		"result": {
+			"black_word": "高兴,天使"
+		}
+	}
+*/
 func (t *YunpianInfo) CheckBlackWord(content string) (blackWords []string, retcode int, err error) {
 	Logger.Info("enter CheckBlackWord.")
 	defer Logger.Info("left CheckBlackWord.")
@@ -745,8 +889,16 @@ func (t *YunpianInfo) CheckBlackWord(content string) (blackWords []string, retco
 		ApiKey  string `json:"apikey"`
 		Content string `json:"text"`
 	}
+	type BlackRespInfo struct {
+		Code   int    `json:"code"`
+		Msg    string `json:"msg"`
+		Result struct {
+			BlackWord string `json:"black_word"`
+		} `json:"result"`
+	}
 	var (
 		body, bodyData []byte
+		resp           *BlackRespInfo = new(BlackRespInfo)
 	)
 	blackInfo := &BlackInfo{
 		ApiKey:  t.SingleApiKey,
@@ -759,9 +911,144 @@ func (t *YunpianInfo) CheckBlackWord(content string) (blackWords []string, retco
 		retcode = utils.HTTP_CALL_FAILD_EXTERNAL
 		return
 	}
-	blackWords = append(blackWords, strings.Split(string(bodyData), ",")...)
+	if err = jsoniter.Unmarshal(bodyData, resp); err != nil {
+		err = errors.Wrap(err, "CheckBlackWord")
+		retcode = utils.JSON_PARSE_FAILED
+		return
+	}
+	if resp.Code != 0 {
+		// 未知错误码时采用云片网返回的错误信息
+		if err = t.getErrorMessage(resp.Code); err == nil {
+			err = errors.New(resp.Msg)
+		}
+		retcode = resp.Code
+		return
+	}
+	for _, word := range strings.Split(resp.Result.BlackWord, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			blackWords = append(blackWords, word)
+		}
+	}
+	return
+}
+func (t *YunpianInfo) GetSmsServiceProviderId() int {
+	return t.SmsServiceProviderId
+}
+
+func (t *YunpianInfo) GetSignName() string {
+	return t.SignName
+}
+
+// 批量发送相同内容和批量发送不同内容，一次都不要超过1000个手机号
+func (t *YunpianInfo) GetMaxMobiles() int {
+	return SMS_YUNPIAN_MAX_MOBILES
+}
+
+// 短信计费规则，未配置时采用默认规则
+func (t *YunpianInfo) GetSegmentRule() *SmsSegmentRule {
+	if t.SegmentRule == nil {
+		t.SegmentRule = newSmsSegmentRule(nil)
+	}
+	return t.SegmentRule
+}
+
+func (t *YunpianInfo) SingleSend(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	if req == nil || len(req.Mobiles) <= 0 {
+		err = errors.New("param `mobiles` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	result = &SmsResult{
+		SmsServiceProviderId: t.SmsServiceProviderId,
+	}
+	result.Count, result.Fee, result.MessageId, retcode, err = t.SendSingleSms(req.Content, req.Mobiles[0], req.ExtendCode)
+	result.MessageIds = map[string]string{
+		req.Mobiles[0]: result.MessageId,
+	}
+	result.CountPerSingle = result.Count
+	return
+}
+
+func (t *YunpianInfo) BatchSend(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	var (
+		msgids map[string]string
+	)
+	if req == nil || len(req.Mobiles) <= 0 {
+		err = errors.New("param `mobiles` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	result = &SmsResult{
+		SmsServiceProviderId: t.SmsServiceProviderId,
+	}
+	result.Count, result.Fee, msgids, result.FailedMobiles, retcode, err = t.SendBatchSms(req.Content, req.Mobiles, req.ExtendCode)
+	result.MessageIds = msgids
+	result.MessageId = getFirstSmsMessageId(req.Mobiles, msgids)
+	result.CountPerSingle = t.GetSegmentRule().Count(req.Content).Segments
+	return
+}
+
+func (t *YunpianInfo) MultiSend(req *SmsRequest) (result *SmsResult, retcode int, err error) {
+	var (
+		msgids map[string]string
+	)
+	if req == nil || len(req.Mobiles) <= 0 {
+		err = errors.New("param `mobiles` empty")
+		retcode = utils.SOURCE_DATA_ILLEGAL
+		return
+	}
+	result = &SmsResult{
+		SmsServiceProviderId: t.SmsServiceProviderId,
+	}
+	result.Count, result.Fee, msgids, result.FailedMobiles, retcode, err = t.SendMultiSms(req.Contents, req.Mobiles, req.ExtendCode)
+	result.MessageIds = msgids
+	result.MessageId = getFirstSmsMessageId(req.Mobiles, msgids)
+	for _, content := range req.Contents {
+		if segments := t.GetSegmentRule().Count(content).Segments; segments > result.CountPerSingle {
+			result.CountPerSingle = segments
+		}
+	}
+	return
+}
+
+// 查账户信息 https://sms.yunpian.com/v2/user/get.json
+// 云片网按金额计费，remainingCount返回账户余额，单位：分
+// @param accountType : 1.验证码短信使用普通发送apikey；2.营销短信使用群发apikey
+func (t *YunpianInfo) QueryBalance(accountType int16) (remainingCount int, retcode int, err error) {
+	Logger.Info("enter QueryBalance.")
+	defer Logger.Info("left QueryBalance.")
+	type UserInfo struct {
+		ApiKey string `json:"apikey"`
+	}
+	type UserRespInfo struct {
+		Balance float64 `json:"balance"` // 账户剩余金额，单位：元
+	}
+	var (
+		body, bodyData []byte
+		resp           *UserRespInfo = new(UserRespInfo)
+	)
+	userInfo := &UserInfo{
+		ApiKey: t.SingleApiKey,
+	}
+	if int(accountType) == SMS_CHUANGLAN_MARKETING_TYPE {
+		userInfo.ApiKey = t.GroupApiKey
+	}
+	body, _ = json.Marshal(*userInfo)
+	httpStr := fmt.Sprintf("https://sms.yunpian.com/v2/user/get.json")
+	if bodyData, err = httpRequest.HttpPostBody(httpStr, body); err != nil {
+		err = errors.Wrap(err, "QueryBalance")
+		retcode = utils.HTTP_CALL_FAILD_EXTERNAL
+		return
+	}
+	if err = jsoniter.Unmarshal(bodyData, resp); err != nil {
+		err = errors.Wrap(err, "QueryBalance")
+		retcode = utils.JSON_PARSE_FAILED
+		return
+	}
+	remainingCount = int(resp.Balance * 100)
 	return
 }
+
 func (t *YunpianInfo) getCheckStatus(code string) (status int) {
 	switch code {
 	case "CHECKING":
@@ -774,6 +1061,29 @@ func (t *YunpianInfo) getCheckStatus(code string) (status int) {
 	return
 }
 
+// 可重试的错误码：apikey、权限、频率、余额、系统繁忙等服务商侧原因，以及HTTP调用或者响应解析失败
+// 1/2参数错误、4关键词屏蔽、8/9/17/22重复或超限提交、10黑名单、20/23地区不支持、25号码内容不匹配等，不重试
+func (t *YunpianInfo) IsRetryableError(retcode int) bool {
+	switch retcode {
+	case utils.HTTP_CALL_FAILD_EXTERNAL, utils.JSON_PARSE_FAILED:
+		return true
+	case -1, -2, -3, -4, -5, -50, -51, -53, -57, 3, 5, 7, 13, 15, 16, 26, 27, 28, 33:
+		return true
+	}
+	return false
+}
+
+// -4: 访问次数超限；-5: 访问频率超限；-50: 未知异常；-51: 系统繁忙；-53: 提交短信失败；28: 运营商错误；33: 超过频率
+func (t *YunpianInfo) IsTransientError(retcode int) bool {
+	switch retcode {
+	case utils.HTTP_CALL_FAILD_EXTERNAL, utils.JSON_PARSE_FAILED:
+		return true
+	case -4, -5, -50, -51, -53, 28, 33:
+		return true
+	}
+	return false
+}
+
 func (t *YunpianInfo) getErrorMessage(errcode int) (err error) {
 	var (
 		message string
@@ -0,0 +1,207 @@
+package phone
+
+import (
+	"strings"
+)
+
+/*
+	手机号规范化与校验
+	>> 去掉空白、横线、括号和点，00前缀转换为+，统一为E.164格式(如：+8613800138000)
+	>> 中国大陆手机号：11位且以1开头，可带+86、0086、86前缀，按号段校验运营商
+	>> 国际号码：+国家码+号码，共8~15位数字，只校验格式
+	>> 批量处理时按E.164格式去重，返回每个被拒绝号码的原因
+*/
+
+// 运营商
+const (
+	CARRIER_CHINA_MOBILE   = "china_mobile"   // 中国移动
+	CARRIER_CHINA_UNICOM   = "china_unicom"   // 中国联通
+	CARRIER_CHINA_TELECOM  = "china_telecom"  // 中国电信
+	CARRIER_CHINA_BROADNET = "china_broadnet" // 中国广电
+	CARRIER_INTERNATIONAL  = "international"  // 国际号码，不区分运营商
+)
+
+// 号码被拒绝的原因
+const (
+	REJECT_EMPTY          = "empty"          // 号码为空
+	REJECT_INVALID_FORMAT = "invalid_format" // 含非法字符或者长度错误
+	REJECT_INVALID_PREFIX = "invalid_prefix" // 中国大陆号段不存在
+	REJECT_INTERNATIONAL  = "international"  // 不支持国际号码
+	REJECT_DUPLICATE      = "duplicate"      // 与前面的号码重复
+)
+
+const (
+	CHINA_COUNTRY_CODE = "86"
+)
+
+// 中国大陆号段(前3位)对应的运营商，含虚拟运营商号段
+var mainlandCarriers = map[string]string{
+	// 中国移动
+	"134": CARRIER_CHINA_MOBILE, "135": CARRIER_CHINA_MOBILE, "136": CARRIER_CHINA_MOBILE, "137": CARRIER_CHINA_MOBILE,
+	"138": CARRIER_CHINA_MOBILE, "139": CARRIER_CHINA_MOBILE, "147": CARRIER_CHINA_MOBILE, "148": CARRIER_CHINA_MOBILE,
+	"150": CARRIER_CHINA_MOBILE, "151": CARRIER_CHINA_MOBILE, "152": CARRIER_CHINA_MOBILE, "157": CARRIER_CHINA_MOBILE,
+	"158": CARRIER_CHINA_MOBILE, "159": CARRIER_CHINA_MOBILE, "165": CARRIER_CHINA_MOBILE, "172": CARRIER_CHINA_MOBILE,
+	"178": CARRIER_CHINA_MOBILE, "182": CARRIER_CHINA_MOBILE, "183": CARRIER_CHINA_MOBILE, "184": CARRIER_CHINA_MOBILE,
+	"187": CARRIER_CHINA_MOBILE, "188": CARRIER_CHINA_MOBILE, "195": CARRIER_CHINA_MOBILE, "197": CARRIER_CHINA_MOBILE,
+	"198": CARRIER_CHINA_MOBILE,
+	// 中国联通
+	"130": CARRIER_CHINA_UNICOM, "131": CARRIER_CHINA_UNICOM, "132": CARRIER_CHINA_UNICOM, "145": CARRIER_CHINA_UNICOM,
+	"146": CARRIER_CHINA_UNICOM, "155": CARRIER_CHINA_UNICOM, "156": CARRIER_CHINA_UNICOM, "166": CARRIER_CHINA_UNICOM,
+	"167": CARRIER_CHINA_UNICOM, "171": CARRIER_CHINA_UNICOM, "175": CARRIER_CHINA_UNICOM, "176": CARRIER_CHINA_UNICOM,
+	"185": CARRIER_CHINA_UNICOM, "186": CARRIER_CHINA_UNICOM, "196": CARRIER_CHINA_UNICOM,
+	// 中国电信
+	"133": CARRIER_CHINA_TELECOM, "149": CARRIER_CHINA_TELECOM, "153": CARRIER_CHINA_TELECOM, "162": CARRIER_CHINA_TELECOM,
+	"173": CARRIER_CHINA_TELECOM, "177": CARRIER_CHINA_TELECOM, "180": CARRIER_CHINA_TELECOM, "181": CARRIER_CHINA_TELECOM,
+	"189": CARRIER_CHINA_TELECOM, "190": CARRIER_CHINA_TELECOM, "191": CARRIER_CHINA_TELECOM, "193": CARRIER_CHINA_TELECOM,
+	"199": CARRIER_CHINA_TELECOM,
+	// 中国广电
+	"192": CARRIER_CHINA_BROADNET,
+}
+
+// 170号段按第4位区分运营商
+var mainland170Carriers = map[byte]string{
+	'0': CARRIER_CHINA_TELECOM, '1': CARRIER_CHINA_TELECOM, '2': CARRIER_CHINA_TELECOM,
+	'3': CARRIER_CHINA_MOBILE, '5': CARRIER_CHINA_MOBILE, '6': CARRIER_CHINA_MOBILE,
+	'4': CARRIER_CHINA_UNICOM, '7': CARRIER_CHINA_UNICOM, '8': CARRIER_CHINA_UNICOM, '9': CARRIER_CHINA_UNICOM,
+}
+
+// 规范化后的号码
+type Number struct {
+	Raw         string `json:"raw"`          // 原始号码
+	E164        string `json:"e164"`         // E.164格式，如：+8613800138000
+	CountryCode string `json:"country_code"` // 国家码，如：86；国际号码不解析国家码，为空
+	National    string `json:"national"`     // 国内号码，如：13800138000；国际号码为不含+的完整号码
+	Carrier     string `json:"carrier"`      // 运营商
+}
+
+// 是否为中国大陆手机号
+func (t *Number) IsMainland() bool {
+	return t.CountryCode == CHINA_COUNTRY_CODE
+}
+
+// 提交服务商的号码格式：中国大陆手机号为11位国内号码，国际号码为E.164格式
+func (t *Number) SendFormat() string {
+	if t.IsMainland() {
+		return t.National
+	}
+	return t.E164
+}
+
+// 被拒绝的号码
+type Rejection struct {
+	Mobile string `json:"mobile"`
+	Reason string `json:"reason"`
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for index := 0; index < len(value); index++ {
+		if value[index] < '0' || value[index] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 中国大陆手机号对应的运营商，号段不存在时返回空
+func GetMainlandCarrier(national string) string {
+	if len(national) != 11 || national[0] != '1' {
+		return ""
+	}
+	if strings.HasPrefix(national, "170") {
+		return mainland170Carriers[national[3]]
+	}
+	return mainlandCarriers[national[:3]]
+}
+
+// 规范化并校验单个号码，失败时返回拒绝原因
+func Parse(raw string) (number *Number, reason string) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return nil, REJECT_EMPTY
+	}
+	value = strings.NewReplacer(" ", "", "\t", "", "-", "", "(", "", ")", "", ".", "", " ", "").Replace(value)
+	if strings.HasPrefix(value, "00") {
+		value = "+" + value[2:]
+	}
+	international := strings.HasPrefix(value, "+")
+	value = strings.TrimPrefix(value, "+")
+	if !isDigits(value) {
+		return nil, REJECT_INVALID_FORMAT
+	}
+	// 中国大陆手机号：11位国内号码，或者86+11位国内号码
+	national := ""
+	switch {
+	case !international && len(value) == 11:
+		national = value
+	case len(value) == 13 && strings.HasPrefix(value, CHINA_COUNTRY_CODE):
+		national = value[2:]
+	}
+	if national != "" {
+		if national[0] != '1' {
+			return nil, REJECT_INVALID_FORMAT
+		}
+		carrier := GetMainlandCarrier(national)
+		if carrier == "" {
+			return nil, REJECT_INVALID_PREFIX
+		}
+		return &Number{
+			Raw:         raw,
+			E164:        "+" + CHINA_COUNTRY_CODE + national,
+			CountryCode: CHINA_COUNTRY_CODE,
+			National:    national,
+			Carrier:     carrier,
+		}, ""
+	}
+	// 国际号码：必须带+或者00前缀，国家码不以0开头，共8~15位数字
+	if !international || len(value) < 8 || len(value) > 15 || value[0] == '0' {
+		return nil, REJECT_INVALID_FORMAT
+	}
+	if strings.HasPrefix(value, CHINA_COUNTRY_CODE) {
+		// +86开头但不是11位手机号
+		return nil, REJECT_INVALID_FORMAT
+	}
+	return &Number{
+		Raw:         raw,
+		E164:        "+" + value,
+		CountryCode: "",
+		National:    value,
+		Carrier:     CARRIER_INTERNATIONAL,
+	}, ""
+}
+
+// 规范化为E.164格式
+func Normalize(raw string) (e164 string, reason string) {
+	number, reason := Parse(raw)
+	if number == nil {
+		return "", reason
+	}
+	return number.E164, ""
+}
+
+// 批量规范化、校验并去重，allowInternational为false时拒绝国际号码
+// numbers与原始号码顺序一致(去掉被拒绝的号码)，indexes为numbers中每个号码在原始号码中的下标
+func Clean(raws []string, allowInternational bool) (numbers []*Number, indexes []int, rejections []Rejection) {
+	exists := map[string]bool{}
+	for index, raw := range raws {
+		number, reason := Parse(raw)
+		if number == nil {
+			rejections = append(rejections, Rejection{Mobile: raw, Reason: reason})
+			continue
+		}
+		if !number.IsMainland() && !allowInternational {
+			rejections = append(rejections, Rejection{Mobile: raw, Reason: REJECT_INTERNATIONAL})
+			continue
+		}
+		if exists[number.E164] {
+			rejections = append(rejections, Rejection{Mobile: raw, Reason: REJECT_DUPLICATE})
+			continue
+		}
+		exists[number.E164] = true
+		numbers = append(numbers, number)
+		indexes = append(indexes, index)
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package phone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		raw     string
+		e164    string
+		carrier string
+		reason  string
+	}{
+		{"13800138000", "+8613800138000", CARRIER_CHINA_MOBILE, ""},
+		{" 138-0013-8000 ", "+8613800138000", CARRIER_CHINA_MOBILE, ""},
+		{"(138) 0013.8000", "+8613800138000", CARRIER_CHINA_MOBILE, ""},
+		{"+86 13800138000", "+8613800138000", CARRIER_CHINA_MOBILE, ""},
+		{"008613800138000", "+8613800138000", CARRIER_CHINA_MOBILE, ""},
+		{"8613800138000", "+8613800138000", CARRIER_CHINA_MOBILE, ""},
+		{"18600000000", "+8618600000000", CARRIER_CHINA_UNICOM, ""},
+		{"19900000000", "+8619900000000", CARRIER_CHINA_TELECOM, ""},
+		{"19200000000", "+8619200000000", CARRIER_CHINA_BROADNET, ""},
+		{"17030000000", "+8617030000000", CARRIER_CHINA_MOBILE, ""},
+		{"17090000000", "+8617090000000", CARRIER_CHINA_UNICOM, ""},
+		{"+14155552671", "+14155552671", CARRIER_INTERNATIONAL, ""},
+		{"0044 20 7946 0958", "+442079460958", CARRIER_INTERNATIONAL, ""},
+		{"", "", "", REJECT_EMPTY},
+		{"   ", "", "", REJECT_EMPTY},
+		{"1380013800a", "", "", REJECT_INVALID_FORMAT},
+		{"1380013800", "", "", REJECT_INVALID_FORMAT},
+		{"23800138000", "", "", REJECT_INVALID_FORMAT},
+		{"12000000000", "", "", REJECT_INVALID_PREFIX},
+		{"17400000000", "", "", REJECT_INVALID_PREFIX},
+		{"14155552671", "", "", REJECT_INVALID_PREFIX},
+		{"+861380013800", "", "", REJECT_INVALID_FORMAT},
+		{"+1234567", "", "", REJECT_INVALID_FORMAT},
+		{"+1234567890123456", "", "", REJECT_INVALID_FORMAT},
+		{"+0123456789", "", "", REJECT_INVALID_FORMAT},
+	}
+	for _, c := range cases {
+		number, reason := Parse(c.raw)
+		if reason != c.reason {
+			t.Errorf("Parse(%q) reason = %q, expected %q", c.raw, reason, c.reason)
+			continue
+		}
+		if c.reason != "" {
+			if number != nil {
+				t.Errorf("Parse(%q) = %+v, expected nil", c.raw, number)
+			}
+			continue
+		}
+		if number.E164 != c.e164 || number.Carrier != c.carrier || number.Raw != c.raw {
+			t.Errorf("Parse(%q) = %+v, expected e164 %q carrier %q", c.raw, number, c.e164, c.carrier)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	raws := []string{"13800138000", "+86 138 0013 8000", "bad", "+14155552671", "18600000000", ""}
+	cases := []struct {
+		name               string
+		allowInternational bool
+		e164s              []string
+		indexes            []int
+		rejections         []Rejection
+	}{
+		{
+			"不允许国际号码", false,
+			[]string{"+8613800138000", "+8618600000000"},
+			[]int{0, 4},
+			[]Rejection{
+				{"+86 138 0013 8000", REJECT_DUPLICATE},
+				{"bad", REJECT_INVALID_FORMAT},
+				{"+14155552671", REJECT_INTERNATIONAL},
+				{"", REJECT_EMPTY},
+			},
+		},
+		{
+			"允许国际号码", true,
+			[]string{"+8613800138000", "+14155552671", "+8618600000000"},
+			[]int{0, 3, 4},
+			[]Rejection{
+				{"+86 138 0013 8000", REJECT_DUPLICATE},
+				{"bad", REJECT_INVALID_FORMAT},
+				{"", REJECT_EMPTY},
+			},
+		},
+	}
+	for _, c := range cases {
+		numbers, indexes, rejections := Clean(raws, c.allowInternational)
+		e164s := []string{}
+		for _, number := range numbers {
+			e164s = append(e164s, number.E164)
+		}
+		if !reflect.DeepEqual(e164s, c.e164s) {
+			t.Errorf("%s: numbers = %v, expected %v", c.name, e164s, c.e164s)
+		}
+		if !reflect.DeepEqual(indexes, c.indexes) {
+			t.Errorf("%s: indexes = %v, expected %v", c.name, indexes, c.indexes)
+		}
+		if !reflect.DeepEqual(rejections, c.rejections) {
+			t.Errorf("%s: rejections = %v, expected %v", c.name, rejections, c.rejections)
+		}
+	}
+}
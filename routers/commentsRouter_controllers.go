@@ -8,18 +8,81 @@ func init() {
 
 	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:ChuanglanSmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:ChuanglanSmsController"],
 		beego.ControllerComments{
-			Method: "ReceivedNotification",
-			Router: `/callback`,
+			Method: "QueryBalance",
+			Router: `/querybalance`,
 			AllowHTTPMethods: []string{"GET"},
 			Params: nil})
 
-	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:ChuanglanSmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:ChuanglanSmsController"],
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsBlacklistsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsBlacklistsController"],
 		beego.ControllerComments{
-			Method: "QueryBalance",
-			Router: `/querybalance`,
+			Method: "GetBlacklists",
+			Router: `/blacklists`,
 			AllowHTTPMethods: []string{"GET"},
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsBlacklistsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsBlacklistsController"],
+		beego.ControllerComments{
+			Method: "AddBlacklists",
+			Router: `/blacklists`,
+			AllowHTTPMethods: []string{"POST"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsBlacklistsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsBlacklistsController"],
+		beego.ControllerComments{
+			Method: "DeleteBlacklist",
+			Router: `/blacklists/:mobile`,
+			AllowHTTPMethods: []string{"DELETE"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "AddCampaign",
+			Router: `/campaigns`,
+			AllowHTTPMethods: []string{"POST"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "GetCampaigns",
+			Router: `/campaigns`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "GetCampaign",
+			Router: `/campaigns/:id`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "GetCampaignRecipients",
+			Router: `/campaigns/:id/recipients`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "PauseCampaign",
+			Router: `/campaigns/:id/pause`,
+			AllowHTTPMethods: []string{"PUT"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "ResumeCampaign",
+			Router: `/campaigns/:id/resume`,
+			AllowHTTPMethods: []string{"PUT"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsCampaignsController"],
+		beego.ControllerComments{
+			Method: "CancelCampaign",
+			Router: `/campaigns/:id/cancel`,
+			AllowHTTPMethods: []string{"PUT"},
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
 		beego.ControllerComments{
 			Method: "MobileVerificationCode",
@@ -34,6 +97,76 @@ func init() {
 			AllowHTTPMethods: []string{"POST"},
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
+		beego.ControllerComments{
+			Method: "GetMessage",
+			Router: `/messages/:id`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
+		beego.ControllerComments{
+			Method: "GetScheduledMessages",
+			Router: `/scheduled_messages`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
+		beego.ControllerComments{
+			Method: "CancelMessage",
+			Router: `/messages/:id`,
+			AllowHTTPMethods: []string{"DELETE"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
+		beego.ControllerComments{
+			Method: "EstimateSms",
+			Router: `/estimate`,
+			AllowHTTPMethods: []string{"POST"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
+		beego.ControllerComments{
+			Method: "VerifyMobileVerificationCode",
+			Router: `/mobile_verification_code/verify`,
+			AllowHTTPMethods: []string{"post"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsController"],
+		beego.ControllerComments{
+			Method: "SendTemplateSms",
+			Router: `/template`,
+			AllowHTTPMethods: []string{"POST"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsInboundMessagesController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsInboundMessagesController"],
+		beego.ControllerComments{
+			Method: "GetInboundMessages",
+			Router: `/inbound_messages`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsOptOutsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsOptOutsController"],
+		beego.ControllerComments{
+			Method: "GetOptOuts",
+			Router: `/opt_outs`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsOptOutsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsOptOutsController"],
+		beego.ControllerComments{
+			Method: "AddOptOuts",
+			Router: `/opt_outs`,
+			AllowHTTPMethods: []string{"POST"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsOptOutsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsOptOutsController"],
+		beego.ControllerComments{
+			Method: "DeleteOptOut",
+			Router: `/opt_outs/:mobile`,
+			AllowHTTPMethods: []string{"DELETE"},
+			Params: nil})
+
 	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRechargeRecordsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRechargeRecordsController"],
 		beego.ControllerComments{
 			Method: "SmsRecharge",
@@ -41,11 +174,95 @@ func init() {
 			AllowHTTPMethods: []string{"POST"},
 			Params: nil})
 
-	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:YunpianSmsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:YunpianSmsController"],
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"],
+		beego.ControllerComments{
+			Method: "GetRoutingRules",
+			Router: `/routing_rules`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"],
 		beego.ControllerComments{
-			Method: "ReceivedNotification",
-			Router: `/yunpian/callback`,
+			Method: "AddRoutingRule",
+			Router: `/routing_rules`,
 			AllowHTTPMethods: []string{"POST"},
 			Params: nil})
 
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"],
+		beego.ControllerComments{
+			Method: "ModifyRoutingRule",
+			Router: `/routing_rules/:id`,
+			AllowHTTPMethods: []string{"PUT"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsRoutingRulesController"],
+		beego.ControllerComments{
+			Method: "DeleteRoutingRule",
+			Router: `/routing_rules/:id`,
+			AllowHTTPMethods: []string{"DELETE"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSendPeriodsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSendPeriodsController"],
+		beego.ControllerComments{
+			Method: "GetSendPeriods",
+			Router: `/send_periods`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSendPeriodsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSendPeriodsController"],
+		beego.ControllerComments{
+			Method: "SetSendPeriod",
+			Router: `/send_periods`,
+			AllowHTTPMethods: []string{"PUT"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSendPeriodsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSendPeriodsController"],
+		beego.ControllerComments{
+			Method: "DeleteSendPeriod",
+			Router: `/send_periods/:id`,
+			AllowHTTPMethods: []string{"DELETE"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSensitiveWordsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSensitiveWordsController"],
+		beego.ControllerComments{
+			Method: "GetSensitiveWords",
+			Router: `/sensitive_words`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSensitiveWordsController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsSensitiveWordsController"],
+		beego.ControllerComments{
+			Method: "CheckSensitiveWords",
+			Router: `/sensitive_words/check`,
+			AllowHTTPMethods: []string{"POST"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"],
+		beego.ControllerComments{
+			Method: "GetProvidersHealth",
+			Router: `/providers/health`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"],
+		beego.ControllerComments{
+			Method: "ReceiptCallback",
+			Router: `/providers/:code/callback`,
+			AllowHTTPMethods: []string{"get", "post"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"],
+		beego.ControllerComments{
+			Method: "GetCallbackRejections",
+			Router: `/providers/callback_rejections`,
+			AllowHTTPMethods: []string{"GET"},
+			Params: nil})
+
+	beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"] = append(beego.GlobalControllerRouter["github.com/1046102779/sms/controllers:SmsServiceProvidersController"],
+		beego.ControllerComments{
+			Method: "InboundCallback",
+			Router: `/providers/:code/inbound`,
+			AllowHTTPMethods: []string{"get", "post"},
+			Params: nil})
+
 }
@@ -18,8 +18,16 @@ func init() {
 		beego.NSNamespace("/sms",
 			beego.NSInclude(
 				&controllers.SmsReceiptFailedRecordsController{},
+				&controllers.SmsBlacklistsController{},
 				&controllers.SmsController{},
+				&controllers.SmsInboundMessagesController{},
+				&controllers.SmsOptOutsController{},
+				&controllers.SmsCampaignsController{},
 				&controllers.SmsRechargeRecordsController{},
+				&controllers.SmsRoutingRulesController{},
+				&controllers.SmsSendPeriodsController{},
+				&controllers.SmsSensitiveWordsController{},
+				&controllers.SmsServiceProvidersController{},
 			),
 		),
 		beego.NSNamespace("/sms/chuanglan",